@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus-community/windows_exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+const (
+	scrapeModeOnDemand   = "on-demand"
+	scrapeModeBackground = "background"
+)
+
+var (
+	snapshotAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "snapshot_age_seconds"),
+		"windows_exporter: Time elapsed since the served snapshot of collector metrics was completed.",
+		nil,
+		nil,
+	)
+	snapshotTimestampDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "snapshot_timestamp_seconds"),
+		"windows_exporter: Unix time at which the served snapshot of collector metrics was completed.",
+		nil,
+		nil,
+	)
+	snapshotDurationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "snapshot_duration_seconds"),
+		"windows_exporter: Duration of the background scrape which produced the served snapshot.",
+		nil,
+		nil,
+	)
+)
+
+// snapshot holds the metrics of a completed background scrape, by collector
+// name. Exporter-wide metrics are stored under the empty name.
+type snapshot struct {
+	metrics   map[string][]prometheus.Metric
+	completed time.Time
+	duration  time.Duration
+}
+
+// backgroundScraper runs the enabled collectors on a fixed interval, and keeps
+// the metrics of the most recent completed run.
+type backgroundScraper struct {
+	// newCollector returns the collector of a single run, as the enabled
+	// collectors change on configuration reloads.
+	newCollector func() windowsCollector
+	interval     time.Duration
+
+	mtx  sync.RWMutex
+	last *snapshot
+}
+
+func newBackgroundScraper(newCollector func() windowsCollector, interval time.Duration) *backgroundScraper {
+	return &backgroundScraper{
+		newCollector: newCollector,
+		interval:     interval,
+	}
+}
+
+// run scrapes immediately, then on every interval until stop is closed.
+func (b *backgroundScraper) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		b.scrape()
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (b *backgroundScraper) scrape() {
+	start := time.Now()
+	metrics := make(map[string][]prometheus.Metric)
+	b.newCollector().collect(func(name string, m prometheus.Metric) {
+		metrics[name] = append(metrics[name], m)
+	})
+	s := &snapshot{
+		metrics:   metrics,
+		completed: time.Now(),
+		duration:  time.Since(start),
+	}
+	log.Debugf("background scrape completed after %fs", s.duration.Seconds())
+
+	b.mtx.Lock()
+	b.last = s
+	b.mtx.Unlock()
+}
+
+// snapshotCollector returns a prometheus.Collector serving the most recent
+// snapshot, restricted to the requested collectors, or all if none is given.
+func (b *backgroundScraper) snapshotCollector(requestedCollectors []string) (error, prometheus.Collector) {
+	enabled := b.newCollector().collectors
+	collectors := make(map[string]bool, len(requestedCollectors))
+	for _, name := range requestedCollectors {
+		if _, exists := enabled[name]; !exists {
+			return fmt.Errorf("unavailable collector: %s", name), nil
+		}
+		collectors[name] = true
+	}
+	b.mtx.RLock()
+	s := b.last
+	b.mtx.RUnlock()
+	return nil, &snapshotCollector{snapshot: s, collectors: collectors}
+}
+
+type snapshotCollector struct {
+	snapshot *snapshot
+	// The collectors to serve metrics for, all if empty.
+	collectors map[string]bool
+}
+
+func (c *snapshotCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- snapshotAgeDesc
+	ch <- snapshotTimestampDesc
+	ch <- snapshotDurationDesc
+}
+
+// Collect sends the metrics of the snapshot. Until the first background scrape
+// completes there is none, and only the metrics of the exporter registered
+// next to c are served.
+func (c *snapshotCollector) Collect(ch chan<- prometheus.Metric) {
+	if c.snapshot == nil {
+		log.Debugf("No completed background scrape yet, serving no collector metrics")
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		snapshotAgeDesc,
+		prometheus.GaugeValue,
+		time.Since(c.snapshot.completed).Seconds(),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		snapshotTimestampDesc,
+		prometheus.GaugeValue,
+		float64(c.snapshot.completed.UnixNano())/1e9,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		snapshotDurationDesc,
+		prometheus.GaugeValue,
+		c.snapshot.duration.Seconds(),
+	)
+
+	for name, metrics := range c.snapshot.metrics {
+		if name != "" && len(c.collectors) > 0 && !c.collectors[name] {
+			continue
+		}
+		for _, m := range metrics {
+			ch <- m
+		}
+	}
+}
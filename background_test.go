@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus-community/windows_exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSnapshotCollector(t *testing.T) {
+	desc := prometheus.NewDesc("test_metric", "", []string{"collector"}, nil)
+	metric := func(name string) prometheus.Metric {
+		return prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, name)
+	}
+	b := newBackgroundScraper(func() windowsCollector {
+		return windowsCollector{
+			collectors: map[string]collector.Collector{"cpu": nil, "os": nil},
+		}
+	}, time.Minute)
+
+	err, c := b.snapshotCollector(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("expected no error before the first background scrape completed, got %v", err)
+	}
+	if len(mfs) != 0 {
+		t.Errorf("expected no metrics before the first background scrape completed, got %v", mfs)
+	}
+
+	b.last = &snapshot{
+		metrics: map[string][]prometheus.Metric{
+			"":    {metric("")},
+			"cpu": {metric("cpu")},
+			"os":  {metric("os")},
+		},
+		completed: time.Now(),
+	}
+
+	cases := []struct {
+		requested []string
+		expected  int
+	}{
+		{nil, 3},
+		{[]string{"cpu"}, 2},
+		{[]string{"cpu", "cpu"}, 2},
+		{[]string{"cpu", "os"}, 3},
+	}
+	for _, tc := range cases {
+		err, c := b.snapshotCollector(tc.requested)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(c)
+		mfs, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, mf := range mfs {
+			if mf.GetName() == "test_metric" && len(mf.GetMetric()) != tc.expected {
+				t.Errorf("requested %v: expected %d metrics, got %d", tc.requested, tc.expected, len(mf.GetMetric()))
+			}
+		}
+	}
+
+	if err, _ := b.snapshotCollector([]string{"iis"}); err == nil {
+		t.Error("expected an error for a collector which is not enabled")
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus-community/windows_exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	cacheHitsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "collector_cache_hits_total"),
+		"windows_exporter: Number of scrapes served the cached metrics of the collector instead of running it.",
+		[]string{"collector"},
+		nil,
+	)
+	cacheMissesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "collector_cache_misses_total"),
+		"windows_exporter: Number of scrapes which ran the collector, because its cached metrics were missing or expired.",
+		[]string{"collector"},
+		nil,
+	)
+	cacheStaleDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "collector_cache_stale"),
+		"windows_exporter: Whether the served metrics of the collector are expired cached values, because the run failed or timed out.",
+		[]string{"collector"},
+		nil,
+	)
+	cacheAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "collector_cache_age_seconds"),
+		"windows_exporter: Time elapsed since the served metrics of the collector were collected.",
+		[]string{"collector"},
+		nil,
+	)
+)
+
+type cacheEntry struct {
+	metrics   []prometheus.Metric
+	collected time.Time
+}
+
+// collectorCache keeps the metrics of the last successful run of collectors
+// with a cache TTL, shared between scrapes.
+type collectorCache struct {
+	ttls map[string]time.Duration
+
+	mtx     sync.Mutex
+	entries map[string]cacheEntry
+	hits    map[string]float64
+	misses  map[string]float64
+}
+
+func newCollectorCache(ttls map[string]time.Duration) *collectorCache {
+	return &collectorCache{
+		ttls:    ttls,
+		entries: make(map[string]cacheEntry),
+		hits:    make(map[string]float64),
+		misses:  make(map[string]float64),
+	}
+}
+
+// enabled reports whether the metrics of the named collector are cached.
+func (c *collectorCache) enabled(name string) bool {
+	return c != nil && c.ttls[name] > 0
+}
+
+// fresh returns the cached metrics of the named collector if they are younger
+// than its TTL, and counts the lookup as a hit or miss.
+func (c *collectorCache) fresh(name string, now time.Time) (cacheEntry, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	e, ok := c.entries[name]
+	if ok && now.Sub(e.collected) < c.ttls[name] {
+		c.hits[name]++
+		return e, true
+	}
+	c.misses[name]++
+	return cacheEntry{}, false
+}
+
+// stale returns the cached metrics of the named collector regardless of their
+// age.
+func (c *collectorCache) stale(name string) (cacheEntry, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	e, ok := c.entries[name]
+	return e, ok
+}
+
+func (c *collectorCache) store(name string, metrics []prometheus.Metric, collected time.Time) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.entries[name] = cacheEntry{metrics: metrics, collected: collected}
+}
+
+// counters returns the number of hits and misses of the named collector.
+func (c *collectorCache) counters(name string) (float64, float64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.hits[name], c.misses[name]
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus-community/windows_exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var testValueDesc = prometheus.NewDesc("test_value", "", nil, nil)
+
+// countingCollector emits its number of runs, or fails if err is set.
+type countingCollector struct {
+	runs int
+	err  error
+}
+
+func (c *countingCollector) Collect(ctx *collector.ScrapeContext, ch chan<- prometheus.Metric) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.runs++
+	ch <- prometheus.MustNewConstMetric(testValueDesc, prometheus.GaugeValue, float64(c.runs))
+	return nil
+}
+
+// scrapeValues runs a scrape and returns the value of every gauge by metric
+// name.
+func scrapeValues(t *testing.T, coll windowsCollector) map[string]float64 {
+	t.Helper()
+	values := make(map[string]float64)
+	coll.collect(func(_ string, m prometheus.Metric) {
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			t.Fatal(err)
+		}
+		name := m.Desc().String()
+		switch {
+		case pb.Gauge != nil:
+			values[name] = pb.Gauge.GetValue()
+		case pb.Counter != nil:
+			values[name] = pb.Counter.GetValue()
+		}
+	})
+	return values
+}
+
+func TestCollectorCache(t *testing.T) {
+	defaultSource := collector.DefaultPerflibSource
+	collector.DefaultPerflibSource = collector.NewFixturePerflibSource()
+	defer func() { collector.DefaultPerflibSource = defaultSource }()
+
+	c := &countingCollector{}
+	cache := newCollectorCache(map[string]time.Duration{"test": time.Hour})
+	coll := windowsCollector{
+		maxScrapeDuration: time.Minute,
+		collectors:        map[string]collector.Collector{"test": c},
+		runs:              newCollectorRuns(),
+		cache:             cache,
+	}
+	value := testValueDesc.String()
+	stale := cacheStaleDesc.String()
+	hits := cacheHitsDesc.String()
+	misses := cacheMissesDesc.String()
+
+	got := scrapeValues(t, coll)
+	if got[value] != 1 || got[misses] != 1 || got[hits] != 0 || got[stale] != 0 {
+		t.Errorf("unexpected first scrape %v", got)
+	}
+
+	got = scrapeValues(t, coll)
+	if c.runs != 1 || got[value] != 1 || got[hits] != 1 || got[stale] != 0 {
+		t.Errorf("expected the cached value to be served, got %v after %d runs", got, c.runs)
+	}
+
+	// Expire the entry and fail the next run.
+	e, _ := cache.stale("test")
+	cache.store("test", e.metrics, e.collected.Add(-2*time.Hour))
+	c.err = errors.New("failed")
+	got = scrapeValues(t, coll)
+	if got[value] != 1 || got[misses] != 2 || got[stale] != 1 || got[scrapeSuccessDesc.String()] != 0 {
+		t.Errorf("expected the stale value to be served after a failure, got %v", got)
+	}
+}
+
+// TestCollectorCacheConcurrent serves a fresh cached collector while other
+// collectors forward their metrics. Run with -race.
+func TestCollectorCacheConcurrent(t *testing.T) {
+	defaultSource := collector.DefaultPerflibSource
+	collector.DefaultPerflibSource = collector.NewFixturePerflibSource()
+	defer func() { collector.DefaultPerflibSource = defaultSource }()
+
+	collectors := map[string]collector.Collector{"cached": &countingCollector{}}
+	for i := 0; i < 10; i++ {
+		collectors[fmt.Sprintf("immediate%d", i)] = &countingCollector{}
+	}
+	coll := windowsCollector{
+		maxScrapeDuration: time.Minute,
+		collectors:        collectors,
+		runs:              newCollectorRuns(),
+		cache:             newCollectorCache(map[string]time.Duration{"cached": time.Hour}),
+	}
+	for i := 0; i < 20; i++ {
+		got := scrapeValues(t, coll)
+		if got[scrapeSuccessDesc.String()] != 1 {
+			t.Fatalf("expected all collectors to succeed, got %v", got)
+		}
+	}
+}
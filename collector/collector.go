@@ -1,15 +1,17 @@
 package collector
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
-	"github.com/leoluk/perflib_exporter/perflib"
+	"github.com/prometheus-community/windows_exporter/perflib"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
-	"golang.org/x/sys/windows/registry"
 )
 
 // ...
@@ -24,8 +26,9 @@ const (
 
 // getWindowsVersion reads the version number of the OS from the Registry
 // See https://docs.microsoft.com/en-us/windows/desktop/sysinfo/operating-system-version
-func getWindowsVersion() float64 {
-	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Windows NT\CurrentVersion`, registry.QUERY_VALUE)
+// It is a variable so tests can pin the version collectors are built for.
+var getWindowsVersion = func() float64 {
+	k, err := openLocalMachineKey(`SOFTWARE\Microsoft\Windows NT\CurrentVersion`)
 	if err != nil {
 		log.Warn("Couldn't open registry", err)
 		return 0
@@ -37,7 +40,7 @@ func getWindowsVersion() float64 {
 		}
 	}()
 
-	currentv, _, err := k.GetStringValue("CurrentVersion")
+	currentv, err := k.GetStringValue("CurrentVersion")
 	if err != nil {
 		log.Warn("Couldn't open registry to determine current Windows version:", err)
 		return 0
@@ -50,23 +53,47 @@ func getWindowsVersion() float64 {
 	return currentv_flt
 }
 
-type collectorBuilder func() (Collector, error)
+type collectorBuilder func(settings Settings) (Collector, error)
 
 var (
-	builders                = make(map[string]collectorBuilder)
-	perfCounterDependencies = make(map[string]string)
+	builders = make(map[string]collectorBuilder)
+	// perfCounterDependencies is updated when collectors are built, which
+	// happens concurrently to scrapes on configuration reloads.
+	perfCounterDependenciesMtx sync.RWMutex
+	perfCounterDependencies    = make(map[string]string)
 )
 
-func registerCollector(name string, builder collectorBuilder, perfCounterNames ...string) {
+func registerCollector(name string, builder func() (Collector, error), perfCounterNames ...string) {
+	registerConfigurableCollector(name, func(Settings) (Collector, error) { return builder() }, perfCounterNames...)
+}
+
+// registerConfigurableCollector registers a collector whose builder reads
+// flags from Settings.
+func registerConfigurableCollector(name string, builder collectorBuilder, perfCounterNames ...string) {
 	builders[name] = builder
-	addPerfCounterDependencies(name, perfCounterNames)
+	addPerfCounterDependencies(name, perfCounterNames, false)
 }
 
-func addPerfCounterDependencies(name string, perfCounterNames []string) {
+// addPerfCounterDependencies sets the perflib objects read for the named
+// collector. With merge, they add to those of the instances built before.
+func addPerfCounterDependencies(name string, perfCounterNames []string, merge bool) {
 	perfIndicies := make([]string, 0, len(perfCounterNames))
 	for _, cn := range perfCounterNames {
 		perfIndicies = append(perfIndicies, MapCounterToIndex(cn))
 	}
+	perfCounterDependenciesMtx.Lock()
+	defer perfCounterDependenciesMtx.Unlock()
+	if merge {
+		for _, index := range strings.Fields(perfCounterDependencies[name]) {
+			found := false
+			for _, i := range perfIndicies {
+				found = found || i == index
+			}
+			if !found {
+				perfIndicies = append(perfIndicies, index)
+			}
+		}
+	}
 	perfCounterDependencies[name] = strings.Join(perfIndicies, " ")
 }
 
@@ -77,14 +104,86 @@ func Available() []string {
 	}
 	return cs
 }
-func Build(collector string) (Collector, error) {
+func Build(collector string, settings Settings) (Collector, error) {
+	return Rebuild(collector, settings, nil)
+}
+
+// builtCollector is a collector built by Rebuild, along with what its
+// builder read.
+type builtCollector struct {
+	Collector
+	name  string
+	merge bool
+	*buildRecord
+}
+
+// Rebuild builds the named collector, unless prev, a collector of the same
+// name built by Build or Rebuild, was built with the same values of the
+// flags its builder read, in which case prev is returned. Collectors keep
+// their state across configuration reloads this way. What builders read
+// from elsewhere, such as the SQL Server instances found in the Registry, is
+// only read again for the collectors which are built again.
+func Rebuild(collector string, settings Settings, prev Collector) (Collector, error) {
 	builder, exists := builders[collector]
 	if !exists {
 		return nil, fmt.Errorf("Unknown collector %q", collector)
 	}
-	return builder()
+	if b, ok := prev.(*builtCollector); ok && b.name == collector && b.merge == settings.MergePerfCounterDependencies && b.unchanged(settings) {
+		if b.hasPerfCounters {
+			addPerfCounterDependencies(collector, b.perfCounters, settings.MergePerfCounterDependencies)
+		}
+		return b, nil
+	}
+	record := &buildRecord{flags: make(map[setting]string)}
+	settings.record = record
+	c, err := builder(settings)
+	if err != nil {
+		return nil, err
+	}
+	return &builtCollector{
+		Collector:   c,
+		name:        collector,
+		merge:       settings.MergePerfCounterDependencies,
+		buildRecord: record,
+	}, nil
+}
+
+// listing prints a list a flag of a collector asks for, such as the classes
+// of the mssql collector.
+type listing struct {
+	collector string
+	flag      setting
+	print     func(w io.Writer)
+}
+
+var listings []listing
+
+// registerListing registers a list printed by PrintListings if flag is set.
+func registerListing(collector string, flag setting, print func(w io.Writer)) {
+	listings = append(listings, listing{collector: collector, flag: flag, print: print})
+	sort.Slice(listings, func(i, j int) bool { return listings[i].flag.name < listings[j].flag.name })
+}
+
+// PrintListings prints the lists asked for by the flags of the enabled
+// collectors in settings, and reports whether there was any. The exporter
+// exits after printing them, so they are only looked for at startup.
+func PrintListings(enabled []string, settings Settings, w io.Writer) bool {
+	isEnabled := make(map[string]bool, len(enabled))
+	for _, name := range enabled {
+		isEnabled[name] = true
+	}
+	printed := false
+	for _, l := range listings {
+		if isEnabled[l.collector] && settings.Bool(l.flag) {
+			l.print(w)
+			printed = true
+		}
+	}
+	return printed
 }
 func getPerfQuery(collectors []string) string {
+	perfCounterDependenciesMtx.RLock()
+	defer perfCounterDependenciesMtx.RUnlock()
 	parts := make([]string, 0, len(collectors))
 	for _, c := range collectors {
 		if p := perfCounterDependencies[c]; p != "" {
@@ -101,18 +200,64 @@ type Collector interface {
 }
 
 type ScrapeContext struct {
+	ctx         context.Context
 	perfObjects map[string]*perflib.PerfObject
+	// wmi answers the queries of collectors from baseWMI, and fails once ctx
+	// is done.
+	wmi     WMIQuerier
+	baseWMI WMIQuerier
+}
+
+// Context returns the context of the scrape. Collectors running several
+// queries or reading several sources stop once it is done.
+func (s *ScrapeContext) Context() context.Context {
+	return s.ctx
+}
+
+// WithContext returns a copy of the ScrapeContext bound to ctx, sharing the
+// perflib snapshot of the original.
+func (s *ScrapeContext) WithContext(ctx context.Context) *ScrapeContext {
+	return &ScrapeContext{
+		ctx:         ctx,
+		perfObjects: s.perfObjects,
+		wmi:         contextWMIQuerier{ctx: ctx, querier: s.baseWMI},
+		baseWMI:     s.baseWMI,
+	}
 }
 
-// PrepareScrapeContext creates a ScrapeContext to be used during a single scrape
-func PrepareScrapeContext(collectors []string) (*ScrapeContext, error) {
+// WithWMIQuerier returns a copy of the ScrapeContext whose WMI queries are
+// answered by wmiQuerier, as long as its context is not done.
+func (s *ScrapeContext) WithWMIQuerier(wmiQuerier WMIQuerier) *ScrapeContext {
+	return &ScrapeContext{
+		ctx:         s.ctx,
+		perfObjects: s.perfObjects,
+		wmi:         contextWMIQuerier{ctx: s.ctx, querier: wmiQuerier},
+		baseWMI:     wmiQuerier,
+	}
+}
+
+var (
+	// DefaultPerflibSource reads perflib objects from the local registry.
+	DefaultPerflibSource PerflibSource = windowsPerflibSource{}
+	// DefaultWMIQuerier runs WMI queries against the local WMI service.
+	DefaultWMIQuerier WMIQuerier = windowsWMIQuerier{}
+)
+
+// PrepareScrapeContext creates a ScrapeContext to be used during a single scrape.
+// The perflib snapshot is taken from perflibSource, and WMI queries issued by
+// the collectors are answered by wmiQuerier as long as ctx is not done.
+func PrepareScrapeContext(ctx context.Context, collectors []string, perflibSource PerflibSource, wmiQuerier WMIQuerier) (*ScrapeContext, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	q := getPerfQuery(collectors) // TODO: Memoize
-	objs, err := getPerflibSnapshot(q)
+	objs, err := perflibSource.Snapshot(ctx, q)
 	if err != nil {
 		return nil, err
 	}
 
-	return &ScrapeContext{objs}, nil
+	s := &ScrapeContext{perfObjects: objs, baseWMI: wmiQuerier}
+	return s.WithContext(ctx), nil
 }
 func boolToFloat(b bool) float64 {
 	if b {
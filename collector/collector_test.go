@@ -1,7 +1,9 @@
 package collector
 
 import (
+	"bytes"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -32,3 +34,51 @@ func TestExpandChildCollectors(t *testing.T) {
 		})
 	}
 }
+
+func TestRebuild(t *testing.T) {
+	settings := Settings{Flags: map[string]string{"collector.service.services-where": "Name='Dhcp'"}}
+	c, err := Build("service", settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings.Flags["collector.textfile.directory"] = "C:\\textfile"
+	kept, err := Rebuild("service", settings, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kept != c {
+		t.Error("expected the collector to be kept when the flags it reads are unchanged")
+	}
+
+	settings = Settings{Flags: map[string]string{"collector.service.services-where": "Name='Dnscache'"}}
+	rebuilt, err := Rebuild("service", settings, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rebuilt == c {
+		t.Error("expected the collector to be built again when a flag it reads changed")
+	}
+
+	rebuilt, err = Rebuild("service", Settings{Flags: map[string]string{}}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rebuilt == c {
+		t.Error("expected the collector to be built again when a flag it reads went back to its default")
+	}
+}
+
+func TestPrintListings(t *testing.T) {
+	settings := Settings{Flags: map[string]string{"collectors.mssql.class-print": "true"}}
+	var b bytes.Buffer
+	if PrintListings([]string{"cpu"}, settings, &b) || b.Len() != 0 {
+		t.Errorf("expected nothing printed for a disabled collector, got %q", b.String())
+	}
+	if !PrintListings([]string{"cpu", "mssql"}, settings, &b) {
+		t.Fatal("expected the mssql classes to be printed")
+	}
+	if !strings.Contains(b.String(), " - accessmethods\n") {
+		t.Errorf("expected the mssql classes, got %q", b.String())
+	}
+}
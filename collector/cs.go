@@ -1,11 +1,8 @@
-// +build windows
-
 package collector
 
 import (
 	"errors"
 
-	"github.com/StackExchange/wmi"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
 )
@@ -53,7 +50,7 @@ func NewCSCollector() (Collector, error) {
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *CSCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) error {
-	if desc, err := c.collect(ch); err != nil {
+	if desc, err := c.collect(ctx, ch); err != nil {
 		log.Error("failed collecting cs metrics:", desc, err)
 		return err
 	}
@@ -70,10 +67,10 @@ type Win32_ComputerSystem struct {
 	Workgroup                 *string
 }
 
-func (c *CSCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *CSCollector) collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_ComputerSystem
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmi.Query(q, &dst); err != nil {
 		return nil, err
 	}
 	if len(dst) == 0 {
@@ -1,24 +1,21 @@
-// +build windows
-
 package collector
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
-	"gopkg.in/alecthomas/kingpin.v2"
 )
 
-var dfsrEnabledCollectors = kingpin.Flag("collectors.dfsr.sources-enabled", "Comma-seperated list of DFSR Perflib sources to use.").Default("connection,folder,volume").String()
+var dfsrEnabledCollectors = stringSetting("collectors.dfsr.sources-enabled", "Comma-seperated list of DFSR Perflib sources to use.", "connection,folder,volume")
 
 func init() {
 	log.Info("dfsr collector is in an experimental state! Metrics for this collector have not been tested.")
 	// Perflib sources are dynamic, depending on the enabled child collectors
 	var perflibDependencies []string
-	for _, source := range expandEnabledChildCollectors(*dfsrEnabledCollectors) {
+	for _, source := range expandEnabledChildCollectors(Settings{}.String(dfsrEnabledCollectors)) {
 		perflibDependencies = append(perflibDependencies, dfsrGetPerfObjectName(source))
 	}
 
-	registerCollector("dfsr", NewDFSRCollector, perflibDependencies...)
+	registerConfigurableCollector("dfsr", NewDFSRCollector, perflibDependencies...)
 }
 
 // DFSRCollector contains the metric and state data of the DFSR collectors.
@@ -97,15 +94,15 @@ func dfsrGetPerfObjectName(collector string) string {
 }
 
 // NewDFSRCollector is registered
-func NewDFSRCollector() (Collector, error) {
+func NewDFSRCollector(settings Settings) (Collector, error) {
 	const subsystem = "dfsr"
 
-	enabled := expandEnabledChildCollectors(*dfsrEnabledCollectors)
+	enabled := expandEnabledChildCollectors(settings.String(dfsrEnabledCollectors))
 	perfCounters := make([]string, 0, len(enabled))
 	for _, c := range enabled {
 		perfCounters = append(perfCounters, dfsrGetPerfObjectName(c))
 	}
-	addPerfCounterDependencies(subsystem, perfCounters)
+	settings.setPerfCounterDependencies(subsystem, perfCounters)
 
 	dfsrCollector := DFSRCollector{
 		// meta
@@ -138,21 +135,21 @@ func NewDFSRCollector() (Collector, error) {
 		),
 
 		ConnectionCompressedSizeOfFilesReceivedTotal: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, subsystem, "compressed_size_of_files_received_total"),
+			prometheus.BuildFQName(Namespace, subsystem, "connection_compressed_size_of_files_received_total"),
 			"",
 			[]string{"name"},
 			nil,
 		),
 
 		ConnectionFilesReceivedTotal: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, subsystem, "received_files_total"),
+			prometheus.BuildFQName(Namespace, subsystem, "connection_received_files_total"),
 			"Total number of files receieved for connection",
 			[]string{"name"},
 			nil,
 		),
 
 		ConnectionRDCBytesReceivedTotal: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, subsystem, "rdc_received_bytes_total"),
+			prometheus.BuildFQName(Namespace, subsystem, "connection_rdc_received_bytes_total"),
 			"",
 			[]string{"name"},
 			nil,
@@ -166,7 +163,7 @@ func NewDFSRCollector() (Collector, error) {
 		),
 
 		ConnectionRDCNumberofFilesReceivedTotal: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, subsystem, "rdc_received_files_total"),
+			prometheus.BuildFQName(Namespace, subsystem, "connection_rdc_received_files_total"),
 			"Total number of Remote Differential Compression files received",
 			[]string{"name"},
 			nil,
@@ -180,7 +177,7 @@ func NewDFSRCollector() (Collector, error) {
 		),
 
 		ConnectionSizeOfFilesReceivedTotal: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, subsystem, "files_received_bytes_total"),
+			prometheus.BuildFQName(Namespace, subsystem, "connection_files_received_bytes_total"),
 			"Total size of files received, in bytes",
 			[]string{"name"},
 			nil,
@@ -195,7 +192,7 @@ func NewDFSRCollector() (Collector, error) {
 		),
 
 		FolderCompressedSizeOfFilesReceivedTotal: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, subsystem, "compressed_size_of_files_received_total"),
+			prometheus.BuildFQName(Namespace, subsystem, "folder_compressed_size_of_files_received_total"),
 			"",
 			[]string{"name"},
 			nil,
@@ -293,14 +290,14 @@ func NewDFSRCollector() (Collector, error) {
 		),
 
 		FolderFilesReceivedTotal: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, subsystem, "received_files_total"),
+			prometheus.BuildFQName(Namespace, subsystem, "folder_received_files_total"),
 			"",
 			[]string{"name"},
 			nil,
 		),
 
 		FolderRDCBytesReceivedTotal: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, subsystem, "rdc_received_bytes_total"),
+			prometheus.BuildFQName(Namespace, subsystem, "folder_rdc_received_bytes_total"),
 			"",
 			[]string{"name"},
 			nil,
@@ -314,7 +311,7 @@ func NewDFSRCollector() (Collector, error) {
 		),
 
 		FolderRDCNumberofFilesReceivedTotal: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, subsystem, "rdc_received_files_total"),
+			prometheus.BuildFQName(Namespace, subsystem, "folder_rdc_received_files_total"),
 			"",
 			[]string{"name"},
 			nil,
@@ -328,7 +325,7 @@ func NewDFSRCollector() (Collector, error) {
 		),
 
 		FolderSizeOfFilesReceivedTotal: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, subsystem, "files_received_bytes_total"),
+			prometheus.BuildFQName(Namespace, subsystem, "folder_files_received_bytes_total"),
 			"",
 			[]string{"name"},
 			nil,
@@ -1,19 +1,16 @@
-// +build windows
-
 package collector
 
 import (
 	"fmt"
-	"os"
+	"io"
 	"strings"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
-	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 func init() {
-	registerCollector("exchange", newExchangeCollector,
+	registerConfigurableCollector("exchange", newExchangeCollector,
 		"MSExchange ADAccess Processes",
 		"MSExchangeTransport Queues",
 		"MSExchange HttpProxy",
@@ -24,6 +21,7 @@ func init() {
 		"MSExchange WorkloadManagement Workloads",
 		"MSExchange RpcClientAccess",
 	)
+	registerListing("exchange", argExchangeListAllCollectors, printExchangeCollectors)
 }
 
 type exchangeCollector struct {
@@ -82,19 +80,42 @@ var (
 		"RpcClientAccess",
 	}
 
-	argExchangeListAllCollectors = kingpin.Flag(
+	argExchangeListAllCollectors = boolSetting(
 		"collectors.exchange.list",
 		"List the collectors along with their perflib object name/ids",
-	).Bool()
+	)
 
-	argExchangeCollectorsEnabled = kingpin.Flag(
+	argExchangeCollectorsEnabled = stringSetting(
 		"collectors.exchange.enabled",
 		"Comma-separated list of collectors to use. Defaults to all, if not specified.",
-	).Default("").String()
+		"",
+	)
 )
 
+// exchangeCollectorDesc holds the perflib object of each exchange collector.
+var exchangeCollectorDesc = map[string]string{
+	"ADAccessProcesses":   "[19108] MSExchange ADAccess Processes",
+	"TransportQueues":     "[20524] MSExchangeTransport Queues",
+	"HttpProxy":           "[36934] MSExchange HttpProxy",
+	"ActiveSync":          "[25138] MSExchange ActiveSync",
+	"AvailabilityService": "[24914] MSExchange Availability Service",
+	"OutlookWebAccess":    "[24618] MSExchange OWA",
+	"Autodiscover":        "[29240] MSExchange Autodiscover",
+	"WorkloadManagement":  "[19430] MSExchange WorkloadManagement Workloads",
+	"RpcClientAccess":     "[29336] MSExchange RpcClientAccess",
+}
+
+// printExchangeCollectors prints the exchange collectors along with their
+// perflib object.
+func printExchangeCollectors(w io.Writer) {
+	fmt.Fprintf(w, "%-32s %-32s\n", "Collector Name", "[PerfID] Perflib Object")
+	for _, cname := range exchangeAllCollectorNames {
+		fmt.Fprintf(w, "%-32s %-32s\n", cname, exchangeCollectorDesc[cname])
+	}
+}
+
 // newExchangeCollector returns a new Collector
-func newExchangeCollector() (Collector, error) {
+func newExchangeCollector(settings Settings) (Collector, error) {
 
 	// desc creates a new prometheus description
 	desc := func(metricName string, description string, labels ...string) *prometheus.Desc {
@@ -148,32 +169,12 @@ func newExchangeCollector() (Collector, error) {
 		enabledCollectors: make([]string, 0, len(exchangeAllCollectorNames)),
 	}
 
-	collectorDesc := map[string]string{
-		"ADAccessProcesses":   "[19108] MSExchange ADAccess Processes",
-		"TransportQueues":     "[20524] MSExchangeTransport Queues",
-		"HttpProxy":           "[36934] MSExchange HttpProxy",
-		"ActiveSync":          "[25138] MSExchange ActiveSync",
-		"AvailabilityService": "[24914] MSExchange Availability Service",
-		"OutlookWebAccess":    "[24618] MSExchange OWA",
-		"Autodiscover":        "[29240] MSExchange Autodiscover",
-		"WorkloadManagement":  "[19430] MSExchange WorkloadManagement Workloads",
-		"RpcClientAccess":     "[29336] MSExchange RpcClientAccess",
-	}
-
-	if *argExchangeListAllCollectors {
-		fmt.Printf("%-32s %-32s\n", "Collector Name", "[PerfID] Perflib Object")
-		for _, cname := range exchangeAllCollectorNames {
-			fmt.Printf("%-32s %-32s\n", cname, collectorDesc[cname])
-		}
-		os.Exit(0)
-	}
-
-	if *argExchangeCollectorsEnabled == "" {
+	if settings.String(argExchangeCollectorsEnabled) == "" {
 		for _, collectorName := range exchangeAllCollectorNames {
 			c.enabledCollectors = append(c.enabledCollectors, collectorName)
 		}
 	} else {
-		for _, collectorName := range strings.Split(*argExchangeCollectorsEnabled, ",") {
+		for _, collectorName := range strings.Split(settings.String(argExchangeCollectorsEnabled), ",") {
 			if find(exchangeAllCollectorNames, collectorName) {
 				c.enabledCollectors = append(c.enabledCollectors, collectorName)
 			} else {
@@ -0,0 +1,71 @@
+package collector
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Filter selects values, such as the names of the instances a collector
+// reports on, or the values of a label of its metrics. A value is selected if
+// it matches the include pattern, and does not match the exclude pattern.
+// Both patterns must match the whole value.
+type Filter struct {
+	// A nil pattern places no constraint.
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+// NewFilter returns a Filter of the values matching include and not matching
+// exclude. An empty exclude pattern only excludes the empty value.
+func NewFilter(include, exclude string) (*Filter, error) {
+	f := &Filter{}
+	var err error
+	if f.include, err = compileFilterPattern(include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = compileFilterPattern(exclude); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// MustNewFilter is like NewFilter, but panics if a pattern is invalid.
+func MustNewFilter(include, exclude string) *Filter {
+	f, err := NewFilter(include, exclude)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// NewIncludeFilter returns a Filter of the values matching pattern.
+func NewIncludeFilter(pattern string) (*Filter, error) {
+	re, err := compileFilterPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{include: re}, nil
+}
+
+// NewExcludeFilter returns a Filter of the values not matching pattern.
+func NewExcludeFilter(pattern string) (*Filter, error) {
+	re, err := compileFilterPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{exclude: re}, nil
+}
+
+func compileFilterPattern(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", pattern))
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter pattern %q: %v", pattern, err)
+	}
+	return re, nil
+}
+
+// Match reports whether value is selected.
+func (f *Filter) Match(value string) bool {
+	return (f.include == nil || f.include.MatchString(value)) &&
+		(f.exclude == nil || !f.exclude.MatchString(value))
+}
@@ -0,0 +1,34 @@
+package collector
+
+import "testing"
+
+func TestFilter(t *testing.T) {
+	f := MustNewFilter("sql.+|w3wp", "sqlwriter")
+	for value, want := range map[string]bool{
+		"sqlservr":  true,
+		"w3wp":      true,
+		"sqlwriter": false,
+		"xw3wp":     false,
+		"":          false,
+	} {
+		if got := f.Match(value); got != want {
+			t.Errorf("expected %v for %q, got %v", want, value, got)
+		}
+	}
+
+	include, err := NewIncludeFilter("C:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exclude, err := NewExcludeFilter("C:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !include.Match("C:") || include.Match("D:") || exclude.Match("C:") || !exclude.Match("") {
+		t.Error("unexpected matches of include and exclude filters")
+	}
+
+	if _, err := NewFilter("(", ""); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
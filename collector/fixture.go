@@ -0,0 +1,99 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/prometheus-community/windows_exporter/perflib"
+)
+
+// FixturePerflibSource is a PerflibSource serving a fixed set of perflib
+// objects, regardless of the query. It is intended for tests and for replaying
+// previously captured data.
+type FixturePerflibSource struct {
+	Objects map[string]*perflib.PerfObject
+}
+
+// NewFixturePerflibSource returns a FixturePerflibSource serving objs, indexed
+// by their name.
+func NewFixturePerflibSource(objs ...*perflib.PerfObject) *FixturePerflibSource {
+	s := &FixturePerflibSource{Objects: make(map[string]*perflib.PerfObject, len(objs))}
+	for _, obj := range objs {
+		s.Objects[obj.Name] = obj
+	}
+	return s
+}
+
+// Snapshot returns all objects held by the fixture, unless ctx is done.
+func (s *FixturePerflibSource) Snapshot(ctx context.Context, query string) (map[string]*perflib.PerfObject, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	objs := make(map[string]*perflib.PerfObject, len(s.Objects))
+	for name, obj := range s.Objects {
+		objs[name] = obj
+	}
+	return objs, nil
+}
+
+type fixtureWMIKey struct {
+	namespace string
+	query     string
+}
+
+// FixtureWMIQuerier is a WMIQuerier answering queries from an in-memory set of
+// result sets. Results are stored as JSON, so rows can be added from any
+// struct type with compatible field names, or from previously recorded data.
+type FixtureWMIQuerier struct {
+	results map[fixtureWMIKey]json.RawMessage
+	errors  map[fixtureWMIKey]string
+}
+
+// NewFixtureWMIQuerier returns an empty FixtureWMIQuerier.
+func NewFixtureWMIQuerier() *FixtureWMIQuerier {
+	return &FixtureWMIQuerier{
+		results: make(map[fixtureWMIKey]json.RawMessage),
+		errors:  make(map[fixtureWMIKey]string),
+	}
+}
+
+// Add registers rows as the result of query in namespace. rows must be a
+// slice, or a raw JSON array.
+func (q *FixtureWMIQuerier) Add(namespace, query string, rows interface{}) error {
+	raw, ok := rows.(json.RawMessage)
+	if !ok {
+		var err error
+		raw, err = json.Marshal(rows)
+		if err != nil {
+			return fmt.Errorf("failed to encode fixture rows for %q: %v", query, err)
+		}
+	}
+	q.results[fixtureWMIKey{namespace: namespace, query: query}] = raw
+	return nil
+}
+
+// AddError registers query in namespace as failing with the given message.
+func (q *FixtureWMIQuerier) AddError(namespace, query, message string) {
+	q.errors[fixtureWMIKey{namespace: namespace, query: query}] = message
+}
+
+// Query answers query from the default namespace.
+func (q *FixtureWMIQuerier) Query(query string, dst interface{}) error {
+	return q.QueryNamespace(query, dst, DefaultWMINamespace)
+}
+
+// QueryNamespace answers query from the given namespace. Queries without a
+// registered result fail, as an unknown WMI class would.
+func (q *FixtureWMIQuerier) QueryNamespace(query string, dst interface{}, namespace string) error {
+	key := fixtureWMIKey{namespace: namespace, query: query}
+	if message, ok := q.errors[key]; ok {
+		return errors.New(message)
+	}
+	raw, ok := q.results[key]
+	if !ok {
+		return fmt.Errorf("no fixture for WMI query %q in namespace %q", query, namespace)
+	}
+	return json.Unmarshal(raw, dst)
+}
@@ -0,0 +1,100 @@
+package collector
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus-community/windows_exporter/perflib"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func collectWithFixtures(t *testing.T, c Collector, perflibSource PerflibSource, wmiQuerier WMIQuerier) map[string][]*dto.Metric {
+	t.Helper()
+	ctx, err := PrepareScrapeContext(context.Background(), nil, perflibSource, wmiQuerier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan prometheus.Metric)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.Collect(ctx, ch)
+		close(ch)
+	}()
+
+	metrics := make(map[string][]*dto.Metric)
+	for m := range ch {
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			t.Fatal(err)
+		}
+		name := m.Desc().String()
+		metrics[name] = append(metrics[name], pb)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	return metrics
+}
+
+func TestFixturePerflibSource(t *testing.T) {
+	threads := &perflib.PerfCounterDef{Name: "Threads", CounterType: perflib.PERF_COUNTER_RAWCOUNT}
+	src := NewFixturePerflibSource(&perflib.PerfObject{
+		Name: "System",
+		Instances: []*perflib.PerfInstance{
+			{Counters: []*perflib.PerfCounter{{Def: threads, Value: 1234}}},
+		},
+	})
+
+	c, err := NewSystemCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := collectWithFixtures(t, c, src, NewFixtureWMIQuerier())
+
+	got := metrics[c.(*SystemCollector).Threads.String()]
+	if len(got) != 1 || got[0].GetGauge().GetValue() != 1234 {
+		t.Errorf("expected a single windows_system_threads sample of 1234, got %v", got)
+	}
+}
+
+func TestFixtureWMIQuerier(t *testing.T) {
+	runAs := "LocalSystem"
+	q := NewFixtureWMIQuerier()
+	err := q.Add(DefaultWMINamespace, "SELECT * FROM Win32_Service", []Win32_Service{
+		{Name: "Dhcp", DisplayName: "DHCP Client", ProcessId: 42, State: "Running", Status: "OK", StartMode: "Auto", StartName: &runAs},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sc, err := NewserviceCollector(Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := sc.(*serviceCollector)
+	metrics := collectWithFixtures(t, c, NewFixturePerflibSource(), q)
+
+	info := metrics[c.Information.String()]
+	if len(info) != 1 {
+		t.Fatalf("expected a single service info sample, got %v", info)
+	}
+	labels := map[string]string{}
+	for _, l := range info[0].GetLabel() {
+		labels[l.GetName()] = l.GetValue()
+	}
+	if labels["name"] != "dhcp" || labels["run_as"] != runAs || labels["process_id"] != "42" {
+		t.Errorf("unexpected service info labels %v", labels)
+	}
+	if n := len(metrics[c.State.String()]); n != len(allStates) {
+		t.Errorf("expected %d state samples, got %d", len(allStates), n)
+	}
+}
+
+func TestFixtureWMIQuerierMissingQuery(t *testing.T) {
+	var dst []Win32_Service
+	if err := NewFixtureWMIQuerier().Query("SELECT * FROM Win32_Service", &dst); err == nil {
+		t.Error("expected an error for a query without fixture")
+	}
+}
@@ -0,0 +1,153 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+var updateGolden = flag.Bool("update", false, "Regenerate the golden files in testdata/golden instead of comparing against them.")
+
+// goldenSkipped lists collectors which do not read from perflib or WMI, and
+// thus cannot be driven by fixtures.
+var goldenSkipped = map[string]string{
+	"container": "reads from the Host Compute Service API",
+}
+
+// goldenVolatile lists metrics whose values depend on the time of the run.
+// Their values are zeroed before comparison.
+var goldenVolatile = map[string]bool{
+	"windows_mssql_collector_duration_seconds": true,
+	"windows_textfile_mtime_seconds":           true,
+}
+
+// goldenSettings holds the flags collectors are built with. Flags missing from
+// it take their default value.
+var goldenSettings = Settings{Flags: map[string]string{
+	"collector.textfile.directory": filepath.Join("testdata", "textfile"),
+}}
+
+// pinGoldenEnvironment replaces lookups of the host environment made while
+// building collectors, so their output only depends on the fixtures. They are
+// restored once t is done.
+func pinGoldenEnvironment(t *testing.T) {
+	windowsVersion, iisVersion, mssqlInstances, broker := getWindowsVersion, getIISVersion, getMSSQLInstances, connectionBrokerEnabled
+	t.Cleanup(func() {
+		getWindowsVersion, getIISVersion, getMSSQLInstances, connectionBrokerEnabled = windowsVersion, iisVersion, mssqlInstances, broker
+	})
+
+	getWindowsVersion = func() float64 { return 10.0 }
+	getIISVersion = func() simple_version { return simple_version{major: 10, minor: 0} }
+	getMSSQLInstances = func() mssqlInstancesType {
+		return mssqlInstancesType{"MSSQLSERVER": "MSSQL15.MSSQLSERVER", "SQLEXPRESS": "MSSQL15.SQLEXPRESS"}
+	}
+	connectionBrokerEnabled = true
+}
+
+// goldenCollector adapts a Collector and a fixed ScrapeContext to a
+// prometheus.Collector, so the output can be gathered from a registry.
+type goldenCollector struct {
+	c   Collector
+	ctx *ScrapeContext
+	err error
+}
+
+func (g *goldenCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (g *goldenCollector) Collect(ch chan<- prometheus.Metric) {
+	g.err = g.c.Collect(g.ctx, ch)
+}
+
+func renderGolden(t *testing.T, name string) []byte {
+	t.Helper()
+	rec, err := LoadRecording(filepath.Join("testdata", "fixtures", name+".json"))
+	if err != nil {
+		t.Fatalf("no usable fixture: %v", err)
+	}
+	perflibSource, wmiQuerier, err := rec.Sources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := PrepareScrapeContext(context.Background(), []string{name}, perflibSource, wmiQuerier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := Build(name, goldenSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gc := &goldenCollector{c: c, ctx: ctx}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(gc)
+	// Inconsistent metrics, such as duplicate series or conflicting help
+	// texts, fail the test.
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gathering failed: %v", err)
+	}
+	if gc.err != nil {
+		t.Fatalf("collector failed: %v", gc.err)
+	}
+
+	var buf bytes.Buffer
+	for _, mf := range mfs {
+		if goldenVolatile[mf.GetName()] {
+			for _, m := range mf.GetMetric() {
+				if m.Gauge != nil {
+					m.Gauge.Value = new(float64)
+				}
+				if m.Counter != nil {
+					m.Counter.Value = new(float64)
+				}
+			}
+		}
+		if _, err := expfmt.MetricFamilyToText(&buf, mf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// TestGolden runs every registered collector against its fixture in
+// testdata/fixtures, and compares the exposition against testdata/golden.
+// Fixtures use the format written by --scrape.record-dir. Run with -update
+// to regenerate the golden files after an intended change in output.
+func TestGolden(t *testing.T) {
+	pinGoldenEnvironment(t)
+
+	names := Available()
+	sort.Strings(names)
+	for _, name := range names {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			if reason, ok := goldenSkipped[name]; ok {
+				t.Skipf("collector %s %s", name, reason)
+			}
+
+			got := renderGolden(t, name)
+			golden := filepath.Join("testdata", "golden", name+".prom")
+			if *updateGolden {
+				if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("no golden file, run with -update to create it: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("output of collector %s does not match %s, run with -update if the change is intended.\nExpected:\n%s\nGot:\n%s", name, golden, want, got)
+			}
+		})
+	}
+}
@@ -1,11 +1,8 @@
-// +build windows
-
 package collector
 
 import (
 	"strings"
 
-	"github.com/StackExchange/wmi"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
 )
@@ -598,52 +595,52 @@ func NewHyperVCollector() (Collector, error) {
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *HyperVCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) error {
-	if desc, err := c.collectVmHealth(ch); err != nil {
+	if desc, err := c.collectVmHealth(ctx, ch); err != nil {
 		log.Error("failed collecting hyperV health status metrics:", desc, err)
 		return err
 	}
 
-	if desc, err := c.collectVmVid(ch); err != nil {
+	if desc, err := c.collectVmVid(ctx, ch); err != nil {
 		log.Error("failed collecting hyperV pages metrics:", desc, err)
 		return err
 	}
 
-	if desc, err := c.collectVmHv(ch); err != nil {
+	if desc, err := c.collectVmHv(ctx, ch); err != nil {
 		log.Error("failed collecting hyperV hv status metrics:", desc, err)
 		return err
 	}
 
-	if desc, err := c.collectVmProcessor(ch); err != nil {
+	if desc, err := c.collectVmProcessor(ctx, ch); err != nil {
 		log.Error("failed collecting hyperV processor metrics:", desc, err)
 		return err
 	}
 
-	if desc, err := c.collectHostCpuUsage(ch); err != nil {
+	if desc, err := c.collectHostCpuUsage(ctx, ch); err != nil {
 		log.Error("failed collecting hyperV host CPU metrics:", desc, err)
 		return err
 	}
 
-	if desc, err := c.collectVmCpuUsage(ch); err != nil {
+	if desc, err := c.collectVmCpuUsage(ctx, ch); err != nil {
 		log.Error("failed collecting hyperV VM CPU metrics:", desc, err)
 		return err
 	}
 
-	if desc, err := c.collectVmSwitch(ch); err != nil {
+	if desc, err := c.collectVmSwitch(ctx, ch); err != nil {
 		log.Error("failed collecting hyperV switch metrics:", desc, err)
 		return err
 	}
 
-	if desc, err := c.collectVmEthernet(ch); err != nil {
+	if desc, err := c.collectVmEthernet(ctx, ch); err != nil {
 		log.Error("failed collecting hyperV ethernet metrics:", desc, err)
 		return err
 	}
 
-	if desc, err := c.collectVmStorage(ch); err != nil {
+	if desc, err := c.collectVmStorage(ctx, ch); err != nil {
 		log.Error("failed collecting hyperV virtual storage metrics:", desc, err)
 		return err
 	}
 
-	if desc, err := c.collectVmNetwork(ch); err != nil {
+	if desc, err := c.collectVmNetwork(ctx, ch); err != nil {
 		log.Error("failed collecting hyperV virtual network metrics:", desc, err)
 		return err
 	}
@@ -657,10 +654,10 @@ type Win32_PerfRawData_VmmsVirtualMachineStats_HyperVVirtualMachineHealthSummary
 	HealthOk       uint32
 }
 
-func (c *HyperVCollector) collectVmHealth(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *HyperVCollector) collectVmHealth(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_VmmsVirtualMachineStats_HyperVVirtualMachineHealthSummary
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmi.Query(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -690,10 +687,10 @@ type Win32_PerfRawData_VidPerfProvider_HyperVVMVidPartition struct {
 	RemotePhysicalPages    uint64
 }
 
-func (c *HyperVCollector) collectVmVid(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *HyperVCollector) collectVmVid(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_VidPerfProvider_HyperVVMVidPartition
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmi.Query(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -754,10 +751,10 @@ type Win32_PerfRawData_HvStats_HyperVHypervisorRootPartition struct {
 	VirtualTLBPages               uint64
 }
 
-func (c *HyperVCollector) collectVmHv(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *HyperVCollector) collectVmHv(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_HvStats_HyperVHypervisorRootPartition
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmi.Query(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -892,10 +889,10 @@ type Win32_PerfRawData_HvStats_HyperVHypervisor struct {
 	VirtualProcessors uint64
 }
 
-func (c *HyperVCollector) collectVmProcessor(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *HyperVCollector) collectVmProcessor(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_HvStats_HyperVHypervisor
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmi.Query(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -927,10 +924,10 @@ type Win32_PerfRawData_HvStats_HyperVHypervisorRootVirtualProcessor struct {
 	PercentTotalRunTime      uint64
 }
 
-func (c *HyperVCollector) collectHostCpuUsage(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *HyperVCollector) collectHostCpuUsage(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_HvStats_HyperVHypervisorRootVirtualProcessor
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmi.Query(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -988,10 +985,10 @@ type Win32_PerfRawData_HvStats_HyperVHypervisorVirtualProcessor struct {
 	PercentTotalRunTime      uint64
 }
 
-func (c *HyperVCollector) collectVmCpuUsage(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *HyperVCollector) collectVmCpuUsage(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_HvStats_HyperVHypervisorVirtualProcessor
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmi.Query(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -1075,10 +1072,10 @@ type Win32_PerfRawData_NvspSwitchStats_HyperVVirtualSwitch struct {
 	PurgedMacAddressesPersec               uint64
 }
 
-func (c *HyperVCollector) collectVmSwitch(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *HyperVCollector) collectVmSwitch(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_NvspSwitchStats_HyperVVirtualSwitch
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmi.Query(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -1234,10 +1231,10 @@ type Win32_PerfRawData_EthernetPerfProvider_HyperVLegacyNetworkAdapter struct {
 	FramesSentPersec     uint64
 }
 
-func (c *HyperVCollector) collectVmEthernet(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *HyperVCollector) collectVmEthernet(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_EthernetPerfProvider_HyperVLegacyNetworkAdapter
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmi.Query(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -1304,10 +1301,10 @@ type Win32_PerfRawData_Counters_HyperVVirtualStorageDevice struct {
 	WriteOperationsPerSec uint64
 }
 
-func (c *HyperVCollector) collectVmStorage(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *HyperVCollector) collectVmStorage(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_Counters_HyperVVirtualStorageDevice
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmi.Query(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -1373,10 +1370,10 @@ type Win32_PerfRawData_NvspNicStats_HyperVVirtualNetworkAdapter struct {
 	PacketsSentPersec            uint64
 }
 
-func (c *HyperVCollector) collectVmNetwork(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *HyperVCollector) collectVmNetwork(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_NvspNicStats_HyperVVirtualNetworkAdapter
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmi.Query(q, &dst); err != nil {
 		return nil, err
 	}
 
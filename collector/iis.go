@@ -1,22 +1,15 @@
-// +build windows
-
 package collector
 
 import (
 	"errors"
-	"fmt"
 	"regexp"
 
-	"golang.org/x/sys/windows/registry"
-
-	"github.com/StackExchange/wmi"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
-	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 func init() {
-	registerCollector("iis", NewIISCollector)
+	registerConfigurableCollector("iis", NewIISCollector)
 }
 
 type simple_version struct {
@@ -24,8 +17,10 @@ type simple_version struct {
 	minor uint64
 }
 
-func getIISVersion() simple_version {
-	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\InetStp\`, registry.QUERY_VALUE)
+// getIISVersion reads the version of IIS from the Registry. It is a variable
+// so tests can pin the version the collector is built for.
+var getIISVersion = func() simple_version {
+	k, err := openLocalMachineKey(`SOFTWARE\Microsoft\InetStp\`)
 	if err != nil {
 		log.Warn("Couldn't open registry to determine IIS version:", err)
 		return simple_version{}
@@ -37,12 +32,12 @@ func getIISVersion() simple_version {
 		}
 	}()
 
-	major, _, err := k.GetIntegerValue("MajorVersion")
+	major, err := k.GetIntegerValue("MajorVersion")
 	if err != nil {
 		log.Warn("Couldn't open registry to determine IIS version:", err)
 		return simple_version{}
 	}
-	minor, _, err := k.GetIntegerValue("MinorVersion")
+	minor, err := k.GetIntegerValue("MinorVersion")
 	if err != nil {
 		log.Warn("Couldn't open registry to determine IIS version:", err)
 		return simple_version{}
@@ -57,10 +52,10 @@ func getIISVersion() simple_version {
 }
 
 var (
-	siteWhitelist = kingpin.Flag("collector.iis.site-whitelist", "Regexp of sites to whitelist. Site name must both match whitelist and not match blacklist to be included.").Default(".+").String()
-	siteBlacklist = kingpin.Flag("collector.iis.site-blacklist", "Regexp of sites to blacklist. Site name must both match whitelist and not match blacklist to be included.").String()
-	appWhitelist  = kingpin.Flag("collector.iis.app-whitelist", "Regexp of apps to whitelist. App name must both match whitelist and not match blacklist to be included.").Default(".+").String()
-	appBlacklist  = kingpin.Flag("collector.iis.app-blacklist", "Regexp of apps to blacklist. App name must both match whitelist and not match blacklist to be included.").String()
+	siteWhitelist = stringSetting("collector.iis.site-whitelist", "Regexp of sites to whitelist. Site name must both match whitelist and not match blacklist to be included.", ".+")
+	siteBlacklist = stringSetting("collector.iis.site-blacklist", "Regexp of sites to blacklist. Site name must both match whitelist and not match blacklist to be included.", "")
+	appWhitelist  = stringSetting("collector.iis.app-whitelist", "Regexp of apps to whitelist. App name must both match whitelist and not match blacklist to be included.", ".+")
+	appBlacklist  = stringSetting("collector.iis.app-blacklist", "Regexp of apps to blacklist. App name must both match whitelist and not match blacklist to be included.", "")
 )
 
 type IISCollector struct {
@@ -87,8 +82,7 @@ type IISCollector struct {
 	TotalNotFoundErrors                 *prometheus.Desc
 	TotalRejectedAsyncIORequests        *prometheus.Desc
 
-	siteWhitelistPattern *regexp.Regexp
-	siteBlacklistPattern *regexp.Regexp
+	siteFilter *Filter
 
 	CurrentApplicationPoolState        *prometheus.Desc
 	CurrentApplicationPoolUptime       *prometheus.Desc
@@ -185,14 +179,13 @@ type IISCollector struct {
 	ServiceCache_OutputCacheFlushedItemsTotal  *prometheus.Desc
 	ServiceCache_OutputCacheFlushesTotal       *prometheus.Desc
 
-	appWhitelistPattern *regexp.Regexp
-	appBlacklistPattern *regexp.Regexp
+	appFilter *Filter
 
 	iis_version simple_version
 }
 
 // NewIISCollector ...
-func NewIISCollector() (Collector, error) {
+func NewIISCollector(settings Settings) (Collector, error) {
 	const subsystem = "iis"
 
 	buildIIS := &IISCollector{
@@ -327,8 +320,7 @@ func NewIISCollector() (Collector, error) {
 			nil,
 		),
 
-		siteWhitelistPattern: regexp.MustCompile(fmt.Sprintf("^(?:%s)$", *siteWhitelist)),
-		siteBlacklistPattern: regexp.MustCompile(fmt.Sprintf("^(?:%s)$", *siteBlacklist)),
+		siteFilter: MustNewFilter(settings.String(siteWhitelist), settings.String(siteBlacklist)),
 
 		// App Pools
 		// Guages
@@ -807,8 +799,7 @@ func NewIISCollector() (Collector, error) {
 			nil,
 		),
 
-		appWhitelistPattern: regexp.MustCompile(fmt.Sprintf("^(?:%s)$", *appWhitelist)),
-		appBlacklistPattern: regexp.MustCompile(fmt.Sprintf("^(?:%s)$", *appBlacklist)),
+		appFilter: MustNewFilter(settings.String(appWhitelist), settings.String(appBlacklist)),
 	}
 
 	buildIIS.iis_version = getIISVersion()
@@ -819,7 +810,7 @@ func NewIISCollector() (Collector, error) {
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *IISCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) error {
-	if desc, err := c.collect(ch); err != nil {
+	if desc, err := c.collect(ctx, ch); err != nil {
 		log.Error("failed collecting iis metrics:", desc, err)
 		return err
 	}
@@ -998,17 +989,19 @@ var applicationStates = map[uint32]string{
 // W3SVCW3WPCounterProvider_W3SVCW3WP returns names prefixed with pid
 var workerProcessNameExtractor = regexp.MustCompile(`^(\d+)_(.+)$`)
 
-func (c *IISCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *IISCollector) collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_W3SVC_WebService
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmi.Query(q, &dst); err != nil {
 		return nil, err
 	}
 
 	for _, site := range dst {
+		if err := ctx.Context().Err(); err != nil {
+			return nil, err
+		}
 		if site.Name == "_Total" ||
-			c.siteBlacklistPattern.MatchString(site.Name) ||
-			!c.siteWhitelistPattern.MatchString(site.Name) {
+			!c.siteFilter.Match(site.Name) {
 			continue
 		}
 
@@ -1254,14 +1247,16 @@ func (c *IISCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, e
 
 	var dst2 []Win32_PerfRawData_APPPOOLCountersProvider_APPPOOLWAS
 	q2 := queryAll(&dst2)
-	if err := wmi.Query(q2, &dst2); err != nil {
+	if err := ctx.wmi.Query(q2, &dst2); err != nil {
 		return nil, err
 	}
 
 	for _, app := range dst2 {
+		if err := ctx.Context().Err(); err != nil {
+			return nil, err
+		}
 		if app.Name == "_Total" ||
-			c.appBlacklistPattern.MatchString(app.Name) ||
-			!c.appWhitelistPattern.MatchString(app.Name) {
+			!c.appFilter.Match(app.Name) {
 			continue
 		}
 
@@ -1371,15 +1366,17 @@ func (c *IISCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, e
 
 	var dst_worker []Win32_PerfRawData_W3SVCW3WPCounterProvider_W3SVCW3WP
 	q = queryAll(&dst_worker)
-	if err := wmi.Query(q, &dst_worker); err != nil {
+	if err := ctx.wmi.Query(q, &dst_worker); err != nil {
 		return nil, err
 	}
 	for _, app := range dst_worker {
+		if err := ctx.Context().Err(); err != nil {
+			return nil, err
+		}
 		// Extract the apppool name from the format <PID>_<NAME>
 		name := workerProcessNameExtractor.ReplaceAllString(app.Name, "$2")
 		if name == "_Total" ||
-			c.appBlacklistPattern.MatchString(name) ||
-			!c.appWhitelistPattern.MatchString(name) {
+			!c.appFilter.Match(name) {
 			continue
 		}
 
@@ -1653,15 +1650,17 @@ func (c *IISCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, e
 	if c.iis_version.major >= 8 {
 		var dst_worker_iis8 []Win32_PerfRawData_W3SVCW3WPCounterProvider_W3SVCW3WP_IIS8
 		q = queryAllForClass(&dst_worker_iis8, "Win32_PerfRawData_W3SVCW3WPCounterProvider_W3SVCW3WP")
-		if err := wmi.Query(q, &dst_worker_iis8); err != nil {
+		if err := ctx.wmi.Query(q, &dst_worker_iis8); err != nil {
 			return nil, err
 		}
 		for _, app := range dst_worker_iis8 {
+			if err := ctx.Context().Err(); err != nil {
+				return nil, err
+			}
 			// Extract the apppool name from the format <PID>_<NAME>
 			name := workerProcessNameExtractor.ReplaceAllString(app.Name, "$2")
 			if name == "_Total" ||
-				c.appBlacklistPattern.MatchString(name) ||
-				!c.appWhitelistPattern.MatchString(name) {
+				!c.appFilter.Match(name) {
 				continue
 			}
 
@@ -1736,7 +1735,7 @@ func (c *IISCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, e
 
 	var dst_cache []Win32_PerfRawData_W3SVC_WebServiceCache
 	q = queryAll(&dst_cache)
-	if err := wmi.Query(q, &dst_cache); err != nil {
+	if err := ctx.wmi.Query(q, &dst_cache); err != nil {
 		return nil, err
 	}
 
@@ -1,36 +1,37 @@
-// +build windows
-
 package collector
 
 import (
 	"errors"
 	"fmt"
-	"os"
+	"io"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
-	"golang.org/x/sys/windows/registry"
-	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 var (
-	mssqlEnabledCollectors = kingpin.Flag(
+	mssqlEnabledCollectors = stringSetting(
 		"collectors.mssql.classes-enabled",
-		"Comma-separated list of mssql WMI classes to use.").
-		Default(mssqlAvailableClassCollectors()).String()
+		"Comma-separated list of mssql WMI classes to use.",
+		mssqlAvailableClassCollectors(),
+	)
 
-	mssqlPrintCollectors = kingpin.Flag(
+	mssqlPrintCollectors = boolSetting(
 		"collectors.mssql.class-print",
 		"If true, print available mssql WMI classes and exit.  Only displays if the mssql collector is enabled.",
-	).Bool()
+	)
 )
 
 type mssqlInstancesType map[string]string
 
-func getMSSQLInstances() mssqlInstancesType {
+// getMSSQLInstances reads the installed SQL Server instances from the
+// Registry. It is a variable so tests can pin the instances the collector is
+// built for.
+var getMSSQLInstances = func() mssqlInstancesType {
 	sqlInstances := make(mssqlInstancesType)
 
 	// in case querying the registry fails, return the default instance
@@ -38,7 +39,7 @@ func getMSSQLInstances() mssqlInstancesType {
 	sqlDefaultInstance["MSSQLSERVER"] = ""
 
 	regkey := `Software\Microsoft\Microsoft SQL Server\Instance Names\SQL`
-	k, err := registry.OpenKey(registry.LOCAL_MACHINE, regkey, registry.QUERY_VALUE)
+	k, err := openLocalMachineKey(regkey)
 	if err != nil {
 		log.Warn("Couldn't open registry to determine SQL instances:", err)
 		return sqlDefaultInstance
@@ -50,14 +51,14 @@ func getMSSQLInstances() mssqlInstancesType {
 		}
 	}()
 
-	instanceNames, err := k.ReadValueNames(0)
+	instanceNames, err := k.ReadValueNames()
 	if err != nil {
 		log.Warnf("Can't ReadSubKeyNames %#v", err)
 		return sqlDefaultInstance
 	}
 
 	for _, instanceName := range instanceNames {
-		if instanceVersion, _, err := k.GetStringValue(instanceName); err == nil {
+		if instanceVersion, err := k.GetStringValue(instanceName); err == nil {
 			sqlInstances[instanceName] = instanceVersion
 		}
 	}
@@ -126,7 +127,16 @@ func mssqlGetPerfObjectName(sqlInstance string, collector string) string {
 }
 
 func init() {
-	registerCollector("mssql", NewMSSQLCollector)
+	registerConfigurableCollector("mssql", NewMSSQLCollector)
+	registerListing("mssql", mssqlPrintCollectors, printMSSQLClasses)
+}
+
+// printMSSQLClasses prints the classes the mssql collector can read.
+func printMSSQLClasses(w io.Writer) {
+	fmt.Fprintf(w, "Available SQLServer Classes:\n")
+	for _, name := range strings.Split(mssqlAvailableClassCollectors(), ",") {
+		fmt.Fprintf(w, " - %s\n", name)
+	}
 }
 
 // A MSSQLCollector is a Prometheus collector for various WMI Win32_PerfRawData_MSSQLSERVER_* metrics
@@ -382,17 +392,17 @@ type MSSQLCollector struct {
 	TransactionsVersionStoreCreationUnits        *prometheus.Desc
 	TransactionsVersionStoreTruncationUnits      *prometheus.Desc
 
-	mssqlInstances             mssqlInstancesType
-	mssqlCollectors            mssqlCollectorsMap
-	mssqlChildCollectorFailure int
+	mssqlInstances         mssqlInstancesType
+	mssqlEnabledCollectors []string
+	mssqlCollectors        mssqlCollectorsMap
 }
 
 // NewMSSQLCollector ...
-func NewMSSQLCollector() (Collector, error) {
+func NewMSSQLCollector(settings Settings) (Collector, error) {
 
 	const subsystem = "mssql"
 
-	enabled := expandEnabledChildCollectors(*mssqlEnabledCollectors)
+	enabled := expandEnabledChildCollectors(settings.String(mssqlEnabledCollectors))
 	mssqlInstances := getMSSQLInstances()
 	perfCounters := make([]string, 0, len(mssqlInstances)*len(enabled))
 	for instance := range mssqlInstances {
@@ -400,7 +410,7 @@ func NewMSSQLCollector() (Collector, error) {
 			perfCounters = append(perfCounters, mssqlGetPerfObjectName(instance, c))
 		}
 	}
-	addPerfCounterDependencies(subsystem, perfCounters)
+	settings.setPerfCounterDependencies(subsystem, perfCounters)
 
 	mssqlCollector := MSSQLCollector{
 		// meta
@@ -1792,35 +1802,32 @@ func NewMSSQLCollector() (Collector, error) {
 		mssqlInstances: mssqlInstances,
 	}
 
+	mssqlCollector.mssqlEnabledCollectors = enabled
 	mssqlCollector.mssqlCollectors = mssqlCollector.getMSSQLCollectors()
 
-	if *mssqlPrintCollectors {
-		fmt.Printf("Available SQLServer Classes:\n")
-		for name := range mssqlCollector.mssqlCollectors {
-			fmt.Printf(" - %s\n", name)
-		}
-		os.Exit(0)
-	}
-
 	return &mssqlCollector, nil
 }
 
 type mssqlCollectorFunc func(ctx *ScrapeContext, ch chan<- prometheus.Metric, sqlInstance string) (*prometheus.Desc, error)
 
-func (c *MSSQLCollector) execute(ctx *ScrapeContext, name string, fn mssqlCollectorFunc, ch chan<- prometheus.Metric, sqlInstance string, wg *sync.WaitGroup) {
-	// Reset failure counter on each scrape
-	c.mssqlChildCollectorFailure = 0
+// execute runs a child collector, and counts it into failures if it fails.
+// Child collectors run concurrently, so failures is updated atomically.
+func (c *MSSQLCollector) execute(ctx *ScrapeContext, name string, fn mssqlCollectorFunc, ch chan<- prometheus.Metric, sqlInstance string, wg *sync.WaitGroup, failures *int32) {
 	defer wg.Done()
 
 	begin := time.Now()
-	_, err := fn(ctx, ch, sqlInstance)
+	// Child collectors do not start once the scrape is done.
+	err := ctx.Context().Err()
+	if err == nil {
+		_, err = fn(ctx, ch, sqlInstance)
+	}
 	duration := time.Since(begin)
 	var success float64
 
 	if err != nil {
 		log.Errorf("mssql class collector %s failed after %fs: %s", name, duration.Seconds(), err)
 		success = 0
-		c.mssqlChildCollectorFailure++
+		atomic.AddInt32(failures, 1)
 	} else {
 		log.Debugf("mssql class collector %s succeeded after %fs.", name, duration.Seconds())
 		success = 1
@@ -1843,20 +1850,21 @@ func (c *MSSQLCollector) execute(ctx *ScrapeContext, name string, fn mssqlCollec
 // to the provided prometheus Metric channel.
 func (c *MSSQLCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) error {
 	wg := sync.WaitGroup{}
+	// Failures of the child collectors of this scrape.
+	var failures int32
 
-	enabled := expandEnabledChildCollectors(*mssqlEnabledCollectors)
 	for sqlInstance := range c.mssqlInstances {
-		for _, name := range enabled {
+		for _, name := range c.mssqlEnabledCollectors {
 			function := c.mssqlCollectors[name]
 
 			wg.Add(1)
-			go c.execute(ctx, name, function, ch, sqlInstance, &wg)
+			go c.execute(ctx, name, function, ch, sqlInstance, &wg, &failures)
 		}
 	}
 	wg.Wait()
 
 	// this shoud return an error if any? some? children errord.
-	if c.mssqlChildCollectorFailure > 0 {
+	if atomic.LoadInt32(&failures) > 0 {
 		return errors.New("at least one child collector failed")
 	}
 	return nil
@@ -1,29 +1,27 @@
-// +build windows
-
 package collector
 
 import (
-	"fmt"
 	"regexp"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
-	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 func init() {
-	registerCollector("net", NewNetworkCollector, "Network Interface")
+	registerConfigurableCollector("net", NewNetworkCollector, "Network Interface")
 }
 
 var (
-	nicWhitelist = kingpin.Flag(
+	nicWhitelist = stringSetting(
 		"collector.net.nic-whitelist",
 		"Regexp of NIC:s to whitelist. NIC name must both match whitelist and not match blacklist to be included.",
-	).Default(".+").String()
-	nicBlacklist = kingpin.Flag(
+		".+",
+	)
+	nicBlacklist = stringSetting(
 		"collector.net.nic-blacklist",
 		"Regexp of NIC:s to blacklist. NIC name must both match whitelist and not match blacklist to be included.",
-	).Default("").String()
+		"",
+	)
 	nicNameToUnderscore = regexp.MustCompile("[^a-zA-Z0-9]")
 )
 
@@ -42,12 +40,11 @@ type NetworkCollector struct {
 	PacketsSentTotal         *prometheus.Desc
 	CurrentBandwidth         *prometheus.Desc
 
-	nicWhitelistPattern *regexp.Regexp
-	nicBlacklistPattern *regexp.Regexp
+	nicFilter *Filter
 }
 
 // NewNetworkCollector ...
-func NewNetworkCollector() (Collector, error) {
+func NewNetworkCollector(settings Settings) (Collector, error) {
 	const subsystem = "net"
 
 	return &NetworkCollector{
@@ -124,8 +121,7 @@ func NewNetworkCollector() (Collector, error) {
 			nil,
 		),
 
-		nicWhitelistPattern: regexp.MustCompile(fmt.Sprintf("^(?:%s)$", *nicWhitelist)),
-		nicBlacklistPattern: regexp.MustCompile(fmt.Sprintf("^(?:%s)$", *nicBlacklist)),
+		nicFilter: MustNewFilter(settings.String(nicWhitelist), settings.String(nicBlacklist)),
 	}, nil
 }
 
@@ -171,8 +167,7 @@ func (c *NetworkCollector) collect(ctx *ScrapeContext, ch chan<- prometheus.Metr
 	}
 
 	for _, nic := range dst {
-		if c.nicBlacklistPattern.MatchString(nic.Name) ||
-			!c.nicWhitelistPattern.MatchString(nic.Name) {
+		if !c.nicFilter.Match(nic.Name) {
 			continue
 		}
 
@@ -1,12 +1,12 @@
 package collector
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"strconv"
 
-	perflibCollector "github.com/leoluk/perflib_exporter/collector"
-	"github.com/leoluk/perflib_exporter/perflib"
+	"github.com/prometheus-community/windows_exporter/perflib"
 	"github.com/prometheus/common/log"
 )
 
@@ -16,6 +16,25 @@ func MapCounterToIndex(name string) string {
 	return strconv.Itoa(int(nametable.LookupIndex(name)))
 }
 
+// PerflibSource provides the perflib objects a scrape reads its counters from.
+type PerflibSource interface {
+	// Snapshot returns the objects selected by query, indexed by object name.
+	// The query is a space-separated list of object indices as built by
+	// getPerfQuery. It fails if ctx is done before the objects are read.
+	Snapshot(ctx context.Context, query string) (map[string]*perflib.PerfObject, error)
+}
+
+type windowsPerflibSource struct{}
+
+// Snapshot reads the objects from the registry. A read in progress cannot be
+// interrupted, so ctx is only checked before it starts.
+func (windowsPerflibSource) Snapshot(ctx context.Context, query string) (map[string]*perflib.PerfObject, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return getPerflibSnapshot(query)
+}
+
 func getPerflibSnapshot(objNames string) (map[string]*perflib.PerfObject, error) {
 	objects, err := perflib.QueryPerformanceData(objNames)
 	if err != nil {
@@ -81,9 +100,9 @@ func unmarshalObject(obj *perflib.PerfObject, vs interface{}) error {
 			}
 
 			switch ctr.Def.CounterType {
-			case perflibCollector.PERF_ELAPSED_TIME:
+			case perflib.PERF_ELAPSED_TIME:
 				target.Field(i).SetFloat(float64(ctr.Value-windowsEpoch) / float64(obj.Frequency))
-			case perflibCollector.PERF_100NSEC_TIMER, perflibCollector.PERF_PRECISION_100NS_TIMER:
+			case perflib.PERF_100NSEC_TIMER, perflib.PERF_PRECISION_100NS_TIMER:
 				target.Field(i).SetFloat(float64(ctr.Value) * ticksToSecondsScaleFactor)
 			default:
 				target.Field(i).SetFloat(float64(ctr.Value))
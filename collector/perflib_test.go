@@ -4,8 +4,7 @@ import (
 	"reflect"
 	"testing"
 
-	perflibCollector "github.com/leoluk/perflib_exporter/collector"
-	"github.com/leoluk/perflib_exporter/perflib"
+	"github.com/prometheus-community/windows_exporter/perflib"
 )
 
 type simple struct {
@@ -36,7 +35,7 @@ func TestUnmarshalPerflib(t *testing.T) {
 							{
 								Def: &perflib.PerfCounterDef{
 									Name:        "Something",
-									CounterType: perflibCollector.PERF_COUNTER_COUNTER,
+									CounterType: perflib.PERF_COUNTER_COUNTER,
 								},
 								Value: 123,
 							},
@@ -56,14 +55,14 @@ func TestUnmarshalPerflib(t *testing.T) {
 							{
 								Def: &perflib.PerfCounterDef{
 									Name:        "Something",
-									CounterType: perflibCollector.PERF_COUNTER_COUNTER,
+									CounterType: perflib.PERF_COUNTER_COUNTER,
 								},
 								Value: 123,
 							},
 							{
 								Def: &perflib.PerfCounterDef{
 									Name:        "Something Else",
-									CounterType: perflibCollector.PERF_COUNTER_COUNTER,
+									CounterType: perflib.PERF_COUNTER_COUNTER,
 								},
 								Value: 256,
 							},
@@ -83,7 +82,7 @@ func TestUnmarshalPerflib(t *testing.T) {
 							{
 								Def: &perflib.PerfCounterDef{
 									Name:        "Something",
-									CounterType: perflibCollector.PERF_COUNTER_COUNTER,
+									CounterType: perflib.PERF_COUNTER_COUNTER,
 								},
 								Value: 321,
 							},
@@ -94,7 +93,7 @@ func TestUnmarshalPerflib(t *testing.T) {
 							{
 								Def: &perflib.PerfCounterDef{
 									Name:        "Something",
-									CounterType: perflibCollector.PERF_COUNTER_COUNTER,
+									CounterType: perflib.PERF_COUNTER_COUNTER,
 								},
 								Value: 231,
 							},
@@ -1,32 +1,28 @@
-// +build windows
-
 package collector
 
 import (
-	"fmt"
-	"regexp"
 	"strconv"
 	"strings"
 
-	"github.com/StackExchange/wmi"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
-	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 func init() {
-	registerCollector("process", newProcessCollector, "Process")
+	registerConfigurableCollector("process", newProcessCollector, "Process")
 }
 
 var (
-	processWhitelist = kingpin.Flag(
+	processWhitelist = stringSetting(
 		"collector.process.whitelist",
 		"Regexp of processes to include. Process name must both match whitelist and not match blacklist to be included.",
-	).Default(".*").String()
-	processBlacklist = kingpin.Flag(
+		".*",
+	)
+	processBlacklist = stringSetting(
 		"collector.process.blacklist",
 		"Regexp of processes to exclude. Process name must both match whitelist and not match blacklist to be included.",
-	).Default("").String()
+		"",
+	)
 )
 
 type processCollector struct {
@@ -44,15 +40,14 @@ type processCollector struct {
 	VirtualBytes      *prometheus.Desc
 	WorkingSet        *prometheus.Desc
 
-	processWhitelistPattern *regexp.Regexp
-	processBlacklistPattern *regexp.Regexp
+	processFilter *Filter
 }
 
 // NewProcessCollector ...
-func newProcessCollector() (Collector, error) {
+func newProcessCollector(settings Settings) (Collector, error) {
 	const subsystem = "process"
 
-	if *processWhitelist == ".*" && *processBlacklist == "" {
+	if settings.String(processWhitelist) == ".*" && settings.String(processBlacklist) == "" {
 		log.Warn("No filters specified for process collector. This will generate a very large number of metrics!")
 	}
 
@@ -135,8 +130,7 @@ func newProcessCollector() (Collector, error) {
 			[]string{"process", "process_id", "creating_process_id"},
 			nil,
 		),
-		processWhitelistPattern: regexp.MustCompile(fmt.Sprintf("^(?:%s)$", *processWhitelist)),
-		processBlacklistPattern: regexp.MustCompile(fmt.Sprintf("^(?:%s)$", *processBlacklist)),
+		processFilter: MustNewFilter(settings.String(processWhitelist), settings.String(processBlacklist)),
 	}, nil
 }
 
@@ -186,14 +180,13 @@ func (c *processCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metr
 
 	var dst_wp []WorkerProcess
 	q_wp := queryAll(&dst_wp)
-	if err := wmi.QueryNamespace(q_wp, &dst_wp, "root\\WebAdministration"); err != nil {
+	if err := ctx.wmi.QueryNamespace(q_wp, &dst_wp, "root\\WebAdministration"); err != nil {
 		log.Debugf("Could not query WebAdministration namespace for IIS worker processes: %v. Skipping", err)
 	}
 
 	for _, process := range data {
 		if process.Name == "_Total" ||
-			c.processBlacklistPattern.MatchString(process.Name) ||
-			!c.processWhitelistPattern.MatchString(process.Name) {
+			!c.processFilter.Match(process.Name) {
 			continue
 		}
 		// Duplicate processes are suffixed # and an index number. Remove those.
@@ -0,0 +1,274 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus-community/windows_exporter/perflib"
+	"github.com/prometheus/common/log"
+)
+
+// RecordingVersion is the version of the recording file format written by
+// Recorder. Recordings with a different version are rejected on load.
+const RecordingVersion = 1
+
+// Recording is the serialised form of everything a single scrape read from
+// perflib and WMI.
+type Recording struct {
+	Version     int                            `json:"version"`
+	Timestamp   time.Time                      `json:"timestamp"`
+	Collectors  []string                       `json:"collectors"`
+	PerfQuery   string                         `json:"perflib_query"`
+	PerfObjects map[string]*perflib.PerfObject `json:"perflib_objects"`
+	WMI         []RecordedWMIResult            `json:"wmi"`
+	// Partial is set when collectors were still running as the recording
+	// was written. Their remaining queries are missing from the recording.
+	Partial bool     `json:"partial,omitempty"`
+	Running []string `json:"running,omitempty"`
+}
+
+// RecordedWMIResult is the result set of a single WMI query, or the error it
+// failed with.
+type RecordedWMIResult struct {
+	Namespace string          `json:"namespace"`
+	Query     string          `json:"query"`
+	Rows      json.RawMessage `json:"rows,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// Sources returns a PerflibSource and WMIQuerier serving the recorded data.
+func (r *Recording) Sources() (PerflibSource, WMIQuerier, error) {
+	q := NewFixtureWMIQuerier()
+	for _, res := range r.WMI {
+		if res.Error != "" {
+			q.AddError(res.Namespace, res.Query, res.Error)
+			continue
+		}
+		if err := q.Add(res.Namespace, res.Query, res.Rows); err != nil {
+			return nil, nil, err
+		}
+	}
+	return &FixturePerflibSource{Objects: r.PerfObjects}, q, nil
+}
+
+// LoadRecording reads a recording written by Recorder.WriteFile.
+func LoadRecording(path string) (*Recording, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r Recording
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse recording %s: %v", path, err)
+	}
+	if r.Version != RecordingVersion {
+		return nil, fmt.Errorf("recording %s has version %d, expected %d", path, r.Version, RecordingVersion)
+	}
+	return &r, nil
+}
+
+// Recorder wraps a PerflibSource and a WMIQuerier, and records every perflib
+// object and WMI result set passing through it. A Recorder covers a single
+// scrape, and is safe for concurrent use by the collectors of that scrape.
+type Recorder struct {
+	perflibSource PerflibSource
+	wmiQuerier    WMIQuerier
+
+	mtx       sync.Mutex
+	recording Recording
+}
+
+// NewRecorder returns a Recorder for a scrape of the given collectors.
+func NewRecorder(collectors []string, perflibSource PerflibSource, wmiQuerier WMIQuerier) *Recorder {
+	return &Recorder{
+		perflibSource: perflibSource,
+		wmiQuerier:    wmiQuerier,
+		recording: Recording{
+			Version:    RecordingVersion,
+			Timestamp:  time.Now(),
+			Collectors: collectors,
+			WMI:        []RecordedWMIResult{},
+		},
+	}
+}
+
+// Snapshot takes a snapshot from the wrapped PerflibSource and records it.
+func (r *Recorder) Snapshot(ctx context.Context, query string) (map[string]*perflib.PerfObject, error) {
+	objs, err := r.perflibSource.Snapshot(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.recording.PerfQuery = query
+	r.recording.PerfObjects = objs
+	return objs, nil
+}
+
+// Query runs query through the wrapped WMIQuerier and records the result,
+// or the error it failed with.
+func (r *Recorder) Query(query string, dst interface{}) error {
+	if err := r.wmiQuerier.Query(query, dst); err != nil {
+		r.recordWMIError(DefaultWMINamespace, query, err)
+		return err
+	}
+	return r.recordWMI(DefaultWMINamespace, query, dst)
+}
+
+// QueryNamespace runs query through the wrapped WMIQuerier and records the
+// result, or the error it failed with.
+func (r *Recorder) QueryNamespace(query string, dst interface{}, namespace string) error {
+	if err := r.wmiQuerier.QueryNamespace(query, dst, namespace); err != nil {
+		r.recordWMIError(namespace, query, err)
+		return err
+	}
+	return r.recordWMI(namespace, query, dst)
+}
+
+func (r *Recorder) recordWMI(namespace, query string, dst interface{}) error {
+	rows, err := json.Marshal(dst)
+	if err != nil {
+		return fmt.Errorf("failed to record result of %q: %v", query, err)
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.recording.WMI = append(r.recording.WMI, RecordedWMIResult{
+		Namespace: namespace,
+		Query:     query,
+		Rows:      rows,
+	})
+	return nil
+}
+
+func (r *Recorder) recordWMIError(namespace, query string, err error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.recording.WMI = append(r.recording.WMI, RecordedWMIResult{
+		Namespace: namespace,
+		Query:     query,
+		Error:     err.Error(),
+	})
+}
+
+// MarkPartial flags the recording as partial, as the named collectors were
+// still running when the scrape ended.
+func (r *Recorder) MarkPartial(running []string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.recording.Partial = true
+	r.recording.Running = append([]string(nil), running...)
+	sort.Strings(r.recording.Running)
+}
+
+// WriteFile writes the recording into dir, and returns the path of the file.
+func (r *Recorder) WriteFile(dir string) (string, error) {
+	r.mtx.Lock()
+	b, err := json.MarshalIndent(&r.recording, "", "  ")
+	ts := r.recording.Timestamp
+	r.mtx.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("scrape-%d.json", ts.UnixNano()))
+	// Write to a temporary file first, so a replayer never sees partial files.
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp, path)
+}
+
+// PruneRecordings removes the oldest recordings in dir, so at most keep are
+// left. Leftover temporary files of interrupted writes are removed as well.
+func PruneRecordings(dir string, keep int) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var recordings []string
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), "scrape-") {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(e.Name(), ".json"):
+			recordings = append(recordings, e.Name())
+		case strings.HasSuffix(e.Name(), ".json.tmp") && time.Since(e.ModTime()) > time.Hour:
+			// Files being written right now are recent, so only stale
+			// ones are removed.
+			if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	if len(recordings) <= keep {
+		return nil
+	}
+	// Names hold the timestamp of the scrape, so they sort oldest first.
+	sort.Strings(recordings)
+	for _, name := range recordings[:len(recordings)-keep] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replayer serves the recordings found in a directory, one per scrape, in
+// lexical file name order. Once all recordings have been served, it starts
+// over with the first one.
+type Replayer struct {
+	files []string
+
+	mtx  sync.Mutex
+	next int
+}
+
+// NewReplayer returns a Replayer for the recordings in dir.
+func NewReplayer(dir string) (*Replayer, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		files = append(files, filepath.Join(dir, e.Name()))
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no recordings found in %s", dir)
+	}
+	sort.Strings(files)
+	return &Replayer{files: files}, nil
+}
+
+// Next loads the next recording and returns sources serving its data.
+func (r *Replayer) Next() (PerflibSource, WMIQuerier, error) {
+	r.mtx.Lock()
+	path := r.files[r.next]
+	r.next = (r.next + 1) % len(r.files)
+	r.mtx.Unlock()
+
+	rec, err := LoadRecording(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rec.Partial {
+		log.Warnf("Recording %s is partial, collectors %v were still running when it was written", path, rec.Running)
+	}
+	return rec.Sources()
+}
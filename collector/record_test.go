@@ -0,0 +1,127 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus-community/windows_exporter/perflib"
+)
+
+type recordedWmiClass struct {
+	Name  string
+	Value uint64
+}
+
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "windows_exporter_recording")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rows := []recordedWmiClass{{Name: "a", Value: 1}, {Name: "b", Value: 2}}
+	live := NewFixtureWMIQuerier()
+	if err := live.Add("root\\WebAdministration", "SELECT * FROM recordedWmiClass", rows); err != nil {
+		t.Fatal(err)
+	}
+	obj := &perflib.PerfObject{
+		Name:      "System",
+		Frequency: 10000000,
+		Instances: []*perflib.PerfInstance{
+			{Counters: []*perflib.PerfCounter{{Def: &perflib.PerfCounterDef{Name: "Threads"}, Value: 42}}},
+		},
+	}
+
+	r := NewRecorder([]string{"test"}, NewFixturePerflibSource(obj), live)
+	if _, err := r.Snapshot(context.Background(), "2"); err != nil {
+		t.Fatal(err)
+	}
+	var dst []recordedWmiClass
+	if err := r.QueryNamespace("SELECT * FROM recordedWmiClass", &dst, "root\\WebAdministration"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Query("SELECT * FROM missingClass", &dst); err == nil {
+		t.Fatal("expected a query without fixture to fail")
+	}
+	r.MarkPartial([]string{"test"})
+	path, err := r.WriteFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := LoadRecording(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rec.Partial || !reflect.DeepEqual(rec.Running, []string{"test"}) {
+		t.Errorf("expected the recording to be partial with collector test running, got partial=%v running=%v", rec.Partial, rec.Running)
+	}
+
+	replayer, err := NewReplayer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	perflibSource, wmiQuerier, err := replayer.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objs, err := perflibSource.Snapshot(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(objs["System"], obj) {
+		t.Errorf("replayed perflib object mismatch, expected %+v, got %+v", obj, objs["System"])
+	}
+
+	var replayed []recordedWmiClass
+	if err := wmiQuerier.QueryNamespace("SELECT * FROM recordedWmiClass", &replayed, "root\\WebAdministration"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(replayed, rows) {
+		t.Errorf("replayed WMI rows mismatch, expected %+v, got %+v", rows, replayed)
+	}
+
+	expectedErr := `no fixture for WMI query "SELECT * FROM missingClass" in namespace "root\\cimv2"`
+	if err := wmiQuerier.Query("SELECT * FROM missingClass", &replayed); err == nil || err.Error() != expectedErr {
+		t.Errorf("expected the recorded error %q to be replayed, got %v", expectedErr, err)
+	}
+}
+
+func TestPruneRecordings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "windows_exporter_recording")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i := 1; i <= 5; i++ {
+		name := filepath.Join(dir, fmt.Sprintf("scrape-%d.json", 1600000000000000000+i))
+		if err := ioutil.WriteFile(name, []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := PruneRecordings(dir, 2); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	expected := []string{"notes.txt", "scrape-1600000000000000004.json", "scrape-1600000000000000005.json"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v to be left, got %v", expected, names)
+	}
+}
@@ -0,0 +1,10 @@
+package collector
+
+// registryKey is an open registry key, as returned by openLocalMachineKey.
+type registryKey interface {
+	GetStringValue(name string) (string, error)
+	GetIntegerValue(name string) (uint64, error)
+	// ReadValueNames returns the names of all values of the key.
+	ReadValueNames() ([]string, error)
+	Close() error
+}
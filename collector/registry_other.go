@@ -0,0 +1,10 @@
+// +build !windows
+
+package collector
+
+import "errors"
+
+// openLocalMachineKey fails, as the registry is only available on Windows.
+func openLocalMachineKey(path string) (registryKey, error) {
+	return nil, errors.New("the registry is only available on Windows")
+}
@@ -0,0 +1,32 @@
+package collector
+
+import (
+	"golang.org/x/sys/windows/registry"
+)
+
+// openLocalMachineKey opens a key of HKEY_LOCAL_MACHINE for reading.
+func openLocalMachineKey(path string) (registryKey, error) {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, path, registry.QUERY_VALUE)
+	if err != nil {
+		return nil, err
+	}
+	return windowsRegistryKey{k}, nil
+}
+
+type windowsRegistryKey struct {
+	registry.Key
+}
+
+func (k windowsRegistryKey) GetStringValue(name string) (string, error) {
+	v, _, err := k.Key.GetStringValue(name)
+	return v, err
+}
+
+func (k windowsRegistryKey) GetIntegerValue(name string) (uint64, error) {
+	v, _, err := k.Key.GetIntegerValue(name)
+	return v, err
+}
+
+func (k windowsRegistryKey) ReadValueNames() ([]string, error) {
+	return k.Key.ReadValueNames(0)
+}
@@ -1,26 +1,23 @@
-// +build windows
-
 package collector
 
 import (
 	"strconv"
 	"strings"
 
-	"github.com/StackExchange/wmi"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
-	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 func init() {
-	registerCollector("service", NewserviceCollector)
+	registerConfigurableCollector("service", NewserviceCollector)
 }
 
 var (
-	serviceWhereClause = kingpin.Flag(
+	serviceWhereClause = stringSetting(
 		"collector.service.services-where",
 		"WQL 'where' clause to use in WMI metrics query. Limits the response to the services you specify and reduces the size of the response.",
-	).Default("").String()
+		"",
+	)
 )
 
 // A serviceCollector is a Prometheus collector for WMI Win32_Service metrics
@@ -34,10 +31,10 @@ type serviceCollector struct {
 }
 
 // NewserviceCollector ...
-func NewserviceCollector() (Collector, error) {
+func NewserviceCollector(settings Settings) (Collector, error) {
 	const subsystem = "service"
 
-	if *serviceWhereClause == "" {
+	if settings.String(serviceWhereClause) == "" {
 		log.Warn("No where-clause specified for service collector. This will generate a very large number of metrics!")
 	}
 
@@ -66,14 +63,14 @@ func NewserviceCollector() (Collector, error) {
 			[]string{"name", "status"},
 			nil,
 		),
-		queryWhereClause: *serviceWhereClause,
+		queryWhereClause: settings.String(serviceWhereClause),
 	}, nil
 }
 
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *serviceCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) error {
-	if desc, err := c.collect(ch); err != nil {
+	if desc, err := c.collect(ctx, ch); err != nil {
 		log.Error("failed collecting service metrics:", desc, err)
 		return err
 	}
@@ -126,10 +123,10 @@ var (
 	}
 )
 
-func (c *serviceCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *serviceCollector) collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_Service
 	q := queryAllWhere(&dst, c.queryWhereClause)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmi.Query(q, &dst); err != nil {
 		return nil, err
 	}
 	for _, service := range dst {
@@ -0,0 +1,103 @@
+package collector
+
+import (
+	"strconv"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Settings holds the values collectors are built with. Collectors read their
+// flags from it rather than from the command line, so differently configured
+// instances can be built while others are in use.
+type Settings struct {
+	// Flags holds the values of collector flags, keyed by flag name. Flags
+	// missing from it take their default value.
+	Flags map[string]string
+	// MergePerfCounterDependencies makes the collector read the perflib
+	// objects of the instances built before, in addition to its own. It is
+	// set to build further instances of collectors with different settings.
+	MergePerfCounterDependencies bool
+
+	// record collects what the builder reads, if set by Rebuild.
+	record *buildRecord
+}
+
+// buildRecord holds the values of the flags a builder read, and the perflib
+// objects it made the collector depend on, if it did.
+type buildRecord struct {
+	flags        map[setting]string
+	perfCounters []string
+	// hasPerfCounters is set if the builder set the perflib objects, rather
+	// than leaving those of registerConfigurableCollector.
+	hasPerfCounters bool
+}
+
+// setting is a flag of a collector, whose value is read from Settings.
+type setting struct {
+	name string
+	def  string
+}
+
+// stringSetting declares a string flag of a collector on the command line.
+func stringSetting(name, help, def string) setting {
+	f := kingpin.Flag(name, help)
+	if def != "" {
+		f.Default(def)
+	}
+	f.String()
+	return setting{name: name, def: def}
+}
+
+// boolSetting declares a boolean flag of a collector on the command line.
+func boolSetting(name, help string) setting {
+	kingpin.Flag(name, help).Bool()
+	return setting{name: name, def: "false"}
+}
+
+// enumSetting declares a flag of a collector on the command line, taking one
+// of options.
+func enumSetting(name, help, def string, options ...string) setting {
+	kingpin.Flag(name, help).Default(def).Enum(options...)
+	return setting{name: name, def: def}
+}
+
+// String returns the value of the flag.
+func (s Settings) String(f setting) string {
+	v, ok := s.Flags[f.name]
+	if !ok {
+		v = f.def
+	}
+	if s.record != nil {
+		s.record.flags[f] = v
+	}
+	return v
+}
+
+// Bool returns the value of a boolean flag. Values which are not booleans
+// read as false.
+func (s Settings) Bool(f setting) bool {
+	v, err := strconv.ParseBool(s.String(f))
+	return err == nil && v
+}
+
+// setPerfCounterDependencies sets the perflib objects read for the named
+// collector, which is being built with s.
+func (s Settings) setPerfCounterDependencies(name string, perfCounterNames []string) {
+	if s.record != nil {
+		s.record.perfCounters = perfCounterNames
+		s.record.hasPerfCounters = true
+	}
+	addPerfCounterDependencies(name, perfCounterNames, s.MergePerfCounterDependencies)
+}
+
+// unchanged reports whether settings give the flags read into r the same
+// values.
+func (r *buildRecord) unchanged(settings Settings) bool {
+	settings.record = nil
+	for f, v := range r.flags {
+		if settings.String(f) != v {
+			return false
+		}
+	}
+	return true
+}
@@ -1,12 +1,9 @@
-// +build windows
-
 package collector
 
 import (
 	"errors"
 	"strings"
 
-	"github.com/StackExchange/wmi"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
 )
@@ -28,7 +25,7 @@ type Win32_ServerFeature struct {
 func isConnectionBrokerServer() bool {
 	var dst []Win32_ServerFeature
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := DefaultWMIQuerier.Query(q, &dst); err != nil {
 		return false
 	}
 	for _, d := range dst {
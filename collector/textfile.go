@@ -23,6 +23,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dimchansky/utfbom"
@@ -30,14 +31,19 @@ import (
 	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
 	"github.com/prometheus/common/log"
-	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
 var (
-	textFileDirectory = kingpin.Flag(
+	textFileDirectory = stringSetting(
 		"collector.textfile.directory",
 		"Directory to read text files with metrics from.",
-	).Default("C:\\Program Files\\windows_exporter\\textfile_inputs").String()
+		"C:\\Program Files\\windows_exporter\\textfile_inputs",
+	)
+	textFileTimestamps = enumSetting(
+		"collector.textfile.timestamps",
+		"What to do with files of the textfile directory holding samples with a timestamp. \"reject\" skips the whole file, \"strip\" drops the timestamps, \"honour\" exposes the samples with their timestamps.",
+		textFileTimestampsReject, textFileTimestampsReject, textFileTimestampsStrip, textFileTimestampsHonour,
+	)
 
 	mtimeDesc = prometheus.NewDesc(
 		prometheus.BuildFQName(Namespace, "textfile", "mtime_seconds"),
@@ -45,23 +51,44 @@ var (
 		[]string{"file"},
 		nil,
 	)
+	timestampedSamplesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "textfile", "timestamped_samples_total"),
+		"Number of samples with a timestamp read from textfiles, which were rejected, stripped or honoured as set by --collector.textfile.timestamps.",
+		[]string{"file", "policy"},
+		nil,
+	)
+)
+
+// Policies for samples with a timestamp.
+const (
+	textFileTimestampsReject = "reject"
+	textFileTimestampsStrip  = "strip"
+	textFileTimestampsHonour = "honour"
 )
 
 type textFileCollector struct {
 	path string
+	// timestamps is the policy for samples with a timestamp.
+	timestamps string
 	// Only set for testing to get predictable output.
 	mtime *float64
+
+	mtx sync.Mutex
+	// timestampedSamples counts the samples with a timestamp by file, for
+	// the files still in the directory.
+	timestampedSamples map[string]float64
 }
 
 func init() {
-	registerCollector("textfile", NewTextFileCollector)
+	registerConfigurableCollector("textfile", NewTextFileCollector)
 }
 
 // NewTextFileCollector returns a new Collector exposing metrics read from files
 // in the given textfile directory.
-func NewTextFileCollector() (Collector, error) {
+func NewTextFileCollector(settings Settings) (Collector, error) {
 	return &textFileCollector{
-		path: *textFileDirectory,
+		path:       settings.String(textFileDirectory),
+		timestamps: settings.String(textFileTimestamps),
 	}, nil
 }
 
@@ -80,8 +107,11 @@ func convertMetricFamily(metricFamily *dto.MetricFamily, ch chan<- prometheus.Me
 	}
 
 	for _, metric := range metricFamily.Metric {
-		if metric.TimestampMs != nil {
-			log.Warnf("Ignoring unsupported custom timestamp on textfile collector metric %v", metric)
+		send := func(m prometheus.Metric) {
+			if metric.TimestampMs != nil {
+				m = prometheus.NewMetricWithTimestamp(time.Unix(0, metric.GetTimestampMs()*int64(time.Millisecond)), m)
+			}
+			ch <- m
 		}
 
 		labels := metric.GetLabel()
@@ -125,7 +155,7 @@ func convertMetricFamily(metricFamily *dto.MetricFamily, ch chan<- prometheus.Me
 			for _, q := range metric.Summary.Quantile {
 				quantiles[q.GetQuantile()] = q.GetValue()
 			}
-			ch <- prometheus.MustNewConstSummary(
+			send(prometheus.MustNewConstSummary(
 				prometheus.NewDesc(
 					*metricFamily.Name,
 					metricFamily.GetHelp(),
@@ -134,13 +164,13 @@ func convertMetricFamily(metricFamily *dto.MetricFamily, ch chan<- prometheus.Me
 				metric.Summary.GetSampleCount(),
 				metric.Summary.GetSampleSum(),
 				quantiles, values...,
-			)
+			))
 		case dto.MetricType_HISTOGRAM:
 			buckets := map[float64]uint64{}
 			for _, b := range metric.Histogram.Bucket {
 				buckets[b.GetUpperBound()] = b.GetCumulativeCount()
 			}
-			ch <- prometheus.MustNewConstHistogram(
+			send(prometheus.MustNewConstHistogram(
 				prometheus.NewDesc(
 					*metricFamily.Name,
 					metricFamily.GetHelp(),
@@ -149,20 +179,20 @@ func convertMetricFamily(metricFamily *dto.MetricFamily, ch chan<- prometheus.Me
 				metric.Histogram.GetSampleCount(),
 				metric.Histogram.GetSampleSum(),
 				buckets, values...,
-			)
+			))
 		default:
 			log.Errorf("unknown metric type for file")
 			continue
 		}
 		if metricType == dto.MetricType_GAUGE || metricType == dto.MetricType_COUNTER || metricType == dto.MetricType_UNTYPED {
-			ch <- prometheus.MustNewConstMetric(
+			send(prometheus.MustNewConstMetric(
 				prometheus.NewDesc(
 					*metricFamily.Name,
 					metricFamily.GetHelp(),
 					names, nil,
 				),
 				valType, val, values...,
-			)
+			))
 		}
 	}
 }
@@ -187,6 +217,31 @@ func (c *textFileCollector) exportMTimes(mtimes map[string]time.Time, ch chan<-
 	}
 }
 
+func (c *textFileCollector) countTimestamped(filename string, samples int) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.timestampedSamples == nil {
+		c.timestampedSamples = make(map[string]float64)
+	}
+	c.timestampedSamples[filename] += float64(samples)
+}
+
+// exportTimestamped exports the counts of samples with a timestamp. The
+// counts of files missing from files, the files of the directory, are
+// dropped, so removed files do not accumulate. They are all kept if files is
+// nil, as the directory could not be read.
+func (c *textFileCollector) exportTimestamped(files map[string]bool, ch chan<- prometheus.Metric) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for filename, samples := range c.timestampedSamples {
+		if files != nil && !files[filename] {
+			delete(c.timestampedSamples, filename)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(timestampedSamplesDesc, prometheus.CounterValue, samples, filename, c.timestamps)
+	}
+}
+
 type carriageReturnFilteringReader struct {
 	r io.Reader
 }
@@ -223,11 +278,19 @@ func (c *textFileCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Met
 		error = 1.0
 	}
 
-fileLoop:
+	var present map[string]bool
+	if err == nil {
+		present = make(map[string]bool, len(files))
+	}
 	for _, f := range files {
 		if !strings.HasSuffix(f.Name(), ".prom") {
 			continue
 		}
+		present[f.Name()] = true
+		// Files are not read once the scrape is done.
+		if err := ctx.Context().Err(); err != nil {
+			return err
+		}
 		path := filepath.Join(c.path, f.Name())
 		log.Debugf("Processing file %q", path)
 		file, err := os.Open(path)
@@ -253,14 +316,26 @@ fileLoop:
 			error = 1.0
 			continue
 		}
+		timestamped := 0
 		for _, mf := range parsedFamilies {
 			for _, m := range mf.Metric {
 				if m.TimestampMs != nil {
-					log.Errorf("Textfile %q contains unsupported client-side timestamps, skipping entire file", path)
-					error = 1.0
-					continue fileLoop
+					timestamped++
+					if c.timestamps == textFileTimestampsStrip {
+						m.TimestampMs = nil
+					}
 				}
 			}
+		}
+		if timestamped > 0 {
+			c.countTimestamped(f.Name(), timestamped)
+			if c.timestamps == textFileTimestampsReject {
+				log.Errorf("Textfile %q contains %d samples with client-side timestamps, skipping entire file", path, timestamped)
+				error = 1.0
+				continue
+			}
+		}
+		for _, mf := range parsedFamilies {
 			if mf.Help == nil {
 				help := fmt.Sprintf("Metric read from %s", path)
 				mf.Help = &help
@@ -277,6 +352,7 @@ fileLoop:
 	}
 
 	c.exportMTimes(mtimes, ch)
+	c.exportTimestamped(present, ch)
 
 	// Export if there were errors.
 	ch <- prometheus.MustNewConstMetric(
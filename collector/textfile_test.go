@@ -1,10 +1,16 @@
 package collector
 
 import (
-	"github.com/dimchansky/utfbom"
+	"context"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/dimchansky/utfbom"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 )
 
 func TestCRFilter(t *testing.T) {
@@ -45,3 +51,105 @@ func TestCheckBOM(t *testing.T) {
 		}
 	}
 }
+
+func TestTextFileTimestamps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "textfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"backup.prom": "backup_last_success_timestamp_seconds{job=\"daily\"} 1.6e+09 1600000000000\nbackup_runs_total 3\n",
+		"plain.prom":  "plain_value 1\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scrapeContext, err := PrepareScrapeContext(context.Background(), nil, NewFixturePerflibSource(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collect := func(c *textFileCollector) map[string]*dto.Metric {
+		ch := make(chan prometheus.Metric)
+		go func() {
+			if err := c.Collect(scrapeContext, ch); err != nil {
+				t.Error(err)
+			}
+			close(ch)
+		}()
+		metrics := make(map[string]*dto.Metric)
+		for m := range ch {
+			pb := &dto.Metric{}
+			if err := m.Write(pb); err != nil {
+				t.Fatal(err)
+			}
+			name := m.Desc().String()
+			name = name[strings.Index(name, `"`)+1:]
+			metrics[name[:strings.Index(name, `"`)]] = pb
+		}
+		return metrics
+	}
+
+	for _, tc := range []struct {
+		policy    string
+		timestamp int64
+		scrapeErr float64
+		rejected  bool
+	}{
+		{textFileTimestampsReject, 0, 1, true},
+		{textFileTimestampsStrip, 0, 0, false},
+		{textFileTimestampsHonour, 1600000000000, 0, false},
+	} {
+		c := &textFileCollector{path: dir, timestamps: tc.policy}
+		// Scrape twice, so the count accumulates across scrapes.
+		collect(c)
+		metrics := collect(c)
+
+		backup, ok := metrics["backup_last_success_timestamp_seconds"]
+		if tc.rejected {
+			if ok || metrics["backup_runs_total"] != nil {
+				t.Errorf("%s: expected the file with timestamps to be skipped", tc.policy)
+			}
+		} else if !ok || backup.GetTimestampMs() != tc.timestamp || metrics["backup_runs_total"].TimestampMs != nil {
+			t.Errorf("%s: expected a timestamp of %d, got %+v", tc.policy, tc.timestamp, backup)
+		}
+		if metrics["plain_value"] == nil {
+			t.Errorf("%s: expected the file without timestamps to be read", tc.policy)
+		}
+		if got := metrics["windows_textfile_scrape_error"].GetGauge().GetValue(); got != tc.scrapeErr {
+			t.Errorf("%s: expected a scrape error of %v, got %v", tc.policy, tc.scrapeErr, got)
+		}
+		counter := metrics["windows_textfile_timestamped_samples_total"]
+		labels := map[string]string{}
+		for _, l := range counter.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		if counter.GetCounter().GetValue() != 2 || labels["file"] != "backup.prom" || labels["policy"] != tc.policy {
+			t.Errorf("%s: expected 2 timestamped samples counted in backup.prom after 2 scrapes, got %+v", tc.policy, counter)
+		}
+	}
+
+	// Files which are gone are no longer reported.
+	c := &textFileCollector{path: dir, timestamps: textFileTimestampsStrip}
+	collect(c)
+	if err := os.Remove(filepath.Join(dir, "backup.prom")); err != nil {
+		t.Fatal(err)
+	}
+	if counter, ok := collect(c)["windows_textfile_timestamped_samples_total"]; ok {
+		t.Errorf("expected no timestamped samples once backup.prom is removed, got %+v", counter)
+	}
+
+	// No file is read once the scrape is done.
+	done, cancel := context.WithCancel(context.Background())
+	cancel()
+	ch := make(chan prometheus.Metric, 10)
+	if err := c.Collect(scrapeContext.WithContext(done), ch); err != context.Canceled {
+		t.Errorf("expected the collector to stop with the scrape, got %v", err)
+	}
+	if len(ch) != 0 {
+		t.Errorf("expected no metrics once the scrape is done, got %d", len(ch))
+	}
+}
@@ -0,0 +1,143 @@
+package collector
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus-community/windows_exporter/perflib"
+)
+
+// Trace describes the perflib snapshot and the WMI queries of a single
+// scrape.
+type Trace struct {
+	// PerfQuery is the space-separated list of perflib object indices of
+	// the snapshot.
+	PerfQuery       string   `json:"perf_query"`
+	SnapshotSeconds float64  `json:"snapshot_seconds"`
+	PerfObjects     []string `json:"perf_objects"`
+	SnapshotError   string   `json:"snapshot_error,omitempty"`
+	// WMI holds the WMI queries in the order they returned.
+	WMI []TracedWMIQuery `json:"wmi_queries"`
+}
+
+// TracedWMIQuery describes a single WMI query.
+type TracedWMIQuery struct {
+	// Collector is the name of the collector issuing the query, if known.
+	Collector string  `json:"collector,omitempty"`
+	Namespace string  `json:"namespace"`
+	Query     string  `json:"query"`
+	Seconds   float64 `json:"seconds"`
+	Rows      int     `json:"rows"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Tracer wraps a PerflibSource and a WMIQuerier, and traces the snapshot and
+// the queries passing through it. Like a Recorder, a Tracer covers a single
+// scrape, and is safe for concurrent use by the collectors of that scrape.
+type Tracer struct {
+	perflibSource PerflibSource
+	wmiQuerier    WMIQuerier
+
+	mtx   sync.Mutex
+	trace Trace
+}
+
+// NewTracer returns a Tracer of the given sources.
+func NewTracer(perflibSource PerflibSource, wmiQuerier WMIQuerier) *Tracer {
+	return &Tracer{
+		perflibSource: perflibSource,
+		wmiQuerier:    wmiQuerier,
+		trace: Trace{
+			PerfObjects: []string{},
+			WMI:         []TracedWMIQuery{},
+		},
+	}
+}
+
+// Snapshot takes a snapshot from the wrapped PerflibSource and traces it.
+func (t *Tracer) Snapshot(ctx context.Context, query string) (map[string]*perflib.PerfObject, error) {
+	start := time.Now()
+	objs, err := t.perflibSource.Snapshot(ctx, query)
+	elapsed := time.Since(start)
+
+	names := make([]string, 0, len(objs))
+	for name := range objs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.trace.PerfQuery = query
+	t.trace.SnapshotSeconds = elapsed.Seconds()
+	t.trace.PerfObjects = names
+	if err != nil {
+		t.trace.SnapshotError = err.Error()
+	}
+	return objs, err
+}
+
+// Query runs query through the wrapped WMIQuerier and traces it.
+func (t *Tracer) Query(query string, dst interface{}) error {
+	return t.traceWMI("", DefaultWMINamespace, query, dst)
+}
+
+// QueryNamespace runs query through the wrapped WMIQuerier and traces it.
+func (t *Tracer) QueryNamespace(query string, dst interface{}, namespace string) error {
+	return t.traceWMI("", namespace, query, dst)
+}
+
+// WMIQuerier returns a WMIQuerier tracing the queries of the named collector.
+func (t *Tracer) WMIQuerier(collector string) WMIQuerier {
+	return collectorTracer{tracer: t, collector: collector}
+}
+
+func (t *Tracer) traceWMI(collector, namespace, query string, dst interface{}) error {
+	start := time.Now()
+	var err error
+	if namespace == DefaultWMINamespace {
+		err = t.wmiQuerier.Query(query, dst)
+	} else {
+		err = t.wmiQuerier.QueryNamespace(query, dst, namespace)
+	}
+	q := TracedWMIQuery{
+		Collector: collector,
+		Namespace: namespace,
+		Query:     query,
+		Seconds:   time.Since(start).Seconds(),
+	}
+	if err != nil {
+		q.Error = err.Error()
+	} else if v := reflect.Indirect(reflect.ValueOf(dst)); v.Kind() == reflect.Slice {
+		q.Rows = v.Len()
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.trace.WMI = append(t.trace.WMI, q)
+	return err
+}
+
+// Trace returns what was traced so far.
+func (t *Tracer) Trace() Trace {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	trace := t.trace
+	trace.WMI = append([]TracedWMIQuery{}, t.trace.WMI...)
+	return trace
+}
+
+// collectorTracer traces the queries of a single collector.
+type collectorTracer struct {
+	tracer    *Tracer
+	collector string
+}
+
+func (q collectorTracer) Query(query string, dst interface{}) error {
+	return q.tracer.traceWMI(q.collector, DefaultWMINamespace, query, dst)
+}
+
+func (q collectorTracer) QueryNamespace(query string, dst interface{}, namespace string) error {
+	return q.tracer.traceWMI(q.collector, namespace, query, dst)
+}
@@ -0,0 +1,55 @@
+package collector
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus-community/windows_exporter/perflib"
+)
+
+func TestTracer(t *testing.T) {
+	wmiQuerier := NewFixtureWMIQuerier()
+	rows := []recordedWmiClass{{Name: "a", Value: 1}, {Name: "b", Value: 2}}
+	if err := wmiQuerier.Add(DefaultWMINamespace, "SELECT * FROM recordedWmiClass", rows); err != nil {
+		t.Fatal(err)
+	}
+	tracer := NewTracer(NewFixturePerflibSource(&perflib.PerfObject{Name: "System"}), wmiQuerier)
+
+	ctx, err := PrepareScrapeContext(context.Background(), nil, tracer, tracer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx = ctx.WithWMIQuerier(tracer.WMIQuerier("test"))
+	var dst []recordedWmiClass
+	if err := ctx.wmi.Query("SELECT * FROM recordedWmiClass", &dst); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.wmi.QueryNamespace("SELECT * FROM missingClass", &dst, "root\\WebAdministration"); err == nil {
+		t.Fatal("expected a query without a fixture to fail")
+	}
+
+	trace := tracer.Trace()
+	if !reflect.DeepEqual(trace.PerfObjects, []string{"System"}) || trace.SnapshotError != "" {
+		t.Errorf("unexpected snapshot trace %+v", trace)
+	}
+	if len(trace.WMI) != 2 {
+		t.Fatalf("expected 2 traced queries, got %+v", trace.WMI)
+	}
+	if q := trace.WMI[0]; q.Collector != "test" || q.Namespace != DefaultWMINamespace || q.Query != "SELECT * FROM recordedWmiClass" || q.Rows != 2 || q.Error != "" {
+		t.Errorf("unexpected trace of a successful query %+v", q)
+	}
+	if q := trace.WMI[1]; q.Collector != "test" || q.Namespace != "root\\WebAdministration" || q.Rows != 0 || q.Error == "" {
+		t.Errorf("unexpected trace of a failed query %+v", q)
+	}
+
+	// Queries are no longer started once the context of the scrape is done.
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := ctx.WithContext(cancelled).WithWMIQuerier(tracer.WMIQuerier("test")).wmi.Query("SELECT * FROM recordedWmiClass", &dst); err == nil {
+		t.Error("expected a query of a done context to fail")
+	}
+	if n := len(tracer.Trace().WMI); n != 2 {
+		t.Errorf("expected the refused query not to be traced, got %d queries", n)
+	}
+}
@@ -1,11 +1,8 @@
-// +build windows
-
 package collector
 
 import (
 	"errors"
 
-	"github.com/StackExchange/wmi"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
 )
@@ -163,11 +160,11 @@ func NewVmwareCollector() (Collector, error) {
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *VmwareCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) error {
-	if desc, err := c.collectMem(ch); err != nil {
+	if desc, err := c.collectMem(ctx, ch); err != nil {
 		log.Error("failed collecting vmware memory metrics:", desc, err)
 		return err
 	}
-	if desc, err := c.collectCpu(ch); err != nil {
+	if desc, err := c.collectCpu(ctx, ch); err != nil {
 		log.Error("failed collecting vmware cpu metrics:", desc, err)
 		return err
 	}
@@ -199,10 +196,10 @@ type Win32_PerfRawData_vmGuestLib_VCPU struct {
 	HostProcessorSpeedMHz uint64
 }
 
-func (c *VmwareCollector) collectMem(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *VmwareCollector) collectMem(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_vmGuestLib_VMem
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmi.Query(q, &dst); err != nil {
 		return nil, err
 	}
 	if len(dst) == 0 {
@@ -288,10 +285,10 @@ func mbToBytes(mb uint64) float64 {
 	return float64(mb * 1024 * 1024)
 }
 
-func (c *VmwareCollector) collectCpu(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *VmwareCollector) collectCpu(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_vmGuestLib_VCPU
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmi.Query(q, &dst); err != nil {
 		return nil, err
 	}
 	if len(dst) == 0 {
@@ -2,11 +2,45 @@ package collector
 
 import (
 	"bytes"
+	"context"
 	"reflect"
 
 	"github.com/prometheus/common/log"
 )
 
+// DefaultWMINamespace is the namespace used by WMIQuerier.Query.
+const DefaultWMINamespace = "root\\cimv2"
+
+// WMIQuerier runs WMI queries on behalf of the collectors. dst must be a
+// pointer to a slice of structs, as accepted by wmi.Query.
+type WMIQuerier interface {
+	// Query runs query in the default namespace.
+	Query(query string, dst interface{}) error
+	// QueryNamespace runs query in the given namespace.
+	QueryNamespace(query string, dst interface{}, namespace string) error
+}
+
+// contextWMIQuerier refuses to start queries once its context is done. A WMI
+// query which has already started cannot be interrupted.
+type contextWMIQuerier struct {
+	ctx     context.Context
+	querier WMIQuerier
+}
+
+func (q contextWMIQuerier) Query(query string, dst interface{}) error {
+	if err := q.ctx.Err(); err != nil {
+		return err
+	}
+	return q.querier.Query(query, dst)
+}
+
+func (q contextWMIQuerier) QueryNamespace(query string, dst interface{}, namespace string) error {
+	if err := q.ctx.Err(); err != nil {
+		return err
+	}
+	return q.querier.QueryNamespace(query, dst, namespace)
+}
+
 func className(src interface{}) string {
 	s := reflect.Indirect(reflect.ValueOf(src))
 	t := s.Type()
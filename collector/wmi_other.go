@@ -0,0 +1,19 @@
+// +build !windows
+
+package collector
+
+import "errors"
+
+var errWMIUnavailable = errors.New("WMI is only available on Windows")
+
+// windowsWMIQuerier fails every query outside of Windows. Collectors are
+// served by a fixture or recording there instead.
+type windowsWMIQuerier struct{}
+
+func (windowsWMIQuerier) Query(query string, dst interface{}) error {
+	return errWMIUnavailable
+}
+
+func (windowsWMIQuerier) QueryNamespace(query string, dst interface{}, namespace string) error {
+	return errWMIUnavailable
+}
@@ -0,0 +1,15 @@
+package collector
+
+import (
+	"github.com/StackExchange/wmi"
+)
+
+type windowsWMIQuerier struct{}
+
+func (windowsWMIQuerier) Query(query string, dst interface{}) error {
+	return wmi.Query(query, dst)
+}
+
+func (windowsWMIQuerier) QueryNamespace(query string, dst interface{}, namespace string) error {
+	return wmi.QueryNamespace(query, dst, namespace)
+}
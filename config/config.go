@@ -14,60 +14,128 @@
 package config
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/prometheus/common/log"
 	"gopkg.in/alecthomas/kingpin.v2"
-	"gopkg.in/yaml.v2"
 )
 
 type getFlagger interface {
 	GetFlag(name string) *kingpin.FlagClause
 }
 
+// Kinds of sources of flag values.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// Source identifies where the value of a flag came from.
+type Source struct {
+	// Kind is one of SourceDefault, SourceFile, SourceEnv and SourceFlag.
+	Kind string
+	// Name is the path of the file, or the name of the environment variable.
+	Name string
+}
+
+func (s Source) String() string {
+	switch s.Kind {
+	case SourceFile:
+		return "configuration file " + s.Name
+	case SourceEnv:
+		return "environment variable " + s.Name
+	case SourceFlag:
+		return "command line"
+	}
+	return s.Kind
+}
+
 // Resolver represents a configuration file resolver for kingpin.
 type Resolver struct {
-	flags map[string]string
+	flags map[string][]string
+	// origins holds the source of each value of flags.
+	origins map[string]Source
+	sources map[string]Source
+	// cli holds the flags given on the command line.
+	cli     map[string]bool
+	modules map[string]Module
 }
 
-// NewResolver returns a Resolver structure.
-func NewResolver(file string) (*Resolver, error) {
-	flags := map[string]string{}
-	log.Infof("Loading configuration file: %v", file)
-	if _, err := os.Stat(file); err != nil {
-		return nil, err
+// NewResolver returns a Resolver structure. Values and modules of later files
+// override those of earlier ones.
+func NewResolver(files ...string) (*Resolver, error) {
+	c := &Resolver{
+		flags:   map[string][]string{},
+		origins: map[string]Source{},
 	}
-	b, err := ioutil.ReadFile(file)
-	if err != nil {
-		return nil, err
+	for _, file := range files {
+		log.Infof("Loading configuration file: %v", file)
+		if _, err := os.Stat(file); err != nil {
+			return nil, err
+		}
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		values, modules, err := parseFile(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		for k, v := range values {
+			c.flags[k] = v
+			c.origins[k] = Source{Kind: SourceFile, Name: file}
+		}
+		// Modules of later files replace those of the same name.
+		for name, m := range modules {
+			if c.modules == nil {
+				c.modules = map[string]Module{}
+			}
+			c.modules[name] = m
+		}
 	}
+	return c, nil
+}
 
-	var rawValues map[string]interface{}
-	err = yaml.Unmarshal(b, &rawValues)
+// ListFiles returns the configuration files to load, in order: files, followed
+// by the .yml and .yaml files of dir in lexical order. dir is ignored if
+// empty.
+func ListFiles(files []string, dir string) ([]string, error) {
+	list := append([]string(nil), files...)
+	if dir == "" {
+		return list, nil
+	}
+	entries, err := ioutil.ReadDir(dir)
 	if err != nil {
 		return nil, err
 	}
-	// Flatten nested YAML values
-	flattenedValues := flatten(rawValues)
-	for k, v := range flattenedValues {
-		if _, ok := flags[k]; !ok {
-			flags[k] = v
+	// ReadDir sorts the entries by name.
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if e.Mode().IsRegular() && (ext == ".yml" || ext == ".yaml") {
+			list = append(list, filepath.Join(dir, e.Name()))
 		}
 	}
-	return &Resolver{flags: flags}, nil
+	return list, nil
 }
 
 func (c *Resolver) setDefault(v getFlagger) {
-	for name, value := range c.flags {
+	for name, values := range c.flags {
 		f := v.GetFlag(name)
 		if f != nil {
-			f.Default(value)
+			f.Default(values...)
 		}
 	}
 }
 
-// Bind sets active flags with their default values from the configuration file(s).
+// Bind sets active flags with their default values from the configuration
+// file(s) and the environment.
 func (c *Resolver) Bind(app *kingpin.Application, args []string) error {
 	// Parse the command line arguments to get the selected command.
 	pc, err := app.ParseContext(args)
@@ -80,5 +148,42 @@ func (c *Resolver) Bind(app *kingpin.Application, args []string) error {
 		c.setDefault(pc.SelectedCommand)
 	}
 
+	// Flags given on the command line take precedence over the files and the
+	// environment.
+	c.sources = map[string]Source{}
+	for name, source := range c.origins {
+		if app.GetFlag(name) != nil {
+			c.sources[name] = source
+		}
+	}
+	c.cli = map[string]bool{}
+	for _, el := range pc.Elements {
+		if f, ok := el.Clause.(*kingpin.FlagClause); ok {
+			c.cli[f.Model().Name] = true
+			delete(c.sources, f.Model().Name)
+		}
+	}
+	names := make([]string, 0, len(c.sources))
+	for name := range c.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		log.Infof("Flag %s set by %s", name, c.sources[name])
+	}
+
 	return nil
 }
+
+// Sources returns the configuration file or environment variable supplying
+// the value of each flag set by one, keyed by flag name. It is only complete
+// once Bind was called.
+func (c *Resolver) Sources() map[string]Source {
+	return c.sources
+}
+
+// Modules returns the scrape modules of the configuration files, keyed by
+// name.
+func (c *Resolver) Modules() map[string]Module {
+	return c.modules
+}
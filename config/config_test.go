@@ -0,0 +1,80 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func TestLayeredFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "windows_exporter_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	confd := filepath.Join(dir, "conf.d")
+	if err := os.Mkdir(confd, 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(path, content string) string {
+		t.Helper()
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	base := write(filepath.Join(dir, "base.yml"), "log:\n  level: debug\ncollectors:\n  enabled: cpu\ntelemetry:\n  path: /base\n")
+	sql := write(filepath.Join(confd, "20-sql.yaml"), "collectors:\n  enabled: cpu,mssql\n")
+	iis := write(filepath.Join(confd, "10-iis.yml"), "collectors:\n  enabled: cpu,iis\ntelemetry:\n  addr: :9000\n")
+	write(filepath.Join(confd, "README.txt"), "not a configuration file")
+
+	files, err := ListFiles([]string{base}, confd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{base, iis, sql}; !reflect.DeepEqual(files, want) {
+		t.Fatalf("expected files %v, got %v", want, files)
+	}
+
+	app := kingpin.New("test", "")
+	level := app.Flag("log.level", "").String()
+	enabled := app.Flag("collectors.enabled", "").String()
+	addr := app.Flag("telemetry.addr", "").String()
+	path := app.Flag("telemetry.path", "").String()
+	args := []string{"--telemetry.path=/cli"}
+
+	resolver, err := NewResolver(files...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resolver.Bind(app, args); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	if *level != "debug" || *enabled != "cpu,mssql" || *addr != ":9000" || *path != "/cli" {
+		t.Errorf("unexpected values %q, %q, %q and %q", *level, *enabled, *addr, *path)
+	}
+	want := map[string]Source{
+		"log.level":          {Kind: SourceFile, Name: base},
+		"collectors.enabled": {Kind: SourceFile, Name: sql},
+		"telemetry.addr":     {Kind: SourceFile, Name: iis},
+	}
+	if got := resolver.Sources(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected sources %v, got %v", want, got)
+	}
+
+	write(filepath.Join(confd, "30-typo.yml"), "collector:\n  proces:\n    whitelist: foo\n")
+	files, err = ListFiles([]string{base}, confd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewResolver(files...); err == nil {
+		t.Error("expected an invalid fragment to fail the configuration")
+	}
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"strings"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Defaults holds the state flags were declared with, before any arguments
+// were parsed or configuration file was bound to them.
+type Defaults struct {
+	defaults map[string][]string
+	zeros    map[string]string
+}
+
+// SaveDefaults records the default values of all flags of app. It must be
+// called before app parses any arguments.
+func SaveDefaults(app *kingpin.Application) *Defaults {
+	d := &Defaults{
+		defaults: make(map[string][]string),
+		zeros:    make(map[string]string),
+	}
+	for _, f := range app.Model().Flags {
+		d.defaults[f.Name] = f.Default
+		d.zeros[f.Name] = f.Value.String()
+	}
+	return d
+}
+
+type resetter interface {
+	Reset()
+}
+
+// Restore sets the defaults of all flags of app back to the saved ones, and
+// clears their values. Parsing the arguments once more then starts from
+// scratch, so values bound from a previous configuration file do not survive.
+// Repeatable flags must be declared with Strings to be cleared.
+func (d *Defaults) Restore(app *kingpin.Application) {
+	for _, f := range app.Model().Flags {
+		app.GetFlag(f.Name).Default(d.defaults[f.Name]...)
+		if r, ok := f.Value.(resetter); ok {
+			r.Reset()
+			continue
+		}
+		// Values which do not accept their own zero value, such as enums,
+		// always have a default.
+		_ = f.Value.Set(d.zeros[f.Name])
+	}
+}
+
+// Strings declares f as a repeatable flag, whose values are cleared by
+// Defaults.Restore.
+func Strings(f *kingpin.FlagClause) *[]string {
+	target := new([]string)
+	f.SetValue((*stringList)(target))
+	return target
+}
+
+type stringList []string
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) IsCumulative() bool {
+	return true
+}
+
+func (s *stringList) Reset() {
+	*s = nil
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func TestRestoreDefaults(t *testing.T) {
+	app := kingpin.New("test", "")
+	level := app.Flag("log.level", "").Default("info").String()
+	filter := app.Flag("collector.process.whitelist", "").String()
+	items := Strings(app.Flag("collector.item", ""))
+	defaults := SaveDefaults(app)
+
+	file, err := ioutil.TempFile("", "windows_exporter_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	parse := func(content string, args ...string) {
+		t.Helper()
+		if err := ioutil.WriteFile(file.Name(), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		defaults.Restore(app)
+		resolver, err := NewResolver(file.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := resolver.Bind(app, args); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := app.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	parse("log:\n  level: debug\ncollector:\n  process:\n    whitelist: foo\n", "--collector.item=a")
+	if *level != "debug" || *filter != "foo" || !reflect.DeepEqual(*items, []string{"a"}) {
+		t.Errorf("unexpected values after the first parse: %q, %q, %v", *level, *filter, *items)
+	}
+
+	// Values removed from the file fall back to the declared defaults, and
+	// repeatable flags are not duplicated.
+	parse("{}\n", "--collector.item=a")
+	if *level != "info" || *filter != "" || !reflect.DeepEqual(*items, []string{"a"}) {
+		t.Errorf("unexpected values after the second parse: %q, %q, %v", *level, *filter, *items)
+	}
+}
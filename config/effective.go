@@ -0,0 +1,103 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v2"
+)
+
+const redacted = "<secret>"
+
+// Setting is the effective value of a flag, and where it came from.
+type Setting struct {
+	Flag   string
+	Value  string
+	Source Source
+}
+
+// Settings returns the values of the flags of app, sorted by name, once they
+// were bound and parsed. The flags built into kingpin, such as help, are
+// omitted.
+func (c *Resolver) Settings(app *kingpin.Application) []Setting {
+	var settings []Setting
+	for _, f := range app.Model().Flags {
+		if f.Hidden || f.Name == "help" || f.Name == "version" {
+			continue
+		}
+		source := Source{Kind: SourceDefault}
+		if c.cli[f.Name] {
+			source = Source{Kind: SourceFlag}
+		} else if s, ok := c.sources[f.Name]; ok {
+			source = s
+		}
+		settings = append(settings, Setting{Flag: f.Name, Value: f.Value.String(), Source: source})
+	}
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Flag < settings[j].Flag })
+	return settings
+}
+
+// Redact returns value with any secret it holds replaced. Flags named after
+// passwords, tokens, secrets or headers, which commonly carry API keys, are
+// redacted as a whole, unless they name a file holding the secret, and so are
+// passwords of URLs.
+func Redact(flag, value string) string {
+	if value == "" {
+		return value
+	}
+	name := strings.ToLower(flag)
+	if !strings.HasSuffix(name, "-file") {
+		for _, word := range []string{"password", "token", "secret", "header"} {
+			if strings.Contains(name, word) {
+				return redacted
+			}
+		}
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			// As url.URL.Redacted does, which requires Go 1.15.
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+			return u.String()
+		}
+	}
+	return value
+}
+
+// Hash returns a SHA-256 hash of the settings, which changes whenever a value
+// does.
+func Hash(settings []Setting) string {
+	h := sha256.New()
+	for _, s := range settings {
+		fmt.Fprintf(h, "%s=%q\n", s.Flag, s.Value)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// Render renders the settings as a YAML document in the layout of the
+// configuration file, with secrets redacted. Every value is followed by a
+// comment naming its source.
+func Render(settings []Setting) []byte {
+	var b bytes.Buffer
+	var section []string
+	for _, s := range settings {
+		path := strings.Split(s.Flag, ".")
+		// Close the sections the flag is not part of, and open its own.
+		common := 0
+		for common < len(section) && common < len(path)-1 && section[common] == path[common] {
+			common++
+		}
+		for i := common; i < len(path)-1; i++ {
+			fmt.Fprintf(&b, "%s%s:\n", strings.Repeat("  ", i), path[i])
+		}
+		section = path[:len(path)-1]
+
+		value, _ := yaml.Marshal(Redact(s.Flag, s.Value))
+		fmt.Fprintf(&b, "%s%s: %s # %s\n", strings.Repeat("  ", len(section)), path[len(path)-1], bytes.TrimSpace(value), s.Source)
+	}
+	return b.Bytes()
+}
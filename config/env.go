@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// EnvPrefix is the prefix of the environment variables setting flags.
+const EnvPrefix = "WINDOWS_EXPORTER_"
+
+var envReplacer = strings.NewReplacer(".", "_", "-", "_")
+
+// EnvName returns the name of the environment variable setting a flag. It is
+// derived from the flag name, which is also the flattened key of the setting
+// in configuration files: collector.process.whitelist, or
+//
+//	collector:
+//	  process:
+//	    whitelist: ...
+//
+// is set by WINDOWS_EXPORTER_COLLECTOR_PROCESS_WHITELIST.
+func EnvName(flag string) string {
+	return EnvPrefix + strings.ToUpper(envReplacer.Replace(flag))
+}
+
+// LoadEnv adds the values of the environment variables setting flags of app,
+// given as key=value pairs as returned by os.Environ. They override the values
+// of the configuration files. Flags of app whose names map to the same
+// variable are an error.
+func (c *Resolver) LoadEnv(app *kingpin.Application, environ []string) error {
+	flags := map[string]string{}
+	for _, f := range app.Model().Flags {
+		name := EnvName(f.Name)
+		if other, ok := flags[name]; ok {
+			return fmt.Errorf("environment: flags %s and %s share the variable %s", other, f.Name, name)
+		}
+		flags[name] = f.Name
+	}
+
+	values := map[string]string{}
+	for _, kv := range environ {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], EnvPrefix) {
+			continue
+		}
+		name, ok := flags[parts[0]]
+		if !ok {
+			log.Warnf("Ignoring environment variable %s, which matches no flag", parts[0])
+			continue
+		}
+		values[name] = parts[1]
+	}
+	if err := Validate(values); err != nil {
+		return fmt.Errorf("environment: %v", err)
+	}
+	for name, v := range values {
+		c.flags[name] = []string{v}
+		c.origins[name] = Source{Kind: SourceEnv, Name: EnvName(name)}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func TestEnvName(t *testing.T) {
+	for flag, want := range map[string]string{
+		"collector.process.whitelist":             "WINDOWS_EXPORTER_COLLECTOR_PROCESS_WHITELIST",
+		"collectors.mssql.classes-enabled":        "WINDOWS_EXPORTER_COLLECTORS_MSSQL_CLASSES_ENABLED",
+		"collector.logical_disk.volume-whitelist": "WINDOWS_EXPORTER_COLLECTOR_LOGICAL_DISK_VOLUME_WHITELIST",
+	} {
+		if got := EnvName(flag); got != want {
+			t.Errorf("expected %s for flag %s, got %s", want, flag, got)
+		}
+	}
+
+	// Every setting has its own variable.
+	seen := map[string]string{}
+	for _, key := range Keys() {
+		name := EnvName(key)
+		if other, ok := seen[name]; ok {
+			t.Errorf("flags %s and %s share the environment variable %s", other, key, name)
+		}
+		seen[name] = key
+	}
+
+	app := kingpin.New("test", "")
+	app.Flag("scrape.cache-ttl", "").String()
+	app.Flag("scrape.cache.ttl", "").String()
+	resolver := &Resolver{flags: map[string][]string{}, origins: map[string]Source{}}
+	if err := resolver.LoadEnv(app, nil); err == nil {
+		t.Error("expected an error for flags sharing an environment variable")
+	}
+}
+
+func TestEnvPrecedence(t *testing.T) {
+	file, err := ioutil.TempFile("", "windows_exporter_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	content := "log:\n  level: debug\ntelemetry:\n  addr: :9000\n  path: /file\nscrape:\n  cache-ttl: [os=5s, cs=1m]\n"
+	if err := ioutil.WriteFile(file.Name(), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := kingpin.New("test", "")
+	level := app.Flag("log.level", "").Default("info").String()
+	addr := app.Flag("telemetry.addr", "").Default(":9182").String()
+	path := app.Flag("telemetry.path", "").Default("/metrics").String()
+	format := app.Flag("log.format", "").Default("logger:stderr").String()
+	timeouts := Strings(app.Flag("scrape.collector-timeout", ""))
+	ttls := Strings(app.Flag("scrape.cache-ttl", ""))
+	args := []string{"--telemetry.path=/flag"}
+	environ := []string{
+		"PATH=C:\\Windows",
+		"WINDOWS_EXPORTER_TELEMETRY_ADDR=:9100",
+		"WINDOWS_EXPORTER_TELEMETRY_PATH=/env",
+		"WINDOWS_EXPORTER_SCRAPE_COLLECTOR_TIMEOUT=os=5s,cs=1m",
+		"WINDOWS_EXPORTER_UNKNOWN=1",
+	}
+
+	resolver, err := NewResolver(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resolver.LoadEnv(app, environ); err != nil {
+		t.Fatal(err)
+	}
+	if err := resolver.Bind(app, args); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	// Flag over env over file over default.
+	if *path != "/flag" || *addr != ":9100" || *level != "debug" || *format != "logger:stderr" {
+		t.Errorf("unexpected values %q, %q, %q and %q", *path, *addr, *level, *format)
+	}
+	if want := []string{"os=5s,cs=1m"}; !reflect.DeepEqual(*timeouts, want) {
+		t.Errorf("expected %v, got %v", want, *timeouts)
+	}
+	// Lists in files repeat the flag.
+	if want := []string{"os=5s", "cs=1m"}; !reflect.DeepEqual(*ttls, want) {
+		t.Errorf("expected %v, got %v", want, *ttls)
+	}
+	want := map[string]Source{
+		"log.level":                {Kind: SourceFile, Name: file.Name()},
+		"scrape.cache-ttl":         {Kind: SourceFile, Name: file.Name()},
+		"telemetry.addr":           {Kind: SourceEnv, Name: "WINDOWS_EXPORTER_TELEMETRY_ADDR"},
+		"scrape.collector-timeout": {Kind: SourceEnv, Name: "WINDOWS_EXPORTER_SCRAPE_COLLECTOR_TIMEOUT"},
+	}
+	if got := resolver.Sources(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected sources %v, got %v", want, got)
+	}
+
+	if err := resolver.LoadEnv(app, []string{"WINDOWS_EXPORTER_LOG_LEVEL=verbose"}); err == nil {
+		t.Error("expected an invalid value to be rejected")
+	}
+}
@@ -4,11 +4,13 @@ import "fmt"
 
 // flatten flattens the nested struct.
 //
-// All keys will be joined by dot
-// e.g. {"a": {"b":"c"}} => {"a.b":"c"}
-// or {"a": {"b":[1,2]}} => {"a.b.0":1, "a.b.1": 2}
-func flatten(data map[string]interface{}) map[string]string {
-	ret := make(map[string]string)
+// All keys will be joined by dot, and lists of scalars kept as lists
+// e.g. {"a": {"b":"c"}} => {"a.b":["c"]}
+// or {"a": {"b":[1,2]}} => {"a.b":[1,2]}
+// while other lists are indexed
+// e.g. {"a": [{"b":1}]} => {"a.0,b":[1]}
+func flatten(data map[string]interface{}) map[string][]string {
+	ret := make(map[string][]string)
 	for k, v := range data {
 		switch typed := v.(type) {
 		case map[interface{}]interface{}:
@@ -20,17 +22,21 @@ func flatten(data map[string]interface{}) map[string]string {
 				ret[fmt.Sprintf("%s.%s", k, fk)] = fv
 			}
 		case []interface{}:
+			if values, ok := scalars(typed); ok {
+				ret[k] = values
+				continue
+			}
 			for fk, fv := range flattenSlice(typed) {
 				ret[fmt.Sprintf("%s.%s", k, fk)] = fv
 			}
 		default:
-			ret[k] = fmt.Sprint(typed)
+			ret[k] = []string{fmt.Sprint(typed)}
 		}
 	}
 	return ret
 }
-func flattenSlice(data []interface{}) map[string]string {
-	ret := make(map[string]string)
+func flattenSlice(data []interface{}) map[string][]string {
+	ret := make(map[string][]string)
 	for idx, v := range data {
 		switch typed := v.(type) {
 		case map[interface{}]interface{}:
@@ -46,12 +52,25 @@ func flattenSlice(data []interface{}) map[string]string {
 				ret[fmt.Sprintf("%d,%s", idx, fk)] = fv
 			}
 		default:
-			ret[fmt.Sprint(idx)] = fmt.Sprint(typed)
+			ret[fmt.Sprint(idx)] = []string{fmt.Sprint(typed)}
 		}
 	}
 	return ret
 }
 
+// scalars returns the values of a list holding no maps or lists.
+func scalars(data []interface{}) ([]string, bool) {
+	values := make([]string, 0, len(data))
+	for _, v := range data {
+		switch v.(type) {
+		case map[interface{}]interface{}, map[string]interface{}, []interface{}:
+			return nil, false
+		}
+		values = append(values, fmt.Sprint(v))
+	}
+	return values, true
+}
+
 func convertMap(originalMap map[interface{}]interface{}) map[string]interface{} {
 	convertedMap := map[string]interface{}{}
 	for key, value := range originalMap {
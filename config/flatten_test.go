@@ -21,9 +21,35 @@ func TestConfigFlattening(t *testing.T) {
 		t.Error(err)
 	}
 
-	expectedResult := map[string]string{
-		"collectors.enabled": "cpu,net,service",
-		"log.level":          "debug",
+	expectedResult := map[string][]string{
+		"collectors.enabled": {"cpu,net,service"},
+		"log.level":          {"debug"},
+	}
+	flattenedValues := flatten(data)
+
+	if !reflect.DeepEqual(expectedResult, flattenedValues) {
+		t.Errorf("Flattened values do not match!\nExpected result: %s\nActual result: %s", expectedResult, flattenedValues)
+	}
+}
+
+// Lists of scalars are kept as lists, other lists are indexed
+func TestConfigFlatteningLists(t *testing.T) {
+	yamlConfig := []byte(`---
+
+    collectors:
+      enabled: [cpu, net, 1]
+
+    items:
+      - name: a`)
+	var data map[string]interface{}
+	err := yaml.Unmarshal(yamlConfig, &data)
+	if err != nil {
+		t.Error(err)
+	}
+
+	expectedResult := map[string][]string{
+		"collectors.enabled": {"cpu", "net", "1"},
+		"items.0,name":       {"a"},
 	}
 	flattenedValues := flatten(data)
 
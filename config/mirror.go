@@ -0,0 +1,88 @@
+package config
+
+import (
+	"strings"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Values holds the values of flags, keyed by flag name. Only repeatable flags
+// have more than one value.
+type Values map[string][]string
+
+// Get returns the last value of the flag, or the empty string.
+func (v Values) Get(name string) string {
+	if items := v[name]; len(items) > 0 {
+		return items[len(items)-1]
+	}
+	return ""
+}
+
+// Validate checks the values against the type and checks of their settings.
+// Values of other flags are ignored.
+func (v Values) Validate() error {
+	return validate(v)
+}
+
+// Mirror returns an application declaring the flags of app, whose values are
+// recorded into the returned Values rather than set on the flags of app.
+// Parsing a configuration into the mirror leaves app untouched, so it can be
+// checked and discarded while the flags of app are in use. Values are not
+// checked against the type of their flags; use Values.Validate.
+func Mirror(app *kingpin.Application) (*kingpin.Application, Values) {
+	mirror := kingpin.New(app.Name, app.Help)
+	values := Values{}
+	for _, f := range app.Model().Flags {
+		if mirror.GetFlag(f.Name) != nil {
+			continue
+		}
+		clause := mirror.Flag(f.Name, f.Help).Default(f.Default...)
+		if f.Envar != "" {
+			clause.Envar(f.Envar)
+		}
+		if f.Short != 0 {
+			clause.Short(f.Short)
+		}
+		if f.Hidden {
+			clause.Hidden()
+		}
+		v := &recordedValue{name: f.Name, values: values}
+		if c, ok := f.Value.(interface{ IsCumulative() bool }); ok {
+			v.cumulative = c.IsCumulative()
+		}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok {
+			v.bool = b.IsBoolFlag()
+		}
+		clause.SetValue(v)
+	}
+	return mirror, values
+}
+
+// recordedValue records the values of a flag of a mirror.
+type recordedValue struct {
+	name       string
+	values     Values
+	cumulative bool
+	bool       bool
+}
+
+func (v *recordedValue) Set(value string) error {
+	if v.cumulative {
+		v.values[v.name] = append(v.values[v.name], value)
+	} else {
+		v.values[v.name] = []string{value}
+	}
+	return nil
+}
+
+func (v *recordedValue) String() string {
+	return strings.Join(v.values[v.name], ",")
+}
+
+func (v *recordedValue) IsCumulative() bool {
+	return v.cumulative
+}
+
+func (v *recordedValue) IsBoolFlag() bool {
+	return v.bool
+}
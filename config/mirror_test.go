@@ -0,0 +1,48 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func TestMirror(t *testing.T) {
+	app := kingpin.New("test", "")
+	level := app.Flag("log.level", "").Default("info").String()
+	print := app.Flag("collectors.print", "").Bool()
+	items := Strings(app.Flag("collector.item", ""))
+	timeout := app.Flag("otlp.timeout", "").Default("10s").Duration()
+	if _, err := app.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	mirror, values := Mirror(app)
+	resolver := &Resolver{flags: map[string][]string{"log.level": {"debug"}}, origins: map[string]Source{}}
+	args := []string{"--collectors.print", "--collector.item=a", "--collector.item=b"}
+	if err := resolver.Bind(mirror, args); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mirror.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	want := Values{
+		"log.level":        {"debug"},
+		"collectors.print": {"true"},
+		"collector.item":   {"a", "b"},
+		"otlp.timeout":     {"10s"},
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("expected the values %v, got %v", want, values)
+	}
+	if *level != "info" || *print || len(*items) != 0 || timeout.String() != "10s" {
+		t.Errorf("expected the flags of the application to be left alone, got %q, %v, %v and %s", *level, *print, *items, timeout)
+	}
+
+	if _, err := mirror.Parse([]string{"--otlp.timeout=soon"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := values.Validate(); err == nil {
+		t.Error("expected an error for a value of the wrong type")
+	}
+}
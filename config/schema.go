@@ -0,0 +1,596 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// File is the schema of the configuration file. Every setting corresponds to
+// the flag named by the path of its keys, e.g. collector.process.whitelist.
+// Settings tagged with check are validated beyond their type, and settings
+// tagged with list may be written as lists, whose items are combined into a
+// comma-separated list, a regular expression alternation, or repeated flags.
+type File struct {
+	Collectors struct {
+		Enabled StringList `yaml:"enabled" list:"comma"`
+		Print   *bool      `yaml:"print"`
+		DFSR    struct {
+			SourcesEnabled StringList `yaml:"sources-enabled" list:"comma"`
+		} `yaml:"dfsr"`
+		Exchange struct {
+			Enabled StringList `yaml:"enabled" list:"comma"`
+			List    *bool      `yaml:"list"`
+		} `yaml:"exchange"`
+		MSSQL struct {
+			ClassesEnabled StringList `yaml:"classes-enabled" list:"comma"`
+			ClassPrint     *bool      `yaml:"class-print"`
+		} `yaml:"mssql"`
+	} `yaml:"collectors"`
+
+	Collector struct {
+		Filter StringList `yaml:"filter" check:"series-filter" list:"repeat"`
+		IIS    struct {
+			SiteWhitelist StringList `yaml:"site-whitelist" check:"regexp" list:"alternation"`
+			SiteBlacklist StringList `yaml:"site-blacklist" check:"regexp" list:"alternation"`
+			AppWhitelist  StringList `yaml:"app-whitelist" check:"regexp" list:"alternation"`
+			AppBlacklist  StringList `yaml:"app-blacklist" check:"regexp" list:"alternation"`
+		} `yaml:"iis"`
+		LogicalDisk struct {
+			VolumeWhitelist StringList `yaml:"volume-whitelist" check:"regexp" list:"alternation"`
+			VolumeBlacklist StringList `yaml:"volume-blacklist" check:"regexp" list:"alternation"`
+		} `yaml:"logical_disk"`
+		MSMQ struct {
+			Where *string `yaml:"msmq-where" check:"wql"`
+		} `yaml:"msmq"`
+		Net struct {
+			NICWhitelist StringList `yaml:"nic-whitelist" check:"regexp" list:"alternation"`
+			NICBlacklist StringList `yaml:"nic-blacklist" check:"regexp" list:"alternation"`
+		} `yaml:"net"`
+		Process struct {
+			Whitelist StringList `yaml:"whitelist" check:"regexp" list:"alternation"`
+			Blacklist StringList `yaml:"blacklist" check:"regexp" list:"alternation"`
+		} `yaml:"process"`
+		Service struct {
+			Where *string `yaml:"services-where" check:"wql"`
+		} `yaml:"service"`
+		SMTP struct {
+			ServerWhitelist StringList `yaml:"server-whitelist" check:"regexp" list:"alternation"`
+			ServerBlacklist StringList `yaml:"server-blacklist" check:"regexp" list:"alternation"`
+		} `yaml:"smtp"`
+		Textfile struct {
+			Directory  *string `yaml:"directory"`
+			Timestamps *string `yaml:"timestamps" check:"oneof=reject strip honour"`
+		} `yaml:"textfile"`
+	} `yaml:"collector"`
+
+	Config struct {
+		WatchInterval *time.Duration `yaml:"watch-interval"`
+	} `yaml:"config"`
+
+	Modules map[string]ModuleFile `yaml:"modules"`
+
+	Log struct {
+		Level  *string `yaml:"level" check:"oneof=debug info warn error fatal"`
+		Format *string `yaml:"format"`
+	} `yaml:"log"`
+
+	Otlp struct {
+		Endpoint *string        `yaml:"endpoint"`
+		Protocol *string        `yaml:"protocol" check:"oneof=http/protobuf grpc"`
+		Interval *time.Duration `yaml:"interval"`
+		Timeout  *time.Duration `yaml:"timeout"`
+		Header   StringList     `yaml:"header" check:"header" list:"repeat"`
+		TLS      struct {
+			CAFile             *string `yaml:"ca-file"`
+			CertFile           *string `yaml:"cert-file"`
+			KeyFile            *string `yaml:"key-file"`
+			ServerName         *string `yaml:"server-name"`
+			InsecureSkipVerify *bool   `yaml:"insecure-skip-verify"`
+		} `yaml:"tls"`
+	} `yaml:"otlp"`
+
+	Push struct {
+		URL        *string        `yaml:"url"`
+		Interval   *time.Duration `yaml:"interval"`
+		Timeout    *time.Duration `yaml:"timeout"`
+		QueueDir   *string        `yaml:"queue-dir"`
+		QueueMax   *int64         `yaml:"queue-max-bytes"`
+		MinBackoff *time.Duration `yaml:"min-backoff"`
+		MaxBackoff *time.Duration `yaml:"max-backoff"`
+		BasicAuth  struct {
+			Username     *string `yaml:"username"`
+			PasswordFile *string `yaml:"password-file"`
+		} `yaml:"basic-auth"`
+		BearerTokenFile *string `yaml:"bearer-token-file"`
+	} `yaml:"push"`
+
+	Relabel struct {
+		Config struct {
+			File *string `yaml:"file"`
+		} `yaml:"config"`
+	} `yaml:"relabel"`
+
+	Scrape struct {
+		TimeoutMargin      *float64       `yaml:"timeout-margin"`
+		RecordDir          *string        `yaml:"record-dir"`
+		RecordMaxFiles     *int           `yaml:"record-max-files"`
+		ReplayDir          *string        `yaml:"replay-dir"`
+		Mode               *string        `yaml:"mode" check:"oneof=on-demand background"`
+		Interval           *time.Duration `yaml:"interval"`
+		CollectorTimeout   StringList     `yaml:"collector-timeout" check:"collector-durations" list:"repeat"`
+		CacheTTL           StringList     `yaml:"cache-ttl" check:"collector-durations" list:"repeat"`
+		SeriesLimit        StringList     `yaml:"series-limit" check:"collector-limits" list:"repeat"`
+		DefaultSeriesLimit *int           `yaml:"default-series-limit"`
+		TotalSeriesLimit   *int           `yaml:"total-series-limit"`
+	} `yaml:"scrape"`
+
+	Telemetry struct {
+		Addr               *string    `yaml:"addr"`
+		Path               *string    `yaml:"path"`
+		MaxRequests        *int       `yaml:"max-requests"`
+		ConstLabel         StringList `yaml:"const-label" check:"const-label" list:"repeat"`
+		ConstLabelConflict *string    `yaml:"const-label-conflict" check:"oneof=rename keep override"`
+	} `yaml:"telemetry"`
+
+	Web struct {
+		Config struct {
+			File *string `yaml:"file"`
+		} `yaml:"config"`
+	} `yaml:"web"`
+}
+
+// ModuleFile is the schema of a scrape module of the configuration file. Only
+// the collectors and collector sections of File may be set, with the scrape
+// timeout of the module.
+type ModuleFile struct {
+	Timeout *time.Duration `yaml:"timeout"`
+	File    `yaml:",inline"`
+}
+
+// Module is a named set of collector settings, selected per scrape with the
+// module parameter.
+type Module struct {
+	// Timeout is the scrape timeout of the module when Prometheus gives none,
+	// 0 if unset.
+	Timeout time.Duration
+	// Flags holds the values of the collector flags the module sets, keyed by
+	// flag name.
+	Flags map[string][]string
+}
+
+// moduleExcluded holds the collector settings which cannot be set by modules,
+// as they are not about building collectors.
+var moduleExcluded = map[string]bool{
+	"collectors.print":             true,
+	"collectors.exchange.list":     true,
+	"collectors.mssql.class-print": true,
+	"collector.filter":             true,
+}
+
+// StringList is a setting written either as a single value, or as a list.
+type StringList []string
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (l *StringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		*l = list
+		return nil
+	}
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	*l = StringList{value}
+	return nil
+}
+
+// parseFile parses the content of a configuration file into flag values and
+// scrape modules. Unknown keys and values of the wrong type are reported with
+// their line numbers, other invalid values with their keys. Only repeatable
+// flags get more than one value.
+func parseFile(b []byte) (map[string][]string, map[string]Module, error) {
+	file := &File{}
+	if err := yaml.UnmarshalStrict(b, file); err != nil {
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			// Drop the Go types of the sections from the messages.
+			for i, e := range typeErr.Errors {
+				if j := strings.Index(e, " in type "); j >= 0 {
+					typeErr.Errors[i] = e[:j]
+				}
+			}
+		}
+		return nil, nil, err
+	}
+	var rawValues map[string]interface{}
+	if err := yaml.Unmarshal(b, &rawValues); err != nil {
+		return nil, nil, err
+	}
+	rawModules, _ := rawValues["modules"].(map[interface{}]interface{})
+	delete(rawValues, "modules")
+
+	// Flatten nested YAML values
+	values, err := parseValues(flatten(rawValues))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var modules map[string]Module
+	for name, raw := range rawModules {
+		if modules == nil {
+			modules = map[string]Module{}
+		}
+		module, err := parseModule(file.Modules[fmt.Sprint(name)], raw)
+		if err != nil {
+			return nil, nil, fmt.Errorf("module %v: %v", name, err)
+		}
+		modules[fmt.Sprint(name)] = module
+	}
+	return values, modules, nil
+}
+
+// parseModule validates the settings of a scrape module, and converts them to
+// flag values.
+func parseModule(file ModuleFile, raw interface{}) (Module, error) {
+	module := Module{}
+	if file.Timeout != nil {
+		module.Timeout = *file.Timeout
+	}
+	rawValues, _ := raw.(map[interface{}]interface{})
+	settings := convertMap(rawValues)
+	delete(settings, "timeout")
+	flags := flatten(settings)
+	for key := range flags {
+		if moduleExcluded[key] || !strings.HasPrefix(key, "collectors.") && !strings.HasPrefix(key, "collector.") {
+			return Module{}, fmt.Errorf("%s cannot be set by a module", key)
+		}
+	}
+	flags, err := parseValues(flags)
+	if err != nil {
+		return Module{}, err
+	}
+	module.Flags = flags
+	return module, nil
+}
+
+// parseValues validates flattened settings, and combines their lists as each
+// flag expects them.
+func parseValues(values map[string][]string) (map[string][]string, error) {
+	if err := validate(values); err != nil {
+		return nil, err
+	}
+	lists := map[string]string{}
+	walkFile(func(key string, field reflect.StructField) {
+		lists[key] = field.Tag.Get("list")
+	})
+	for key, items := range values {
+		switch lists[key] {
+		case "comma":
+			values[key] = []string{strings.Join(items, ",")}
+		case "alternation":
+			if len(items) > 1 {
+				for i, item := range items {
+					items[i] = "(?:" + item + ")"
+				}
+				values[key] = []string{strings.Join(items, "|")}
+			}
+		}
+	}
+	return values, nil
+}
+
+// Validate checks the values of settings, keyed by flag name, against their
+// type and checks. Values of other flags are ignored.
+func Validate(values map[string]string) error {
+	lists := make(map[string][]string, len(values))
+	for key, value := range values {
+		lists[key] = []string{value}
+	}
+	return validate(lists)
+}
+
+func validate(values map[string][]string) error {
+	checks := map[string]string{}
+	types := map[string]reflect.Type{}
+	walkFile(func(key string, field reflect.StructField) {
+		checks[key] = field.Tag.Get("check")
+		types[key] = field.Type
+	})
+	var errs []string
+	for key, items := range values {
+		for _, value := range items {
+			err := checkType(value, types[key])
+			if err == nil {
+				err = checkValue(value, checks[key])
+			}
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", key, err))
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Strings(errs)
+	return fmt.Errorf("invalid configuration:\n  %s", strings.Join(errs, "\n  "))
+}
+
+// Keys returns the names of all settings of the configuration file, which
+// are also the names of their flags.
+func Keys() []string {
+	var keys []string
+	walkFile(func(key string, _ reflect.StructField) {
+		keys = append(keys, key)
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+// walkFile calls fn with the key and field of every setting of File.
+func walkFile(fn func(key string, field reflect.StructField)) {
+	var walk func(prefix string, t reflect.Type)
+	walk = func(prefix string, t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			key := prefix + field.Tag.Get("yaml")
+			switch field.Type.Kind() {
+			case reflect.Struct:
+				walk(key+".", field.Type)
+				continue
+			case reflect.Map:
+				// Modules are not settings of their own.
+				continue
+			}
+			fn(key, field)
+		}
+	}
+	walk("", reflect.TypeOf(File{}))
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// checkType checks that value parses as a flag of type t. Types of unknown
+// settings are nil.
+func checkType(value string, t reflect.Type) error {
+	if t == nil {
+		return nil
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	var err error
+	kind := ""
+	switch {
+	case t == durationType:
+		_, err = time.ParseDuration(value)
+		kind = "duration"
+	case t.Kind() == reflect.Bool:
+		_, err = strconv.ParseBool(value)
+		kind = "boolean"
+	case t.Kind() == reflect.Int || t.Kind() == reflect.Int64:
+		_, err = strconv.ParseInt(value, 0, 64)
+		kind = "integer"
+	case t.Kind() == reflect.Float64:
+		_, err = strconv.ParseFloat(value, 64)
+		kind = "number"
+	}
+	if err != nil {
+		return fmt.Errorf("%q is not a valid %s", value, kind)
+	}
+	return nil
+}
+
+func checkValue(value, check string) error {
+	switch {
+	case check == "regexp":
+		if _, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", value)); err != nil {
+			return fmt.Errorf("invalid regular expression: %v", err)
+		}
+	case check == "wql":
+		return CheckWQLFragment(value)
+	case check == "collector-durations":
+		_, err := ParseCollectorDurations([]string{value})
+		return err
+	case check == "collector-limits":
+		_, err := ParseCollectorLimits([]string{value})
+		return err
+	case check == "series-filter":
+		_, err := ParseSeriesFilter(value)
+		return err
+	case check == "const-label":
+		_, err := ParseConstLabels([]string{value})
+		return err
+	case check == "header":
+		_, err := ParseHeaders([]string{value})
+		return err
+	case strings.HasPrefix(check, "oneof="):
+		options := strings.Fields(strings.TrimPrefix(check, "oneof="))
+		for _, o := range options {
+			if value == o {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not one of %s", value, strings.Join(options, ", "))
+	}
+	return nil
+}
+
+// CheckWQLFragment checks that s can be used as a WHERE clause of a WQL
+// query: quotes and parentheses must be balanced, and it must not end the
+// statement or repeat the WHERE keyword.
+func CheckWQLFragment(s string) error {
+	depth := 0
+	var quote rune
+	for i, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+			if depth < 0 {
+				return fmt.Errorf("unbalanced ')' at offset %d", i)
+			}
+		case r == ';':
+			return fmt.Errorf("unexpected ';' at offset %d", i)
+		}
+	}
+	if quote != 0 {
+		return fmt.Errorf("unterminated %c quote", quote)
+	}
+	if depth > 0 {
+		return fmt.Errorf("%d unclosed '('", depth)
+	}
+	if fields := strings.Fields(s); len(fields) > 0 && strings.EqualFold(fields[0], "where") {
+		return fmt.Errorf("must not start with WHERE, which is added by the collector")
+	}
+	return nil
+}
+
+// ParseCollectorDurations parses values of the form "collector=duration" into
+// a map. Each value may hold several comma-separated pairs.
+func ParseCollectorDurations(values []string) (map[string]time.Duration, error) {
+	durations := make(map[string]time.Duration)
+	for _, value := range values {
+		for _, pair := range strings.Split(value, ",") {
+			if pair == "" {
+				continue
+			}
+			parts := strings.SplitN(pair, "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				return nil, fmt.Errorf("expected collector=duration, got %q", pair)
+			}
+			d, err := time.ParseDuration(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid duration for collector %s: %v", parts[0], err)
+			}
+			durations[parts[0]] = d
+		}
+	}
+	return durations, nil
+}
+
+// ParseCollectorLimits parses values of the form "collector=count" into a
+// map. Each value may hold several comma-separated pairs. Counts must not be
+// negative.
+func ParseCollectorLimits(values []string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, value := range values {
+		for _, pair := range strings.Split(value, ",") {
+			if pair == "" {
+				continue
+			}
+			parts := strings.SplitN(pair, "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				return nil, fmt.Errorf("expected collector=count, got %q", pair)
+			}
+			n, err := strconv.Atoi(parts[1])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid count for collector %s: %q", parts[0], parts[1])
+			}
+			limits[parts[0]] = n
+		}
+	}
+	return limits, nil
+}
+
+// SeriesFilter is a rule selecting the series of a collector by the value of
+// one of their labels, written as collector:label=~pattern to keep the series
+// matching pattern, or collector:label!~pattern to drop them.
+type SeriesFilter struct {
+	Collector string
+	Label     string
+	Pattern   string
+	Exclude   bool
+}
+
+func (f SeriesFilter) String() string {
+	op := "=~"
+	if f.Exclude {
+		op = "!~"
+	}
+	return f.Label + op + f.Pattern
+}
+
+// ParseSeriesFilter parses a rule of the form collector:label=~pattern or
+// collector:label!~pattern.
+func ParseSeriesFilter(rule string) (SeriesFilter, error) {
+	parts := strings.SplitN(rule, ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return SeriesFilter{}, fmt.Errorf("expected collector:label=~pattern or collector:label!~pattern, got %q", rule)
+	}
+	f := SeriesFilter{Collector: parts[0]}
+	i := strings.Index(parts[1], "~")
+	if i < 1 || (parts[1][i-1] != '=' && parts[1][i-1] != '!') {
+		return SeriesFilter{}, fmt.Errorf("expected collector:label=~pattern or collector:label!~pattern, got %q", rule)
+	}
+	f.Label = parts[1][:i-1]
+	f.Exclude = parts[1][i-1] == '!'
+	f.Pattern = parts[1][i+1:]
+	if !model.LabelName(f.Label).IsValid() {
+		return SeriesFilter{}, fmt.Errorf("invalid label name %q in %q", f.Label, rule)
+	}
+	if _, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", f.Pattern)); err != nil {
+		return SeriesFilter{}, fmt.Errorf("invalid regular expression in %q: %v", rule, err)
+	}
+	return f, nil
+}
+
+// ParseConstLabels parses values of the form "name=value" into a map of
+// labels. Names must be valid label names, and must not be repeated. Values
+// must not be empty.
+func ParseConstLabels(values []string) (map[string]string, error) {
+	labels := make(map[string]string, len(values))
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected name=value, got %q", value)
+		}
+		name := parts[0]
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, model.ReservedLabelPrefix) {
+			return nil, fmt.Errorf("invalid label name %q", name)
+		}
+		if parts[1] == "" {
+			return nil, fmt.Errorf("empty value for label %s", name)
+		}
+		if _, ok := labels[name]; ok {
+			return nil, fmt.Errorf("label %s set more than once", name)
+		}
+		labels[name] = parts[1]
+	}
+	return labels, nil
+}
+
+// ParseHeaders parses HTTP headers given as Name=Value. A header may be
+// given more than once, to send several values.
+func ParseHeaders(values []string) (http.Header, error) {
+	headers := make(http.Header, len(values))
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected Name=Value, got %q", value)
+		}
+		name := parts[0]
+		if name == "" || strings.IndexFunc(name, func(r rune) bool {
+			return r <= ' ' || r >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r)
+		}) >= 0 {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+		headers.Add(name, parts[1])
+	}
+	return headers, nil
+}
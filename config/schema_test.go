@@ -0,0 +1,249 @@
+package config
+
+import (
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFile(t *testing.T) {
+	b, err := ioutil.ReadFile("../docs/example_config.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := parseFile(b); err != nil {
+		t.Errorf("expected the example configuration to be valid, got %v", err)
+	}
+
+	values, _, err := parseFile([]byte(`
+collector:
+  process:
+    whitelist: "firefox|chrome"
+scrape:
+  timeout-margin: 1.5
+  cache-ttl: os=5s,cs=1m
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"collector.process.whitelist": {"firefox|chrome"},
+		"scrape.timeout-margin":       {"1.5"},
+		"scrape.cache-ttl":            {"os=5s,cs=1m"},
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("expected values %v, got %v", want, values)
+	}
+
+	// Lists are combined as each flag expects them.
+	values, _, err = parseFile([]byte(`
+collectors:
+  enabled: [cpu, os, mssql]
+  mssql:
+    classes-enabled:
+      - accessmethods
+      - bufman
+collector:
+  process:
+    whitelist:
+      - firefox
+      - chrome|msedge
+scrape:
+  cache-ttl:
+    - os=5s
+    - cs=1m
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string][]string{
+		"collectors.enabled":               {"cpu,os,mssql"},
+		"collectors.mssql.classes-enabled": {"accessmethods,bufman"},
+		"collector.process.whitelist":      {"(?:firefox)|(?:chrome|msedge)"},
+		"scrape.cache-ttl":                 {"os=5s", "cs=1m"},
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("expected values %v, got %v", want, values)
+	}
+
+	for _, tc := range []struct {
+		config string
+		err    string
+	}{
+		{"collector:\n  proces:\n    whitelist: foo\n", "line 2: field proces not found"},
+		{"log:\n  level: [debug, info]\n", "line 2: cannot unmarshal !!seq into string"},
+		{"collector:\n  net:\n    nic-whitelist: [eth0, \"(eth1\"]\n", "collector.net.nic-whitelist: invalid regular expression"},
+		{"telemetry:\n  addr: :9182\n  max-requests: many\n", "line 3: cannot unmarshal !!str `many` into int"},
+		{"config:\n  watch-interval: soon\n", "line 2"},
+		{"collector:\n  iis:\n    site-whitelist: \"(default\"\n", "collector.iis.site-whitelist: invalid regular expression"},
+		{"collector:\n  service:\n    services-where: \"Name='foo\"\n", "collector.service.services-where: unterminated ' quote"},
+		{"collector:\n  msmq:\n    msmq-where: \"WHERE Name='foo'\"\n", "collector.msmq.msmq-where: must not start with WHERE"},
+		{"log:\n  level: verbose\n", `log.level: "verbose" is not one of`},
+		{"scrape:\n  collector-timeout: os\n", "scrape.collector-timeout: expected collector=duration"},
+		{"collector:\n  filter: [\"hyperv:vm=~web.*\", \"hyperv:vm~db\"]\n", "collector.filter: expected collector:label=~pattern"},
+		{"scrape:\n  series-limit: [process=5000, textfile=lots]\n", `scrape.series-limit: invalid count for collector textfile: "lots"`},
+		{"telemetry:\n  const-label: [dc=ams1, 1role=web]\n", `telemetry.const-label: invalid label name "1role"`},
+		{"telemetry:\n  const-label-conflict: replace\n", `telemetry.const-label-conflict: "replace" is not one of rename, keep, override`},
+	} {
+		_, _, err := parseFile([]byte(tc.config))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("expected an error containing %q for %q, got %v", tc.err, tc.config, err)
+		}
+	}
+}
+
+func TestParseModules(t *testing.T) {
+	_, modules, err := parseFile([]byte(`
+collectors:
+  enabled: cpu,os
+modules:
+  sql:
+    timeout: 30s
+    collectors:
+      enabled: [cpu, mssql, process]
+      mssql:
+        classes-enabled: [bufman, sqlstats]
+    collector:
+      process:
+        whitelist: [sqlservr, sqlagent]
+  iis:
+    collectors:
+      enabled: iis
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Module{
+		"sql": {
+			Timeout: 30 * time.Second,
+			Flags: map[string][]string{
+				"collectors.enabled":               {"cpu,mssql,process"},
+				"collectors.mssql.classes-enabled": {"bufman,sqlstats"},
+				"collector.process.whitelist":      {"(?:sqlservr)|(?:sqlagent)"},
+			},
+		},
+		"iis": {
+			Flags: map[string][]string{"collectors.enabled": {"iis"}},
+		},
+	}
+	if !reflect.DeepEqual(modules, want) {
+		t.Errorf("expected modules %v, got %v", want, modules)
+	}
+
+	for _, tc := range []struct {
+		config string
+		err    string
+	}{
+		{"modules:\n  sql:\n    timeout: soon\n", "line 3"},
+		{"modules:\n  sql:\n    collector:\n      proces:\n        whitelist: foo\n", "line 4: field proces not found"},
+		{"modules:\n  sql:\n    log:\n      level: debug\n", "module sql: log.level cannot be set by a module"},
+		{"modules:\n  sql:\n    collectors:\n      print: true\n", "module sql: collectors.print cannot be set by a module"},
+		{"modules:\n  sql:\n    collector:\n      process:\n        whitelist: \"(sql\"\n", "module sql: invalid configuration:\n  collector.process.whitelist: invalid regular expression"},
+	} {
+		_, _, err := parseFile([]byte(tc.config))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("expected an error containing %q for %q, got %v", tc.err, tc.config, err)
+		}
+	}
+}
+
+func TestCheckWQLFragment(t *testing.T) {
+	for _, valid := range []string{
+		"",
+		"Name='windows_exporter'",
+		"Name LIKE 'foo(%' OR (StartMode='Auto' AND State=\"Stopped\")",
+	} {
+		if err := CheckWQLFragment(valid); err != nil {
+			t.Errorf("expected %q to be valid, got %v", valid, err)
+		}
+	}
+	for _, invalid := range []string{
+		"(Name='foo'",
+		"Name='foo')",
+		"Name='foo'; DELETE",
+		"where Name='foo'",
+	} {
+		if err := CheckWQLFragment(invalid); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestParseCollectorDurations(t *testing.T) {
+	got, err := ParseCollectorDurations([]string{"os=5s,cs=1m", "mssql=30s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]time.Duration{"os": 5 * time.Second, "cs": time.Minute, "mssql": 30 * time.Second}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for name, d := range want {
+		if got[name] != d {
+			t.Errorf("expected %s for collector %s, got %s", d, name, got[name])
+		}
+	}
+
+	for _, value := range []string{"os", "=5s", "os=five"} {
+		if _, err := ParseCollectorDurations([]string{value}); err == nil {
+			t.Errorf("expected an error for %q", value)
+		}
+	}
+}
+
+func TestParseCollectorLimits(t *testing.T) {
+	got, err := ParseCollectorLimits([]string{"process=5000,textfile=100", "cpu=0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"process": 5000, "textfile": 100, "cpu": 0}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	for _, value := range []string{"process", "=5000", "process=many", "process=-1"} {
+		if _, err := ParseCollectorLimits([]string{value}); err == nil {
+			t.Errorf("expected an error for %q", value)
+		}
+	}
+}
+
+func TestParseConstLabels(t *testing.T) {
+	got, err := ParseConstLabels([]string{"datacenter=ams1", "role=web,sql"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"datacenter": "ams1", "role": "web,sql"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	for _, value := range []string{"datacenter", "=ams1", "data-center=ams1", "__name__=foo", "role="} {
+		if _, err := ParseConstLabels([]string{value}); err == nil {
+			t.Errorf("expected an error for %q", value)
+		}
+	}
+	if _, err := ParseConstLabels([]string{"role=web", "role=sql"}); err == nil {
+		t.Error("expected an error for a repeated label")
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	got, err := ParseHeaders([]string{"Authorization=Bearer a=b", "x-scope=a", "X-Scope=b", "X-Empty="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := http.Header{"Authorization": {"Bearer a=b"}, "X-Scope": {"a", "b"}, "X-Empty": {""}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	for _, value := range []string{"Authorization", "=a", "X Scope=a", "X:Scope=a"} {
+		if _, err := ParseHeaders([]string{value}); err == nil {
+			t.Errorf("expected an error for %q", value)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus-community/windows_exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// scrapeTrace describes a single scrape, as served on /debug/scrape.
+type scrapeTrace struct {
+	mtx sync.Mutex
+
+	Start           time.Time `json:"start"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	// Error is set if the scrape could not start.
+	Error   string          `json:"error,omitempty"`
+	Sources collector.Trace `json:"sources"`
+	// Collectors holds the runs of the collectors by name. Runs which timed
+	// out are included as far as they got.
+	Collectors map[string]*collectorTrace `json:"collectors"`
+
+	tracer *collector.Tracer
+}
+
+// collectorTrace describes the run of a collector in a scrape.
+type collectorTrace struct {
+	// Outcome is one of the outcomes of the history of collectors. Debug
+	// scrapes run every collector, rather than serving cached metrics.
+	Outcome         string  `json:"outcome"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	// Series is the number of series the collector sent, before filters,
+	// limits and relabeling.
+	Series int    `json:"series"`
+	Error  string `json:"error,omitempty"`
+}
+
+func newScrapeTrace() *scrapeTrace {
+	return &scrapeTrace{
+		Start:      time.Now(),
+		Collectors: make(map[string]*collectorTrace),
+	}
+}
+
+// wrap returns the sources of the scrape, traced.
+func (t *scrapeTrace) wrap(perflibSource collector.PerflibSource, wmiQuerier collector.WMIQuerier) (*collector.Tracer, collector.PerflibSource, collector.WMIQuerier) {
+	if t == nil {
+		return nil, perflibSource, wmiQuerier
+	}
+	tracer := collector.NewTracer(perflibSource, wmiQuerier)
+	t.mtx.Lock()
+	t.tracer = tracer
+	t.mtx.Unlock()
+	return tracer, tracer, tracer
+}
+
+// fail records the error which prevented the scrape from starting.
+func (t *scrapeTrace) fail(err error) {
+	if t == nil {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.Error = err.Error()
+}
+
+func (t *scrapeTrace) get(name string) *collectorTrace {
+	c, ok := t.Collectors[name]
+	if !ok {
+		c = &collectorTrace{}
+		t.Collectors[name] = c
+	}
+	return c
+}
+
+// ran records a run of the named collector which returned.
+func (t *scrapeTrace) ran(name string, duration time.Duration, err error, series int) {
+	if t == nil {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	c := t.get(name)
+	c.DurationSeconds = duration.Seconds()
+	c.Series = series
+	if err != nil {
+		c.Error = err.Error()
+	}
+}
+
+// outcome records the outcome of the named collector in the scrape.
+func (t *scrapeTrace) outcome(name string, outcome string) {
+	if t == nil {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.get(name).Outcome = outcome
+}
+
+// MarshalJSON encodes the trace as it stands.
+func (t *scrapeTrace) MarshalJSON() ([]byte, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.tracer != nil {
+		t.Sources = t.tracer.Trace()
+	}
+	type plain scrapeTrace
+	return json.Marshal((*plain)(t))
+}
+
+// debugScrapeHandler runs a scrape and serves its trace. Debug scrapes are
+// kept out of the shared state of scrapes: they neither read nor fill the
+// cache, and are neither recorded in the status of collectors nor in scrape
+// recordings.
+type debugScrapeHandler struct {
+	// timeout is the scrape timeout, and the longest one a request can ask
+	// for, unless the module of the scrape sets another.
+	timeout       time.Duration
+	timeoutMargin time.Duration
+	// moduleTimeout returns the scrape timeout of a module, 0 if unset.
+	moduleTimeout func(module string) time.Duration
+	newCollector  func(timeout time.Duration, requestedCollectors []string, module string) (*windowsCollector, error)
+}
+
+func (h *debugScrapeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	module := query.Get("module")
+	timeout := h.timeout
+	if h.moduleTimeout != nil {
+		if t := h.moduleTimeout(module); t > 0 {
+			timeout = t - h.timeoutMargin
+		}
+	}
+	if v := query.Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, fmt.Sprintf("invalid timeout %q", v), http.StatusBadRequest)
+			return
+		}
+		if d < timeout {
+			timeout = d
+		}
+	}
+	coll, err := h.newCollector(timeout, query["collect[]"], module)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Couldn't create filtered metrics handler: %s", err), http.StatusBadRequest)
+		return
+	}
+	coll.cache = newCollectorCache(nil)
+	coll.status = nil
+	coll.recordDir = ""
+
+	trace := newScrapeTrace()
+	coll.trace = trace
+	coll.collect(func(string, prometheus.Metric) {})
+	trace.mtx.Lock()
+	trace.DurationSeconds = time.Since(trace.Start).Seconds()
+	trace.mtx.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(trace); err != nil {
+		log.Debugf("Failed to write the scrape trace: %v", err)
+	}
+}
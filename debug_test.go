@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus-community/windows_exporter/collector"
+)
+
+func TestDebugScrapeHandler(t *testing.T) {
+	defaultSource := collector.DefaultPerflibSource
+	collector.DefaultPerflibSource = collector.NewFixturePerflibSource()
+	defer func() { collector.DefaultPerflibSource = defaultSource }()
+
+	var gotTimeout time.Duration
+	var gotRequested []string
+	status := newCollectorStatus()
+	cache := newCollectorCache(map[string]time.Duration{"volumes": time.Hour})
+	recordDir, err := ioutil.TempDir("", "windows_exporter_debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(recordDir)
+	h := &debugScrapeHandler{
+		timeout:       9 * time.Second,
+		timeoutMargin: time.Second,
+		moduleTimeout: func(module string) time.Duration {
+			if module == "slow" {
+				return time.Minute
+			}
+			return 0
+		},
+		newCollector: func(timeout time.Duration, requestedCollectors []string, module string) (*windowsCollector, error) {
+			if module != "" && module != "slow" {
+				return nil, fmt.Errorf("unknown module: %s", module)
+			}
+			gotTimeout, gotRequested = timeout, requestedCollectors
+			return &windowsCollector{
+				maxScrapeDuration: timeout,
+				collectors: map[string]collector.Collector{
+					"volumes": volumeCollector{"C:", "D:"},
+					"failing": &countingCollector{err: errors.New("access denied")},
+				},
+				runs:      newCollectorRuns(),
+				cache:     cache,
+				status:    status,
+				recordDir: recordDir,
+			}, nil
+		},
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/scrape?collect[]=volumes&collect[]=failing", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the trace, got %d: %s", rec.Code, rec.Body)
+	}
+	if gotTimeout != 9*time.Second || !reflect.DeepEqual(gotRequested, []string{"volumes", "failing"}) {
+		t.Errorf("unexpected timeout %s and collectors %v", gotTimeout, gotRequested)
+	}
+	var trace struct {
+		DurationSeconds float64                   `json:"duration_seconds"`
+		Sources         collector.Trace           `json:"sources"`
+		Collectors      map[string]collectorTrace `json:"collectors"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&trace); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]collectorTrace{
+		"volumes": {Outcome: historySuccess, Series: 3},
+		"failing": {Outcome: historyFailure, Error: "access denied"},
+	}
+	for name, c := range trace.Collectors {
+		c.DurationSeconds = 0
+		trace.Collectors[name] = c
+	}
+	if !reflect.DeepEqual(trace.Collectors, want) {
+		t.Errorf("expected collectors %+v, got %+v", want, trace.Collectors)
+	}
+	if trace.DurationSeconds <= 0 || trace.Sources.WMI == nil || trace.Sources.PerfObjects == nil {
+		t.Errorf("unexpected trace %+v", trace)
+	}
+
+	if _, ok := cache.stale("volumes"); ok {
+		t.Error("expected the debug scrape to leave the cache alone")
+	}
+	if got := status.list([]string{"volumes"}, nil); len(got) != 1 || len(got[0].History) != 0 {
+		t.Errorf("expected the debug scrape to leave the status alone, got %+v", got)
+	}
+	if files, err := ioutil.ReadDir(recordDir); err != nil || len(files) != 0 {
+		t.Errorf("expected the debug scrape not to be recorded, got %v, %v", files, err)
+	}
+
+	for target, want := range map[string]time.Duration{
+		"/debug/scrape?timeout=2s":              2 * time.Second,
+		"/debug/scrape?timeout=1h":              9 * time.Second,
+		"/debug/scrape?timeout=1h&module=slow":  59 * time.Second,
+		"/debug/scrape?timeout=30s&module=slow": 30 * time.Second,
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target+"&collect[]=volumes", nil))
+		if rec.Code != http.StatusOK || gotTimeout != want {
+			t.Errorf("%s: expected a scrape with timeout %s, got %d and %s", target, want, rec.Code, gotTimeout)
+		}
+	}
+
+	for _, target := range []string{"/debug/scrape?timeout=soon", "/debug/scrape?module=sql"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected %s to be refused, got %d", target, rec.Code)
+		}
+	}
+}
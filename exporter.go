@@ -1,24 +1,26 @@
-// +build windows
-
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"golang.org/x/sys/windows/svc"
-
-	"github.com/StackExchange/wmi"
 	"github.com/prometheus-community/windows_exporter/collector"
 	"github.com/prometheus-community/windows_exporter/config"
+	"github.com/prometheus-community/windows_exporter/format"
+	"github.com/prometheus-community/windows_exporter/otlp"
+	"github.com/prometheus-community/windows_exporter/relabel"
+	"github.com/prometheus-community/windows_exporter/remotewrite"
+	"github.com/prometheus-community/windows_exporter/web"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/log"
@@ -29,6 +31,30 @@ import (
 type windowsCollector struct {
 	maxScrapeDuration time.Duration
 	collectors        map[string]collector.Collector
+	// If set, every scrape is recorded to a file in this directory.
+	recordDir string
+	// Number of recordings kept in recordDir, 0 to keep all.
+	recordMaxFiles int
+	// If set, scrapes read from recordings instead of the live sources.
+	replayer *collector.Replayer
+	// Timeouts of individual collectors, capped by maxScrapeDuration.
+	collectorTimeouts map[string]time.Duration
+	// Runs of collectors in flight, shared between scrapes.
+	runs *collectorRuns
+	// Cached metrics of collectors with a cache TTL, shared between scrapes.
+	cache *collectorCache
+	// Filters of the series of collectors, shared between scrapes.
+	filters map[string][]*seriesFilter
+	// Relabeling rules of the metrics of collectors, shared between scrapes.
+	relabeler *relabel.Relabeler
+	// Series limits of collectors, shared between scrapes.
+	limiter *seriesLimiter
+	// Status of the recent runs of collectors, shared between scrapes.
+	status *collectorStatus
+	// Scrape module of the collectors, empty for the top level.
+	module string
+	// If set, the scrape is traced into it.
+	trace *scrapeTrace
 }
 
 // Same struct prometheus uses for their /version endpoint.
@@ -46,6 +72,9 @@ const (
 	defaultCollectors            = "cpu,cs,logical_disk,net,os,service,system,textfile"
 	defaultCollectorsPlaceholder = "[defaults]"
 	serviceName                  = "windows_exporter"
+	// defaultTimeout is the scrape timeout in seconds when Prometheus gives
+	// none.
+	defaultTimeout = 10.0
 )
 
 var (
@@ -67,6 +96,12 @@ var (
 		[]string{"collector"},
 		nil,
 	)
+	scrapeSkippedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "collector_skipped_total"),
+		"windows_exporter: Number of scrapes which did not run the collector, because a previous run timed out and was still in flight.",
+		[]string{"collector"},
+		nil,
+	)
 	snapshotDuration = prometheus.NewDesc(
 		prometheus.BuildFQName(collector.Namespace, "exporter", "perflib_snapshot_duration_seconds"),
 		"Duration of perflib snapshot capture",
@@ -88,111 +123,373 @@ const (
 	pending collectorOutcome = iota
 	success
 	failed
+	skipped
 )
 
+// metricSink receives the metrics of a scrape, along with the name of the
+// collector they belong to. Exporter-wide metrics have an empty collector name.
+type metricSink func(collectorName string, m prometheus.Metric)
+
 // Collect sends the collected metrics from each of the collectors to
 // prometheus.
 func (coll windowsCollector) Collect(ch chan<- prometheus.Metric) {
+	coll.collect(func(_ string, m prometheus.Metric) {
+		ch <- m
+	})
+}
+
+// collect runs all collectors and passes their metrics to sink, once
+// relabeled. sink is never called once collect has returned.
+func (coll windowsCollector) collect(sink metricSink) {
 	t := time.Now()
+	// Metrics of collectors are relabeled as they are produced, so they are
+	// limited and cached once relabeled, and passed on by emit. Other metrics
+	// are relabeled by sink.
+	emit := sink
+	sink = relabelSink(coll.relabeler, sink)
 	cs := make([]string, 0, len(coll.collectors))
 	for name := range coll.collectors {
 		cs = append(cs, name)
 	}
-	scrapeContext, err := collector.PrepareScrapeContext(cs)
-	ch <- prometheus.MustNewConstMetric(
+	perflibSource, wmiQuerier, err := coll.sources()
+	if err != nil {
+		coll.trace.fail(err)
+		sink("", prometheus.NewInvalidMetric(scrapeSuccessDesc, fmt.Errorf("failed to load scrape sources: %v", err)))
+		return
+	}
+	var recorder *collector.Recorder
+	if coll.recordDir != "" {
+		recorder = collector.NewRecorder(cs, perflibSource, wmiQuerier)
+		perflibSource, wmiQuerier = recorder, recorder
+		defer func() {
+			path, err := recorder.WriteFile(coll.recordDir)
+			if err != nil {
+				log.Errorf("failed to write scrape recording: %v", err)
+				return
+			}
+			log.Debugf("scrape recorded to %s", path)
+			if coll.recordMaxFiles > 0 {
+				if err := collector.PruneRecordings(coll.recordDir, coll.recordMaxFiles); err != nil {
+					log.Errorf("failed to prune scrape recordings: %v", err)
+				}
+			}
+		}()
+	}
+	tracer, perflibSource, wmiQuerier := coll.trace.wrap(perflibSource, wmiQuerier)
+
+	ctx, cancel := context.WithTimeout(context.Background(), coll.maxScrapeDuration)
+	// Collectors still running when the scrape ends stop issuing queries.
+	defer cancel()
+
+	scrapeContext, err := collector.PrepareScrapeContext(ctx, cs, perflibSource, wmiQuerier)
+	sink("", prometheus.MustNewConstMetric(
 		snapshotDuration,
 		prometheus.GaugeValue,
 		time.Since(t).Seconds(),
-	)
+	))
 	if err != nil {
-		ch <- prometheus.NewInvalidMetric(scrapeSuccessDesc, fmt.Errorf("failed to prepare scrape: %v", err))
+		coll.trace.fail(err)
+		sink("", prometheus.NewInvalidMetric(scrapeSuccessDesc, fmt.Errorf("failed to prepare scrape: %v", err)))
 		return
 	}
 
 	wg := sync.WaitGroup{}
-	wg.Add(len(coll.collectors))
 	collectorOutcomes := make(map[string]collectorOutcome)
 	for name := range coll.collectors {
 		collectorOutcomes[name] = pending
 	}
 
-	metricsBuffer := make(chan prometheus.Metric)
 	l := sync.Mutex{}
 	finished := false
-	go func() {
-		for m := range metricsBuffer {
-			l.Lock()
-			if !finished {
-				ch <- m
+	// Metrics of cached collectors are held back until the outcome of the
+	// run is known, and replaced by the cached ones if it failed. Metrics of
+	// limited collectors are held back until all are known, to pick those
+	// within the limit, and so are all metrics if the total is limited.
+	held := make(map[string][]prometheus.Metric)
+	served := make(map[string]cacheEntry)
+	for name, c := range coll.collectors {
+		cached := coll.cache.enabled(name)
+		if cached {
+			if e, ok := coll.cache.fresh(name, t); ok {
+				// Collectors started before are already forwarding metrics.
+				l.Lock()
+				if !coll.limiter.limitsTotal() {
+					for _, m := range e.metrics {
+						emit(name, m)
+					}
+				}
+				served[name] = e
+				collectorOutcomes[name] = success
+				l.Unlock()
+				continue
 			}
+		}
+
+		run, ok := coll.runs.start(name)
+		if !ok {
+			l.Lock()
+			collectorOutcomes[name] = skipped
 			l.Unlock()
+			continue
 		}
-	}()
 
-	for name, c := range coll.collectors {
+		collectorCtx, cancelCollector := context.WithTimeout(ctx, coll.collectorTimeout(name))
+		ch := make(chan prometheus.Metric)
+		forwarded := make(chan struct{})
+		hold := cached || coll.limiter.enabled(name) || coll.limiter.limitsTotal()
+		// descs and series are only read once forwarded is closed.
+		descs := make(map[*prometheus.Desc]prometheus.Metric)
+		series := 0
+		go func(name string, hold bool) {
+			defer close(forwarded)
+			// Keep draining after the scrape ended, so the collector can run
+			// to completion.
+			for m := range ch {
+				if d := m.Desc(); d != scrapeDurationDesc {
+					descs[d] = m
+					series++
+				}
+				if !keepSeries(coll.filters[name], m) {
+					continue
+				}
+				m, keep := relabelMetric(coll.relabeler, name, m)
+				if !keep {
+					continue
+				}
+				l.Lock()
+				if !finished {
+					if hold {
+						held[name] = append(held[name], m)
+					} else {
+						emit(name, m)
+					}
+				}
+				l.Unlock()
+			}
+		}(name, hold)
+
+		done := make(chan collectorOutcome, 1)
 		go func(name string, c collector.Collector) {
+			sc := scrapeContext.WithContext(collectorCtx)
+			if tracer != nil {
+				sc = sc.WithWMIQuerier(tracer.WMIQuerier(name))
+			}
+			start := time.Now()
+			outcome, duration, err := execute(name, c, sc, ch)
+			close(ch)
+			<-forwarded
+			coll.status.ran(coll.module, name, start, duration, err, descs)
+			coll.trace.ran(name, duration, err, series)
+			done <- outcome
+			coll.runs.finish(name, run)
+			cancelCollector()
+		}(name, c)
+
+		wg.Add(1)
+		go func(name string) {
 			defer wg.Done()
-			outcome := execute(name, c, scrapeContext, metricsBuffer)
+			var outcome collectorOutcome
+			select {
+			case outcome = <-done:
+			case <-collectorCtx.Done():
+				select {
+				case outcome = <-done:
+				default:
+					coll.runs.abandon(name, run)
+					return
+				}
+			}
 			l.Lock()
 			if !finished {
 				collectorOutcomes[name] = outcome
 			}
 			l.Unlock()
-		}(name, c)
+		}(name)
 	}
 
-	allDone := make(chan struct{})
-	go func() {
-		wg.Wait()
-		close(allDone)
-		close(metricsBuffer)
-	}()
-
-	// Wait until either all collectors finish, or timeout expires
-	select {
-	case <-allDone:
-	case <-time.After(coll.maxScrapeDuration):
-	}
+	// Wait until every collector either finished or timed out. Collector
+	// timeouts are bounded by the scrape timeout.
+	wg.Wait()
 
 	l.Lock()
 	finished = true
 
+	// serving holds the metrics of each collector left to serve, within its
+	// limit.
+	serving := make(map[string][]prometheus.Metric, len(collectorOutcomes))
+	caches := make(map[string]servedCache)
+	for name, outcome := range collectorOutcomes {
+		metrics, ran := held[name]
+		if ran && coll.limiter.enabled(name) {
+			metrics = coll.limiter.apply(name, metrics)
+		}
+		if coll.cache.enabled(name) {
+			c := coll.cached(name, outcome, metrics, served)
+			metrics = c.metrics
+			caches[name] = c
+		}
+		serving[name] = metrics
+	}
+	if coll.limiter.limitsTotal() {
+		coll.limiter.applyTotal(serving)
+	}
+
 	remainingCollectorNames := make([]string, 0)
+	skippedCollectorNames := make([]string, 0)
 	for name, outcome := range collectorOutcomes {
+		for _, m := range serving[name] {
+			emit(name, m)
+		}
+		if c, ok := caches[name]; ok {
+			coll.sendCacheMetrics(name, c, sink)
+		}
+
 		var successValue, timeoutValue float64
-		if outcome == pending {
+		if outcome == pending || outcome == skipped {
 			timeoutValue = 1.0
+		}
+		if outcome == pending {
 			remainingCollectorNames = append(remainingCollectorNames, name)
 		}
+		if outcome == skipped {
+			skippedCollectorNames = append(skippedCollectorNames, name)
+		}
 		if outcome == success {
 			successValue = 1.0
 		}
+		if _, hit := served[name]; !hit {
+			coll.status.record(coll.module, name, outcome.history(), t)
+			coll.trace.outcome(name, outcome.history())
+		}
 
-		ch <- prometheus.MustNewConstMetric(
+		sink(name, prometheus.MustNewConstMetric(
 			scrapeSuccessDesc,
 			prometheus.GaugeValue,
 			successValue,
 			name,
-		)
-		ch <- prometheus.MustNewConstMetric(
+		))
+		sink(name, prometheus.MustNewConstMetric(
 			scrapeTimeoutDesc,
 			prometheus.GaugeValue,
 			timeoutValue,
 			name,
-		)
+		))
+		sink(name, prometheus.MustNewConstMetric(
+			scrapeSkippedDesc,
+			prometheus.CounterValue,
+			coll.runs.skippedTotal(name),
+			name,
+		))
+		for _, f := range coll.filters[name] {
+			sink(name, prometheus.MustNewConstMetric(
+				filteredSeriesDesc,
+				prometheus.CounterValue,
+				f.droppedTotal(),
+				name,
+				f.String(),
+			))
+		}
+		if coll.limiter.counted(name) {
+			dropped, hit := coll.limiter.counters(name)
+			sink(name, prometheus.MustNewConstMetric(
+				seriesDroppedDesc,
+				prometheus.CounterValue,
+				dropped,
+				name,
+			))
+			sink(name, prometheus.MustNewConstMetric(
+				seriesLimitHitDesc,
+				prometheus.GaugeValue,
+				boolToFloat(hit),
+				name,
+			))
+		}
+		if coll.relabeler.Enabled(name) {
+			sink(name, prometheus.MustNewConstMetric(
+				relabelDroppedDesc,
+				prometheus.CounterValue,
+				coll.relabeler.Dropped(name),
+				name,
+			))
+		}
 	}
 
 	if len(remainingCollectorNames) > 0 {
 		log.Warn("Collection timed out, still waiting for ", remainingCollectorNames)
+		if recorder != nil {
+			recorder.MarkPartial(remainingCollectorNames)
+		}
+	}
+	if len(skippedCollectorNames) > 0 {
+		log.Warn("Previous run timed out and is still in flight, skipping ", skippedCollectorNames)
 	}
 
 	l.Unlock()
 }
 
-func execute(name string, c collector.Collector, ctx *collector.ScrapeContext, ch chan<- prometheus.Metric) collectorOutcome {
+// servedCache is the cache entry of a collector served by a scrape, and the
+// metrics of it left to serve.
+type servedCache struct {
+	entry   cacheEntry
+	stale   bool
+	metrics []prometheus.Metric
+}
+
+// cached returns the metrics of a cached collector to serve. Metrics of a
+// successful run replace the cached ones, otherwise the cached metrics are
+// served and flagged as stale. Metrics of a fresh entry were served as the
+// scrape started, unless the total is limited.
+func (coll windowsCollector) cached(name string, outcome collectorOutcome, metrics []prometheus.Metric, served map[string]cacheEntry) servedCache {
+	if e, hit := served[name]; hit {
+		c := servedCache{entry: e}
+		if coll.limiter.limitsTotal() {
+			c.metrics = e.metrics
+		}
+		return c
+	}
+	if outcome == success {
+		e := cacheEntry{metrics: metrics, collected: time.Now()}
+		coll.cache.store(name, e.metrics, e.collected)
+		return servedCache{entry: e, metrics: e.metrics}
+	}
+	if e, ok := coll.cache.stale(name); ok {
+		return servedCache{entry: e, stale: true, metrics: e.metrics}
+	}
+	return servedCache{}
+}
+
+// sendCacheMetrics passes the metrics of the cache of a collector to sink.
+func (coll windowsCollector) sendCacheMetrics(name string, c servedCache, sink metricSink) {
+	hits, misses := coll.cache.counters(name)
+	sink(name, prometheus.MustNewConstMetric(cacheHitsDesc, prometheus.CounterValue, hits, name))
+	sink(name, prometheus.MustNewConstMetric(cacheMissesDesc, prometheus.CounterValue, misses, name))
+	sink(name, prometheus.MustNewConstMetric(cacheStaleDesc, prometheus.GaugeValue, boolToFloat(c.stale), name))
+	if !c.entry.collected.IsZero() {
+		sink(name, prometheus.MustNewConstMetric(cacheAgeDesc, prometheus.GaugeValue, time.Since(c.entry.collected).Seconds(), name))
+	}
+}
+
+// collectorTimeout returns the time the named collector may run for.
+func (coll windowsCollector) collectorTimeout(name string) time.Duration {
+	if timeout, ok := coll.collectorTimeouts[name]; ok && timeout < coll.maxScrapeDuration {
+		return timeout
+	}
+	return coll.maxScrapeDuration
+}
+
+// sources returns the perflib and WMI sources to be used for a single scrape.
+func (coll windowsCollector) sources() (collector.PerflibSource, collector.WMIQuerier, error) {
+	if coll.replayer != nil {
+		return coll.replayer.Next()
+	}
+	return collector.DefaultPerflibSource, collector.DefaultWMIQuerier, nil
+}
+
+func execute(name string, c collector.Collector, ctx *collector.ScrapeContext, ch chan<- prometheus.Metric) (collectorOutcome, time.Duration, error) {
 	t := time.Now()
 	err := c.Collect(ctx, ch)
-	duration := time.Since(t).Seconds()
+	elapsed := time.Since(t)
+	duration := elapsed.Seconds()
 	ch <- prometheus.MustNewConstMetric(
 		scrapeDurationDesc,
 		prometheus.GaugeValue,
@@ -202,10 +499,10 @@ func execute(name string, c collector.Collector, ctx *collector.ScrapeContext, c
 
 	if err != nil {
 		log.Errorf("collector %s failed after %fs: %s", name, duration, err)
-		return failed
+		return failed, elapsed, err
 	}
 	log.Debugf("collector %s succeeded after %fs.", name, duration)
-	return success
+	return success, elapsed, nil
 }
 
 func expandEnabledCollectors(enabled string) []string {
@@ -224,12 +521,12 @@ func expandEnabledCollectors(enabled string) []string {
 	return result
 }
 
-func loadCollectors(list string) (map[string]collector.Collector, error) {
+func loadCollectors(list string, settings collector.Settings, prev map[string]collector.Collector) (map[string]collector.Collector, error) {
 	collectors := map[string]collector.Collector{}
 	enabled := expandEnabledCollectors(list)
 
 	for _, name := range enabled {
-		c, err := collector.Build(name)
+		c, err := collector.Rebuild(name, settings, prev[name])
 		if err != nil {
 			return nil, err
 		}
@@ -239,70 +536,235 @@ func loadCollectors(list string) (map[string]collector.Collector, error) {
 	return collectors, nil
 }
 
-func initWbem() {
-	// This initialization prevents a memory leak on WMF 5+. See
-	// https://github.com/prometheus-community/windows_exporter/issues/77 and
-	// linked issues for details.
-	log.Debugf("Initializing SWbemServices")
-	s, err := wmi.InitializeSWbemServices(wmi.DefaultClient)
-	if err != nil {
-		log.Fatal(err)
-	}
-	wmi.DefaultClient.AllowMissingFields = true
-	wmi.DefaultClient.SWbemServicesClient = s
-}
+// Flags of the collector set are not bound to variables: they are read
+// from the loaded configuration on every reload, see newCollectorFlags.
+var (
+	// The configuration files are located before the environment is bound
+	// to the other flags, so they read their variables themselves.
+	configFiles = config.Strings(kingpin.Flag(
+		"config.file",
+		"YAML configuration file to use. Values set in this file will be overriden by CLI flags. May be repeated, later files override earlier ones.",
+	).Envar(config.EnvName("config.file")))
+	configDir = kingpin.Flag(
+		"config.dir",
+		"Directory of YAML configuration files, loaded in lexical order after those of --config.file.",
+	).Envar(config.EnvName("config.dir")).String()
+	configCheck = kingpin.Flag(
+		"config.check",
+		"If true, check the configuration file and flags, print any errors and exit. The exit status is non-zero if the configuration is invalid.",
+	).Bool()
+	configWatchInterval = kingpin.Flag(
+		"config.watch-interval",
+		"Interval at which to check the configuration file for changes, and reload it. 0 to disable.",
+	).Default("0s").Duration()
+	listenAddress = kingpin.Flag(
+		"telemetry.addr",
+		"host:port for exporter.",
+	).Default(":9182").String()
+	metricsPath = kingpin.Flag(
+		"telemetry.path",
+		"URL path for surfacing collected metrics.",
+	).Default("/metrics").String()
+	webConfigFile = kingpin.Flag(
+		"web.config.file",
+		"Path to a web configuration file enabling TLS and basic authentication. The file is reloaded when it changes.",
+	).String()
+	maxRequests = kingpin.Flag(
+		"telemetry.max-requests",
+		"Maximum number of concurrent requests. 0 to disable.",
+	).Default("5").Int()
+	_ = kingpin.Flag(
+		"collectors.enabled",
+		"Comma-separated list of collectors to use. Use '[defaults]' as a placeholder for all the collectors enabled by default.").
+		Default(defaultCollectors).String()
+	printCollectors = kingpin.Flag(
+		"collectors.print",
+		"If true, print available collectors and exit.",
+	).Bool()
+	timeoutMargin = kingpin.Flag(
+		"scrape.timeout-margin",
+		"Seconds to subtract from the timeout allowed by the client. Tune to allow for overhead or high loads.",
+	).Default("0.5").Float64()
+	recordDir = kingpin.Flag(
+		"scrape.record-dir",
+		"Directory to write a JSON recording of the perflib objects and WMI results read by each scrape to. Disabled if empty.",
+	).String()
+	recordMaxFiles = kingpin.Flag(
+		"scrape.record-max-files",
+		"Number of recordings kept in --scrape.record-dir, the oldest are removed. 0 to keep all.",
+	).Default("100").Int()
+	replayDir = kingpin.Flag(
+		"scrape.replay-dir",
+		"Directory of scrape recordings to serve in place of the live perflib and WMI sources. Disabled if empty.",
+	).String()
+	scrapeMode = kingpin.Flag(
+		"scrape.mode",
+		"How collectors are run. \"on-demand\" runs them on each request, \"background\" runs them every scrape.interval and serves the most recent completed snapshot.",
+	).Default(scrapeModeOnDemand).Enum(scrapeModeOnDemand, scrapeModeBackground)
+	scrapeInterval = kingpin.Flag(
+		"scrape.interval",
+		"Interval between background scrapes, which also bounds their duration. Only used with --scrape.mode=background.",
+	).Default("15s").Duration()
+	_ = config.Strings(kingpin.Flag(
+		"scrape.collector-timeout",
+		"Timeout of a single collector, as collector=duration. May be repeated, or hold comma-separated pairs. Collectors without a timeout may run for the whole scrape.",
+	))
+	_ = config.Strings(kingpin.Flag(
+		"scrape.cache-ttl",
+		"Time to reuse the metrics of a collector for, as collector=duration. May be repeated, or hold comma-separated pairs. Cached metrics are also served when a run fails or times out.",
+	))
+	_ = config.Strings(kingpin.Flag(
+		"collector.filter",
+		"Filter of the series of a collector by a label, as collector:label=~regexp to keep the matching series, or collector:label!~regexp to drop them. May be repeated.",
+	))
+	constLabelFlags = config.Strings(kingpin.Flag(
+		"telemetry.const-label",
+		"Label added to every exported metric, as name=value. May be repeated.",
+	))
+	constLabelConflict = kingpin.Flag(
+		"telemetry.const-label-conflict",
+		"What to do with a metric which already has a constant label. \"rename\" moves its label to exported_<name>, \"keep\" keeps its value, \"override\" replaces it.",
+	).Default(relabel.ConflictRename).Enum(relabel.ConflictRename, relabel.ConflictKeep, relabel.ConflictOverride)
+	_ = config.Strings(kingpin.Flag(
+		"scrape.series-limit",
+		"Maximum number of series of a collector, as collector=count. May be repeated, or hold comma-separated pairs. 0 disables the limit of the collector.",
+	))
+	_ = kingpin.Flag(
+		"scrape.default-series-limit",
+		"Maximum number of series of collectors without a --scrape.series-limit. 0 to disable.",
+	).Default("0").Int()
+	_ = kingpin.Flag(
+		"scrape.total-series-limit",
+		"Maximum number of series of all collectors of a scrape, after the limits of collectors. 0 to disable.",
+	).Default("0").Int()
+	_ = kingpin.Flag(
+		"relabel.config.file",
+		"Path to a file of relabeling rules, rewriting or dropping the series of collectors before they are exposed. Disabled if empty.",
+	).String()
+	otlpEndpoint = kingpin.Flag(
+		"otlp.endpoint",
+		"URL of an OpenTelemetry collector to export metrics to over OTLP. Disabled if empty.",
+	).String()
+	otlpProtocol = kingpin.Flag(
+		"otlp.protocol",
+		"OTLP transport, \"http/protobuf\" or \"grpc\".",
+	).Default(otlp.ProtocolHTTP).Enum(otlp.ProtocolHTTP, otlp.ProtocolGRPC)
+	otlpInterval = kingpin.Flag(
+		"otlp.interval",
+		"Interval between exports, which also bounds the duration of the collectors run for them.",
+	).Default("15s").Duration()
+	otlpTimeout = kingpin.Flag(
+		"otlp.timeout",
+		"Timeout of a single OTLP export request.",
+	).Default("10s").Duration()
+	otlpHeaderFlags = config.Strings(kingpin.Flag(
+		"otlp.header",
+		"Header sent with OTLP export requests, as Name=Value. May be repeated.",
+	))
+	otlpCAFile = kingpin.Flag(
+		"otlp.tls.ca-file",
+		"File holding the CA certificates to verify the OTLP endpoint with, instead of the system roots.",
+	).String()
+	otlpCertFile = kingpin.Flag(
+		"otlp.tls.cert-file",
+		"File holding the client certificate to present to the OTLP endpoint.",
+	).String()
+	otlpKeyFile = kingpin.Flag(
+		"otlp.tls.key-file",
+		"File holding the key of the client certificate.",
+	).String()
+	otlpServerName = kingpin.Flag(
+		"otlp.tls.server-name",
+		"Name to verify the certificate of the OTLP endpoint against, instead of its host.",
+	).String()
+	otlpInsecureSkipVerify = kingpin.Flag(
+		"otlp.tls.insecure-skip-verify",
+		"Do not verify the certificate of the OTLP endpoint.",
+	).Bool()
+	pushURL = kingpin.Flag(
+		"push.url",
+		"URL of a Prometheus remote_write endpoint to push metrics to. Disabled if empty.",
+	).String()
+	pushInterval = kingpin.Flag(
+		"push.interval",
+		"Interval between pushes, which also bounds the duration of the collectors run for them.",
+	).Default("15s").Duration()
+	pushTimeout = kingpin.Flag(
+		"push.timeout",
+		"Timeout of a single remote_write request.",
+	).Default("10s").Duration()
+	pushQueueDir = kingpin.Flag(
+		"push.queue-dir",
+		"Directory to queue samples in until they are sent.",
+	).Default(filepath.Join(os.TempDir(), "windows_exporter_push")).String()
+	pushQueueMaxBytes = kingpin.Flag(
+		"push.queue-max-bytes",
+		"Maximum size of the queue. Once exceeded, the oldest samples are dropped.",
+	).Default("104857600").Int64()
+	pushMinBackoff = kingpin.Flag(
+		"push.min-backoff",
+		"Initial delay before retrying a failed remote_write request. The delay doubles on every retry.",
+	).Default("500ms").Duration()
+	pushMaxBackoff = kingpin.Flag(
+		"push.max-backoff",
+		"Maximum delay before retrying a failed remote_write request.",
+	).Default("30s").Duration()
+	pushUsername = kingpin.Flag(
+		"push.basic-auth.username",
+		"Username for basic authentication against the remote_write endpoint.",
+	).String()
+	pushPasswordFile = kingpin.Flag(
+		"push.basic-auth.password-file",
+		"File holding the password for basic authentication against the remote_write endpoint.",
+	).String()
+	pushBearerTokenFile = kingpin.Flag(
+		"push.bearer-token-file",
+		"File holding a bearer token for authentication against the remote_write endpoint.",
+	).String()
+)
 
 func main() {
-	var (
-		configFile = kingpin.Flag(
-			"config.file",
-			"YAML configuration file to use. Values set in this file will be overriden by CLI flags.",
-		).String()
-		listenAddress = kingpin.Flag(
-			"telemetry.addr",
-			"host:port for exporter.",
-		).Default(":9182").String()
-		metricsPath = kingpin.Flag(
-			"telemetry.path",
-			"URL path for surfacing collected metrics.",
-		).Default("/metrics").String()
-		maxRequests = kingpin.Flag(
-			"telemetry.max-requests",
-			"Maximum number of concurrent requests. 0 to disable.",
-		).Default("5").Int()
-		enabledCollectors = kingpin.Flag(
-			"collectors.enabled",
-			"Comma-separated list of collectors to use. Use '[defaults]' as a placeholder for all the collectors enabled by default.").
-			Default(defaultCollectors).String()
-		printCollectors = kingpin.Flag(
-			"collectors.print",
-			"If true, print available collectors and exit.",
-		).Bool()
-		timeoutMargin = kingpin.Flag(
-			"scrape.timeout-margin",
-			"Seconds to subtract from the timeout allowed by the client. Tune to allow for overhead or high loads.",
-		).Default("0.5").Float64()
-	)
-
 	log.AddFlags(kingpin.CommandLine)
 	kingpin.Version(version.Print("windows_exporter"))
 	kingpin.HelpFlag.Short('h')
+	defaults := config.SaveDefaults(kingpin.CommandLine)
 
 	// Load values from configuration file(s). Executable flags must first be parsed, in order
 	// to load the specified file(s).
 	kingpin.Parse()
 
-	if *configFile != "" {
-		resolver, err := config.NewResolver(*configFile)
-		if err != nil {
-			log.Fatalf("could not load config file: %v\n", err)
+	loader := &configLoader{
+		app:      kingpin.CommandLine,
+		args:     os.Args[1:],
+		environ:  os.Environ(),
+		files:    append([]string(nil), *configFiles...),
+		dir:      *configDir,
+		defaults: defaults,
+	}
+	// Parse flags once more to include those discovered in configuration
+	// file(s) and the environment.
+	loaded, err := loader.load()
+	if err == nil {
+		err = loader.apply(loaded)
+	}
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+
+	if *configCheck {
+		flags, err := newCollectorFlags(loaded)
+		if err == nil {
+			err = checkConfig(flags)
+		}
+		if err == nil {
+			_, err = config.ParseConstLabels(*constLabelFlags)
 		}
-		err = resolver.Bind(kingpin.CommandLine, os.Args[1:])
 		if err != nil {
-			log.Fatalf("%v\n", err)
+			fmt.Fprintf(os.Stderr, "Configuration is invalid: %v\n", err)
+			os.Exit(1)
 		}
-		// Parse flags once more to include those discovered in configuration file(s).
-		kingpin.Parse()
+		fmt.Println("Configuration is valid")
+		return
 	}
 
 	if *printCollectors {
@@ -319,53 +781,238 @@ func main() {
 		return
 	}
 
-	initWbem()
+	// Lists asked for by flags of collectors, such as the classes of the
+	// mssql collector, are printed at startup only, as the exporter exits
+	// after printing them.
+	collectorFlags, err := newCollectorFlags(loaded)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	if collector.PrintListings(expandEnabledCollectors(collectorFlags.enabled), collectorFlags.settings, os.Stdout) {
+		return
+	}
 
-	isInteractive, err := svc.IsAnInteractiveSession()
+	labels, err := config.ParseConstLabels(*constLabelFlags)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("Invalid constant label: %s", err)
 	}
+	constLabels := relabel.NewConstLabels(labels, *constLabelConflict)
 
-	stopCh := make(chan bool)
-	if !isInteractive {
-		go func() {
-			err = svc.Run(serviceName, &windowsExporterService{stopCh: stopCh})
-			if err != nil {
-				log.Errorf("Failed to start service: %v", err)
-			}
-		}()
+	var replayer *collector.Replayer
+	if *replayDir != "" {
+		var err error
+		replayer, err = collector.NewReplayer(*replayDir)
+		if err != nil {
+			log.Fatalf("Couldn't load scrape recordings: %s", err)
+		}
+		log.Infof("Replaying scrape recordings from %s", *replayDir)
+	} else {
+		initWbem()
 	}
 
-	collectors, err := loadCollectors(*enabledCollectors)
+	stopCh := make(chan bool)
+	startService(stopCh)
+
+	reloader, err := newConfigReloader(loader, loaded, func(c *loadedConfig, prev *collectorSet) (*collectorSet, error) {
+		flags, err := newCollectorFlags(c)
+		if err != nil {
+			return nil, err
+		}
+		return buildCollectorSet(flags, prev)
+	})
 	if err != nil {
-		log.Fatalf("Couldn't load collectors: %s", err)
+		log.Fatalf("%s", err)
 	}
+	log.Infof("Enabled collectors: %v", strings.Join(keys(reloader.collectors().collectors), ", "))
 
-	log.Infof("Enabled collectors: %v", strings.Join(keys(collectors), ", "))
+	// Flags read below are not reloadable, so their values are captured
+	// before a reload can change them.
+	// Collectors of modules are distinct from the others, so their runs are
+	// tracked separately.
+	var runsMtx sync.Mutex
+	moduleRuns := make(map[string]*collectorRuns)
+	runsOf := func(module string) *collectorRuns {
+		runsMtx.Lock()
+		defer runsMtx.Unlock()
+		if moduleRuns[module] == nil {
+			moduleRuns[module] = newCollectorRuns()
+		}
+		return moduleRuns[module]
+	}
+	recordTo, recordKeep := *recordDir, *recordMaxFiles
+	status := newCollectorStatus()
+	newCollector := func(timeout time.Duration, requestedCollectors []string, module string) (*windowsCollector, error) {
+		set, err := reloader.collectors().module(module)
+		if err != nil {
+			return nil, err
+		}
+		collectors, err := set.filter(requestedCollectors)
+		if err != nil {
+			return nil, err
+		}
+		return &windowsCollector{
+			collectors:        collectors,
+			maxScrapeDuration: timeout,
+			recordDir:         recordTo,
+			recordMaxFiles:    recordKeep,
+			replayer:          replayer,
+			collectorTimeouts: set.timeouts,
+			runs:              runsOf(module),
+			cache:             set.cache,
+			filters:           set.filters,
+			relabeler:         set.relabeler,
+			limiter:           set.limiter,
+			status:            status,
+			module:            module,
+		}, nil
+	}
+	newScrapeCollector := func(timeout time.Duration, requestedCollectors []string, module string) (error, prometheus.Collector) {
+		c, err := newCollector(timeout, requestedCollectors, module)
+		if err != nil {
+			return err, nil
+		}
+		return nil, c
+	}
 
 	h := &metricsHandler{
-		timeoutMargin: *timeoutMargin,
-		collectorFactory: func(timeout time.Duration, requestedCollectors []string) (error, *windowsCollector) {
-			filteredCollectors := make(map[string]collector.Collector)
-			// scrape all enabled collectors if no collector is requested
-			if len(requestedCollectors) == 0 {
-				filteredCollectors = collectors
-			}
-			for _, name := range requestedCollectors {
-				col, exists := collectors[name]
-				if !exists {
-					return fmt.Errorf("unavailable collector: %s", name), nil
-				}
-				filteredCollectors[name] = col
-			}
-			return nil, &windowsCollector{
-				collectors:        filteredCollectors,
-				maxScrapeDuration: timeout,
+		timeoutMargin:    *timeoutMargin,
+		collectorFactory: newScrapeCollector,
+		moduleTimeout: func(module string) time.Duration {
+			set, err := reloader.collectors().module(module)
+			if err != nil {
+				return 0
 			}
+			return set.defaultTimeout
 		},
+		extraCollectors: []prometheus.Collector{reloader},
+		constLabels:     constLabels,
+	}
+
+	if *scrapeMode == scrapeModeBackground {
+		if *scrapeInterval <= 0 {
+			log.Fatalf("Invalid scrape interval %s for background scrapes", *scrapeInterval)
+		}
+		interval := *scrapeInterval
+		b := newBackgroundScraper(func() windowsCollector {
+			// Requesting all collectors cannot fail.
+			c, _ := newCollector(interval, nil, "")
+			return *c
+		}, interval)
+		go b.run(make(chan struct{}))
+		h.collectorFactory = func(timeout time.Duration, requestedCollectors []string, module string) (error, prometheus.Collector) {
+			// Modules are not scraped in the background, but on request.
+			if module != "" {
+				return newScrapeCollector(timeout, requestedCollectors, module)
+			}
+			return b.snapshotCollector(requestedCollectors)
+		}
+		log.Infof("Running collectors in the background every %s", *scrapeInterval)
+	}
+
+	if *pushURL != "" {
+		if *pushInterval <= 0 {
+			log.Fatalf("Invalid push interval %s", *pushInterval)
+		}
+		if *pushUsername != "" && *pushBearerTokenFile != "" {
+			log.Fatalf("Only one of basic authentication and a bearer token can be used for pushing")
+		}
+		password, err := readSecretFile(*pushPasswordFile)
+		if err != nil {
+			log.Fatalf("Invalid push password: %s", err)
+		}
+		token, err := readSecretFile(*pushBearerTokenFile)
+		if err != nil {
+			log.Fatalf("Invalid push bearer token: %s", err)
+		}
+		queue, err := remotewrite.OpenQueue(*pushQueueDir, *pushQueueMaxBytes)
+		if err != nil {
+			log.Fatalf("Couldn't open push queue: %s", err)
+		}
+		p := &pusher{
+			interval: *pushInterval,
+			factory: func(timeout time.Duration) (error, prometheus.Collector) {
+				return h.collectorFactory(timeout, nil, "")
+			},
+			sender: remotewrite.NewSender(&remotewrite.Client{
+				URL:         *pushURL,
+				Timeout:     *pushTimeout,
+				Username:    *pushUsername,
+				Password:    password,
+				BearerToken: token,
+			}, queue, *pushMinBackoff, *pushMaxBackoff),
+			extraCollectors: h.extraCollectors,
+			constLabels:     constLabels,
+		}
+		h.extraCollectors = append(h.extraCollectors, p.sender)
+		go p.run(context.Background())
+		log.Infof("Pushing metrics to %s every %s", *pushURL, *pushInterval)
+	}
+
+	if *otlpEndpoint != "" {
+		if *otlpInterval <= 0 {
+			log.Fatalf("Invalid OTLP interval %s", *otlpInterval)
+		}
+		headers, err := config.ParseHeaders(*otlpHeaderFlags)
+		if err != nil {
+			log.Fatalf("Invalid OTLP header: %s", err)
+		}
+		client, err := otlp.NewClient(*otlpEndpoint, *otlpProtocol, headers, *otlpTimeout, otlp.TLSConfig{
+			CAFile:             *otlpCAFile,
+			CertFile:           *otlpCertFile,
+			KeyFile:            *otlpKeyFile,
+			ServerName:         *otlpServerName,
+			InsecureSkipVerify: *otlpInsecureSkipVerify,
+		})
+		if err != nil {
+			log.Fatalf("Couldn't create OTLP client: %s", err)
+		}
+		p := &otlpPusher{
+			interval: *otlpInterval,
+			factory: func(timeout time.Duration) (error, prometheus.Collector) {
+				return h.collectorFactory(timeout, nil, "")
+			},
+			exporter:        otlp.NewExporter(client),
+			extraCollectors: h.extraCollectors,
+			constLabels:     constLabels,
+			start:           time.Now(),
+		}
+		h.extraCollectors = append(h.extraCollectors, p.exporter)
+		go p.run(context.Background())
+		log.Infof("Exporting metrics over OTLP to %s every %s", *otlpEndpoint, *otlpInterval)
+	}
+
+	if *configWatchInterval > 0 {
+		if len(loader.files) == 0 && loader.dir == "" {
+			log.Fatalf("Watching the configuration requires --config.file or --config.dir")
+		}
+		go reloader.watch(*configWatchInterval)
 	}
 
 	http.HandleFunc(*metricsPath, withConcurrencyLimit(*maxRequests, h.ServeHTTP))
+	http.Handle("/-/reload", reloader)
+	http.HandleFunc("/config", reloader.serveConfig)
+	listStatus := func() []collectorStatusJSON {
+		set := reloader.collectors()
+		enabled := map[string]map[string]bool{"": {}}
+		for name := range set.collectors {
+			enabled[""][name] = true
+		}
+		for module, m := range set.modules {
+			enabled[module] = make(map[string]bool)
+			for name := range m.collectors {
+				enabled[module][name] = true
+			}
+		}
+		return status.list(collector.Available(), enabled)
+	}
+	http.HandleFunc("/collectors", serveStatus(listStatus))
+	debugScrape := &debugScrapeHandler{
+		timeout:       time.Duration((defaultTimeout - *timeoutMargin) * float64(time.Second)),
+		timeoutMargin: time.Duration(*timeoutMargin * float64(time.Second)),
+		moduleTimeout: h.moduleTimeout,
+		newCollector:  newCollector,
+	}
+	http.HandleFunc("/debug/scrape", withConcurrencyLimit(*maxRequests, debugScrape.ServeHTTP))
 	http.HandleFunc("/health", healthCheck)
 	http.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
 		// we can't use "version" directly as it is a package, and not an object that
@@ -388,7 +1035,13 @@ func main() {
 <body>
 <h1>windows_exporter</h1>
 <p><a href="` + *metricsPath + `">Metrics</a></p>
-<p><i>` + version.Info() + `</i></p>
+<p><a href="/config">Configuration</a></p>
+<p><a href="/collectors">Collectors</a></p>
+`))
+		if err := writeStatusTable(w, listStatus()); err != nil {
+			log.Debugf("Failed to render the collector table: %v", err)
+		}
+		_, _ = w.Write([]byte(`<p><i>` + version.Info() + `</i></p>
 </body>
 </html>`))
 	})
@@ -398,7 +1051,8 @@ func main() {
 
 	go func() {
 		log.Infoln("Starting server on", *listenAddress)
-		log.Fatalf("cannot start windows_exporter: %s", http.ListenAndServe(*listenAddress, nil))
+		server := &http.Server{Addr: *listenAddress}
+		log.Fatalf("cannot start windows_exporter: %s", web.ListenAndServe(server, *webConfigFile))
 	}()
 
 	for {
@@ -444,40 +1098,21 @@ func withConcurrencyLimit(n int, next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-type windowsExporterService struct {
-	stopCh chan<- bool
-}
-
-func (s *windowsExporterService) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (ssec bool, errno uint32) {
-	const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown
-	changes <- svc.Status{State: svc.StartPending}
-	changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
-loop:
-	for {
-		select {
-		case c := <-r:
-			switch c.Cmd {
-			case svc.Interrogate:
-				changes <- c.CurrentStatus
-			case svc.Stop, svc.Shutdown:
-				s.stopCh <- true
-				break loop
-			default:
-				log.Error(fmt.Sprintf("unexpected control request #%d", c))
-			}
-		}
-	}
-	changes <- svc.Status{State: svc.StopPending}
-	return
-}
-
 type metricsHandler struct {
 	timeoutMargin    float64
-	collectorFactory func(timeout time.Duration, requestedCollectors []string) (error, *windowsCollector)
+	collectorFactory func(timeout time.Duration, requestedCollectors []string, module string) (error, prometheus.Collector)
+	// moduleTimeout returns the scrape timeout of a module when Prometheus
+	// gives none, 0 if unset. It may be nil.
+	moduleTimeout func(module string) time.Duration
+	// Collectors of the exporter itself, registered on every request.
+	extraCollectors []prometheus.Collector
+	// Labels added to every metric.
+	constLabels *relabel.ConstLabels
 }
 
 func (mh *metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	const defaultTimeout = 10.0
+	query := r.URL.Query()
+	module := query.Get("module")
 
 	var timeoutSeconds float64
 	if v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); v != "" {
@@ -487,26 +1122,73 @@ func (mh *metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			log.Warnf("Couldn't parse X-Prometheus-Scrape-Timeout-Seconds: %q. Defaulting timeout to %f", v, defaultTimeout)
 		}
 	}
+	if timeoutSeconds == 0 && mh.moduleTimeout != nil {
+		timeoutSeconds = mh.moduleTimeout(module).Seconds()
+	}
 	if timeoutSeconds == 0 {
 		timeoutSeconds = defaultTimeout
 	}
 	timeoutSeconds = timeoutSeconds - mh.timeoutMargin
 
-	reg := prometheus.NewRegistry()
-	err, wc := mh.collectorFactory(time.Duration(timeoutSeconds*float64(time.Second)), r.URL.Query()["collect[]"])
+	outputFormat := query.Get("format")
+	if outputFormat != "" && !format.Supported(outputFormat) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Unknown format %q, expected %q or %q", outputFormat, format.Influx, format.JSON)))
+		return
+	}
+	matcher, err := relabel.NewSeriesMatcher(query["match[]"], query["exclude[]"])
+	if err != nil {
+		log.Warnln("Couldn't parse series selectors: ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Couldn't parse series selectors: %s", err)))
+		return
+	}
+	err, wc := mh.collectorFactory(time.Duration(timeoutSeconds*float64(time.Second)), query["collect[]"], module)
 	if err != nil {
 		log.Warnln("Couldn't create filtered metrics handler: ", err)
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(fmt.Sprintf("Couldn't create filtered metrics handler: %s", err)))
 		return
 	}
-	reg.MustRegister(wc)
-	reg.MustRegister(
+	reg := newRegistry(matcher, mh.constLabels, append([]prometheus.Collector{wc}, mh.extraCollectors...)...)
+
+	if outputFormat != "" {
+		serveFormat(w, reg, outputFormat)
+		return
+	}
+	h := promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+	h.ServeHTTP(w, r)
+}
+
+// serveFormat serves the metrics of reg in one of the formats of the format
+// package. As with the Prometheus formats, nothing is served if gathering
+// fails.
+func serveFormat(w http.ResponseWriter, reg prometheus.Gatherer, outputFormat string) {
+	t := time.Now()
+	mfs, err := reg.Gather()
+	if err != nil {
+		http.Error(w, "An error has occurred while serving metrics:\n\n"+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", format.ContentType(outputFormat))
+	if err := format.Write(w, outputFormat, mfs, t); err != nil {
+		log.Debugf("Failed to write the metrics as %s: %v", outputFormat, err)
+	}
+}
+
+// newRegistry returns a gatherer of collectors and of the collectors of the
+// exporter process, adding the constant labels to the metrics, and keeping
+// those selected by matcher. The constant labels are added first, so
+// selectors can match them.
+func newRegistry(matcher *relabel.SeriesMatcher, labels *relabel.ConstLabels, collectors ...prometheus.Collector) prometheus.Gatherer {
+	collectors = append(collectors,
 		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
 		prometheus.NewGoCollector(),
 		version.NewCollector("windows_exporter"),
 	)
-
-	h := promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
-	h.ServeHTTP(w, r)
+	reg := prometheus.NewRegistry()
+	for _, c := range collectors {
+		reg.MustRegister(c)
+	}
+	return matcher.Gatherer(labels.Gatherer(reg))
 }
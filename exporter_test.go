@@ -1,11 +1,17 @@
-// +build windows
-
 package main
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"sort"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/prometheus-community/windows_exporter/relabel"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type expansionTestCase struct {
@@ -49,3 +55,160 @@ func TestExpandEnabled(t *testing.T) {
 		}
 	}
 }
+
+func TestNewRegistryConstLabels(t *testing.T) {
+	labels := relabel.NewConstLabels(map[string]string{"datacenter": "ams1"}, relabel.ConflictRename)
+	reg := newRegistry(nil, labels, metricsCollector{prometheus.MustNewConstMetric(testValueDesc, prometheus.GaugeValue, 1)})
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, mf := range mfs {
+		names[mf.GetName()] = true
+		for _, m := range mf.GetMetric() {
+			found := false
+			for _, l := range m.GetLabel() {
+				found = found || (l.GetName() == "datacenter" && l.GetValue() == "ams1")
+			}
+			if !found {
+				t.Errorf("expected the constant label on %s, got %v", mf.GetName(), m.GetLabel())
+			}
+		}
+	}
+	for _, name := range []string{"test_value", "go_goroutines", "windows_exporter_build_info"} {
+		if !names[name] {
+			t.Errorf("expected metric %s to be gathered", name)
+		}
+	}
+
+	matcher, err := relabel.NewSeriesMatcher([]string{`{datacenter="ams1"}`}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfs, err = newRegistry(matcher, labels, metricsCollector{prometheus.MustNewConstMetric(testValueDesc, prometheus.GaugeValue, 1)}).Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mfs) == 0 {
+		t.Error("expected selectors to match the constant labels")
+	}
+}
+
+func TestMetricsHandlerSelectors(t *testing.T) {
+	h := &metricsHandler{
+		collectorFactory: func(_ time.Duration, _ []string, _ string) (error, prometheus.Collector) {
+			return nil, metricsCollector{
+				prometheus.MustNewConstMetric(testValueDesc, prometheus.GaugeValue, 1),
+				prometheus.MustNewConstMetric(testVolumeDesc, prometheus.GaugeValue, 2, "C:"),
+				prometheus.MustNewConstMetric(testVolumeDesc, prometheus.GaugeValue, 3, "D:"),
+			}
+		},
+	}
+	query := url.Values{
+		"match[]":   {"test_*", "go_goroutines"},
+		"exclude[]": {`{volume="D:"}`},
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics?"+query.Encode(), nil))
+	body := rec.Body.String()
+	for _, want := range []string{"test_value 1", `test_volume_free_bytes{volume="C:"} 2`, "go_goroutines"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in the response, got\n%s", want, body)
+		}
+	}
+	for _, unwanted := range []string{`volume="D:"`, "go_threads", "windows_exporter_build_info"} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("expected no %q in the response, got\n%s", unwanted, body)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics?match[]=%7Bvolume%3D%22C%3A%7D", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for an invalid selector, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestMetricsHandlerFormat(t *testing.T) {
+	h := &metricsHandler{
+		collectorFactory: func(time.Duration, []string, string) (error, prometheus.Collector) {
+			return nil, metricsCollector{
+				prometheus.MustNewConstMetric(testVolumeDesc, prometheus.GaugeValue, 2, "C:"),
+			}
+		},
+	}
+	for _, tc := range []struct {
+		format      string
+		contentType string
+		want        string
+	}{
+		{"influx", "text/plain; charset=utf-8", "test_volume_free_bytes,volume=C: gauge=2 "},
+		{"json", "application/json", `"value": "2"`},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics?match[]=test_volume_free_bytes&format="+tc.format, nil))
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != tc.contentType {
+			t.Fatalf("expected %s to be served as %s, got %d with %s: %s", tc.format, tc.contentType, rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+		}
+		if body := rec.Body.String(); !strings.Contains(body, tc.want) || strings.Contains(body, "go_goroutines") {
+			t.Errorf("expected %q in the %s response, got\n%s", tc.want, tc.format, body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics?format=xml", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected an unknown format to be refused, got %d", rec.Code)
+	}
+}
+
+func TestMetricsHandlerModule(t *testing.T) {
+	var gotTimeout time.Duration
+	var gotModule string
+	h := &metricsHandler{
+		timeoutMargin: 0.5,
+		collectorFactory: func(timeout time.Duration, _ []string, module string) (error, prometheus.Collector) {
+			if module == "iis" {
+				return fmt.Errorf("unknown module: %s", module), nil
+			}
+			gotTimeout, gotModule = timeout, module
+			return nil, metricsCollector{}
+		},
+		moduleTimeout: func(module string) time.Duration {
+			if module == "sql" {
+				return 30 * time.Second
+			}
+			return 0
+		},
+	}
+
+	for _, tc := range []struct {
+		target  string
+		header  string
+		timeout time.Duration
+	}{
+		{"/metrics?module=sql", "", 29500 * time.Millisecond},
+		{"/metrics?module=sql", "5", 4500 * time.Millisecond},
+		{"/metrics", "", 9500 * time.Millisecond},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+		if tc.header != "" {
+			req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", tc.header)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected %s to succeed, got %d: %s", tc.target, rec.Code, rec.Body)
+		}
+		if want := req.URL.Query().Get("module"); gotModule != want || gotTimeout != tc.timeout {
+			t.Errorf("expected module %q with timeout %s for %s, got %q with %s", want, tc.timeout, tc.target, gotModule, gotTimeout)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics?module=iis", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected an unknown module to be refused, got %d", rec.Code)
+	}
+}
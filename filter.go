@@ -0,0 +1,164 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/prometheus-community/windows_exporter/collector"
+	"github.com/prometheus-community/windows_exporter/config"
+	"github.com/prometheus-community/windows_exporter/relabel"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+)
+
+var (
+	filteredSeriesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "collector_filtered_series_total"),
+		"windows_exporter: Number of series of the collector dropped by a --collector.filter rule.",
+		[]string{"collector", "filter"},
+		nil,
+	)
+	relabelDroppedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "relabel_dropped_samples_total"),
+		"windows_exporter: Number of samples of the collector dropped by relabeling rules.",
+		[]string{"collector"},
+		nil,
+	)
+)
+
+// seriesFilter is a rule of --collector.filter, with its compiled filter.
+type seriesFilter struct {
+	config.SeriesFilter
+	filter *collector.Filter
+
+	// dropped is shared with the filter of the same rule after reloads, see
+	// forkSeriesFilters.
+	dropped *uint64
+}
+
+// match reports whether value passes the filter. Values which do not are
+// counted.
+func (f *seriesFilter) match(value string) bool {
+	if f.filter.Match(value) {
+		return true
+	}
+	atomic.AddUint64(f.dropped, 1)
+	return false
+}
+
+// droppedTotal returns the number of series the filter dropped so far.
+func (f *seriesFilter) droppedTotal() float64 {
+	return float64(atomic.LoadUint64(f.dropped))
+}
+
+// parseSeriesFilters parses --collector.filter rules, grouped by collector.
+func parseSeriesFilters(rules []string) (map[string][]*seriesFilter, error) {
+	filters := make(map[string][]*seriesFilter)
+	for _, rule := range rules {
+		sf, err := config.ParseSeriesFilter(rule)
+		if err != nil {
+			return nil, err
+		}
+		var f *collector.Filter
+		if sf.Exclude {
+			f, err = collector.NewExcludeFilter(sf.Pattern)
+		} else {
+			f, err = collector.NewIncludeFilter(sf.Pattern)
+		}
+		if err != nil {
+			return nil, err
+		}
+		filters[sf.Collector] = append(filters[sf.Collector], &seriesFilter{SeriesFilter: sf, filter: f, dropped: new(uint64)})
+	}
+	return filters, nil
+}
+
+// forkSeriesFilters returns filters sharing the compiled rules of filters,
+// but counting the series they drop on their own. The filters of prev, those
+// in use if any, pass their counts on to the filters of the same collector
+// and rule, so the counts carry on across reloads.
+func forkSeriesFilters(filters, prev map[string][]*seriesFilter) map[string][]*seriesFilter {
+	counts := make(map[config.SeriesFilter][]*uint64)
+	for _, fs := range prev {
+		for _, f := range fs {
+			counts[f.SeriesFilter] = append(counts[f.SeriesFilter], f.dropped)
+		}
+	}
+	forked := make(map[string][]*seriesFilter, len(filters))
+	for name, fs := range filters {
+		for _, f := range fs {
+			dropped := new(uint64)
+			// A rule given twice keeps both counts.
+			if c := counts[f.SeriesFilter]; len(c) > 0 {
+				dropped, counts[f.SeriesFilter] = c[0], c[1:]
+			}
+			forked[name] = append(forked[name], &seriesFilter{SeriesFilter: f.SeriesFilter, filter: f.filter, dropped: dropped})
+		}
+	}
+	return forked
+}
+
+// keepSeries reports whether m passes all filters. Filters on a label m does
+// not have do not apply to it.
+func keepSeries(filters []*seriesFilter, m prometheus.Metric) bool {
+	if len(filters) == 0 || m.Desc() == scrapeDurationDesc {
+		return true
+	}
+	var pb dto.Metric
+	if err := m.Write(&pb); err != nil {
+		// Let invalid metrics through, so the error is reported.
+		log.Debugf("Failed to read metric for filtering: %v", err)
+		return true
+	}
+	for _, f := range filters {
+		for _, l := range pb.GetLabel() {
+			if l.GetName() == f.Label && !f.match(l.GetValue()) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// collectorMetaDescs are the descriptors of the metrics the exporter exposes
+// about each collector, rather than produced by the collector.
+var collectorMetaDescs = map[*prometheus.Desc]bool{
+	scrapeDurationDesc: true,
+	scrapeSuccessDesc:  true,
+	scrapeTimeoutDesc:  true,
+	scrapeSkippedDesc:  true,
+	cacheHitsDesc:      true,
+	cacheMissesDesc:    true,
+	cacheStaleDesc:     true,
+	cacheAgeDesc:       true,
+	filteredSeriesDesc: true,
+	relabelDroppedDesc: true,
+	seriesDroppedDesc:  true,
+	seriesLimitHitDesc: true,
+}
+
+// relabelSink returns a sink applying the relabeling rules of r to the metrics
+// of collectors before passing them to sink. The metrics of the exporter about
+// each collector only go through the global rules, and exporter-wide metrics
+// are passed through unchanged.
+func relabelSink(r *relabel.Relabeler, sink metricSink) metricSink {
+	return func(name string, m prometheus.Metric) {
+		if name == "" {
+			sink(name, m)
+			return
+		}
+		if m, keep := relabelMetric(r, name, m); keep {
+			sink(name, m)
+		}
+	}
+}
+
+// relabelMetric applies the relabeling rules of r to a metric of the named
+// collector. The metrics of the exporter about the collector only go through
+// the global rules. It returns false if the metric is dropped.
+func relabelMetric(r *relabel.Relabeler, name string, m prometheus.Metric) (prometheus.Metric, bool) {
+	if collectorMetaDescs[m.Desc()] {
+		return r.ApplyGlobal(m)
+	}
+	return r.Apply(name, m)
+}
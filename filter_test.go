@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus-community/windows_exporter/collector"
+	"github.com/prometheus-community/windows_exporter/relabel"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var testVolumeDesc = prometheus.NewDesc("test_volume_free_bytes", "Free space of a volume.", []string{"volume"}, nil)
+
+// volumeCollector emits one series per volume.
+type volumeCollector []string
+
+func (c volumeCollector) Collect(ctx *collector.ScrapeContext, ch chan<- prometheus.Metric) error {
+	for _, volume := range c {
+		ch <- prometheus.MustNewConstMetric(testVolumeDesc, prometheus.GaugeValue, 1, volume)
+	}
+	ch <- prometheus.MustNewConstMetric(testValueDesc, prometheus.GaugeValue, 1)
+	return nil
+}
+
+func TestCollectorFilter(t *testing.T) {
+	defaultSource := collector.DefaultPerflibSource
+	collector.DefaultPerflibSource = collector.NewFixturePerflibSource()
+	defer func() { collector.DefaultPerflibSource = defaultSource }()
+
+	filters, err := parseSeriesFilters([]string{"volumes:volume=~[A-Z]:", "volumes:volume!~D:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	coll := windowsCollector{
+		maxScrapeDuration: time.Minute,
+		collectors:        map[string]collector.Collector{"volumes": volumeCollector{"C:", "D:", "HarddiskVolume1"}},
+		runs:              newCollectorRuns(),
+		cache:             newCollectorCache(nil),
+		filters:           filters,
+	}
+
+	for scrape := 1; scrape <= 2; scrape++ {
+		var volumes []string
+		filtered := map[string]float64{}
+		unlabelled := 0
+		coll.collect(func(_ string, m prometheus.Metric) {
+			pb := &dto.Metric{}
+			if err := m.Write(pb); err != nil {
+				t.Fatal(err)
+			}
+			switch m.Desc() {
+			case testVolumeDesc:
+				volumes = append(volumes, pb.GetLabel()[0].GetValue())
+			case testValueDesc:
+				unlabelled++
+			case filteredSeriesDesc:
+				filtered[pb.GetLabel()[1].GetValue()] = pb.GetCounter().GetValue()
+			}
+		})
+
+		if len(volumes) != 1 || volumes[0] != "C:" {
+			t.Errorf("expected only volume C: to be kept, got %v", volumes)
+		}
+		if unlabelled != 1 {
+			t.Errorf("expected the series without the label to be kept, got %d", unlabelled)
+		}
+		want := map[string]float64{"volume=~[A-Z]:": float64(scrape), "volume!~D:": float64(scrape)}
+		for rule, n := range want {
+			if filtered[rule] != n {
+				t.Errorf("expected %v series dropped by %s after scrape %d, got %v", n, rule, scrape, filtered)
+			}
+		}
+	}
+
+	for _, rule := range []string{"volumes", "volumes:volume", "volumes:volume=(", "volumes:1volume=~a"} {
+		if _, err := parseSeriesFilters([]string{rule}); err == nil {
+			t.Errorf("expected an error for %q", rule)
+		}
+	}
+}
+
+func TestForkSeriesFilters(t *testing.T) {
+	filters, err := parseSeriesFilters([]string{"os:product!~foo", "os:product!~foo", "cpu:core=~0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := forkSeriesFilters(filters, nil)
+	prev["os"][0].match("foo")
+	prev["os"][1].match("foo")
+	prev["os"][1].match("foo")
+	prev["cpu"][0].match("1")
+
+	reloaded, err := parseSeriesFilters([]string{"os:product!~foo", "os:product!~foo", "cpu:core=~1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forked := forkSeriesFilters(reloaded, prev)
+	if forked["os"][0].filter != reloaded["os"][0].filter {
+		t.Error("expected the forked filter to share the compiled rule")
+	}
+	if got := []float64{forked["os"][0].droppedTotal(), forked["os"][1].droppedTotal()}; got[0] != 1 || got[1] != 2 {
+		t.Errorf("expected the counts of the rules to carry on, got %v", got)
+	}
+	if got := forked["cpu"][0].droppedTotal(); got != 0 {
+		t.Errorf("expected a new rule to start from zero, got %v", got)
+	}
+	forked["os"][0].match("foo")
+	if got := forkSeriesFilters(reloaded, nil)["os"][0].droppedTotal(); got != 0 {
+		t.Errorf("expected filters forked without previous ones to start from zero, got %v", got)
+	}
+}
+
+// metricsCollector exposes fixed metrics.
+type metricsCollector []prometheus.Metric
+
+func (c metricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c metricsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c {
+		ch <- m
+	}
+}
+
+func TestCollectorRelabel(t *testing.T) {
+	defaultSource := collector.DefaultPerflibSource
+	collector.DefaultPerflibSource = collector.NewFixturePerflibSource()
+	defer func() { collector.DefaultPerflibSource = defaultSource }()
+
+	c, err := relabel.Parse([]byte(`
+collectors:
+  volumes:
+    - source_labels: [volume]
+      regex: Harddisk.*
+      action: drop
+    - source_labels: [volume]
+      regex: "(.):"
+      target_label: drive
+    - regex: volume
+      action: labeldrop
+    - source_labels: [__name__]
+      regex: windows_exporter_.*
+      action: drop
+global:
+  - source_labels: [__name__]
+    regex: test_value|windows_exporter_collector_timeout
+    action: drop
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	coll := windowsCollector{
+		maxScrapeDuration: time.Minute,
+		collectors:        map[string]collector.Collector{"volumes": volumeCollector{"C:", "D:", "HarddiskVolume1"}},
+		runs:              newCollectorRuns(),
+		cache:             newCollectorCache(nil),
+		relabeler:         relabel.New(c),
+	}
+
+	for scrape := 1; scrape <= 2; scrape++ {
+		var metrics metricsCollector
+		coll.collect(func(_ string, m prometheus.Metric) {
+			metrics = append(metrics, m)
+		})
+		expected := fmt.Sprintf(`# HELP test_volume_free_bytes Free space of a volume.
+# TYPE test_volume_free_bytes gauge
+test_volume_free_bytes{drive="C"} 1
+test_volume_free_bytes{drive="D"} 1
+# HELP windows_exporter_relabel_dropped_samples_total windows_exporter: Number of samples of the collector dropped by relabeling rules.
+# TYPE windows_exporter_relabel_dropped_samples_total counter
+windows_exporter_relabel_dropped_samples_total{collector="volumes"} %d
+# HELP windows_exporter_collector_success windows_exporter: Whether the collector was successful.
+# TYPE windows_exporter_collector_success gauge
+windows_exporter_collector_success{collector="volumes"} 1
+`, 2*scrape)
+		// The metrics of the exporter about the collector only go through the
+		// global rules, and are not counted as dropped.
+		err := testutil.CollectAndCompare(metrics, strings.NewReader(expected),
+			"test_volume_free_bytes", "test_value", "windows_exporter_relabel_dropped_samples_total",
+			"windows_exporter_collector_success", "windows_exporter_collector_timeout")
+		if err != nil {
+			t.Errorf("scrape %d: %v", scrape, err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Package format renders gathered metric families in formats other than the
+// Prometheus exposition formats, for consumers which cannot read those.
+package format
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Names of the formats, as given in the format parameter of the metrics
+// endpoint.
+const (
+	Influx = "influx"
+	JSON   = "json"
+)
+
+// Supported reports whether format names a format of this package.
+func Supported(format string) bool {
+	return format == Influx || format == JSON
+}
+
+// ContentType returns the content type of a format.
+func ContentType(format string) string {
+	if format == JSON {
+		return "application/json"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// Write renders the metric families in the named format. Metrics without a
+// timestamp are stamped with ts, in formats which require one.
+func Write(w io.Writer, format string, mfs []*dto.MetricFamily, ts time.Time) error {
+	switch format {
+	case Influx:
+		return WriteInflux(w, mfs, ts)
+	case JSON:
+		return WriteJSON(w, mfs)
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, +1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
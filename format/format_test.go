@@ -0,0 +1,76 @@
+package format
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+var updateGolden = flag.Bool("update", false, "Regenerate the golden files in testdata/golden instead of comparing against them.")
+
+// readFamilies parses the metric families of testdata/metrics.prom, in the
+// order of a registry.
+func readFamilies(t *testing.T) []*dto.MetricFamily {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", "metrics.prom"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfs := make([]*dto.MetricFamily, 0, len(families))
+	for _, mf := range families {
+		mfs = append(mfs, mf)
+	}
+	sort.Slice(mfs, func(i, j int) bool { return mfs[i].GetName() < mfs[j].GetName() })
+	return mfs
+}
+
+// TestGolden renders testdata/metrics.prom in every format, and compares the
+// output against testdata/golden. Run with -update to regenerate the golden
+// files after an intended change in output.
+func TestGolden(t *testing.T) {
+	for _, format := range []string{Influx, JSON} {
+		format := format
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, format, readFamilies(t), time.Unix(1700000000, 0)); err != nil {
+				t.Fatal(err)
+			}
+			got := buf.Bytes()
+			golden := filepath.Join("testdata", "golden", "metrics."+format)
+			if *updateGolden {
+				if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("no golden file, run with -update to create it: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s output does not match %s, run with -update if the change is intended.\nExpected:\n%s\nGot:\n%s", format, golden, want, got)
+			}
+		})
+	}
+}
+
+func TestWriteUnknownFormat(t *testing.T) {
+	if err := Write(ioutil.Discard, "xml", nil, time.Now()); err == nil {
+		t.Error("expected an unknown format to be refused")
+	}
+}
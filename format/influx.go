@@ -0,0 +1,100 @@
+package format
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// influxEscaper escapes measurement names, tag keys, tag values and field
+// keys of the line protocol.
+var influxEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+
+// WriteInflux renders the metric families in the InfluxDB line protocol, as
+// the Prometheus input of Telegraf does. Every metric is a point of the
+// measurement named after its family, tagged with its labels. The field of a
+// counter is named "counter", of a gauge "gauge", and of an untyped metric
+// "value". Summaries and histograms have "count" and "sum" fields, and a
+// field per quantile or bucket named after its quantile or upper bound.
+// Fields whose value is NaN or infinite are dropped, as InfluxDB cannot store
+// them, and so are points left without fields.
+func WriteInflux(w io.Writer, mfs []*dto.MetricFamily, ts time.Time) error {
+	bw := bufio.NewWriter(w)
+	defaultTimestamp := ts.UnixNano()
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			var fields []influxField
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				fields = append(fields, influxField{"counter", m.GetCounter().GetValue()})
+			case dto.MetricType_GAUGE:
+				fields = append(fields, influxField{"gauge", m.GetGauge().GetValue()})
+			case dto.MetricType_UNTYPED:
+				fields = append(fields, influxField{"value", m.GetUntyped().GetValue()})
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				fields = append(fields, influxField{"count", float64(s.GetSampleCount())}, influxField{"sum", s.GetSampleSum()})
+				for _, q := range s.GetQuantile() {
+					fields = append(fields, influxField{formatFloat(q.GetQuantile()), q.GetValue()})
+				}
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				fields = append(fields, influxField{"count", float64(h.GetSampleCount())}, influxField{"sum", h.GetSampleSum()})
+				for _, b := range h.GetBucket() {
+					fields = append(fields, influxField{formatFloat(b.GetUpperBound()), float64(b.GetCumulativeCount())})
+				}
+			}
+
+			timestamp := defaultTimestamp
+			if m.TimestampMs != nil {
+				timestamp = m.GetTimestampMs() * int64(time.Millisecond)
+			}
+			writeInfluxPoint(bw, mf.GetName(), m.GetLabel(), fields, timestamp)
+		}
+	}
+	return bw.Flush()
+}
+
+type influxField struct {
+	key   string
+	value float64
+}
+
+func writeInfluxPoint(w *bufio.Writer, measurement string, labels []*dto.LabelPair, fields []influxField, timestamp int64) {
+	var written int
+	for _, f := range fields {
+		if math.IsNaN(f.value) || math.IsInf(f.value, 0) {
+			continue
+		}
+		if written == 0 {
+			w.WriteString(influxEscaper.Replace(measurement))
+			for _, l := range labels {
+				// Empty tag values are not allowed.
+				if l.GetValue() == "" {
+					continue
+				}
+				w.WriteByte(',')
+				w.WriteString(influxEscaper.Replace(l.GetName()))
+				w.WriteByte('=')
+				w.WriteString(influxEscaper.Replace(l.GetValue()))
+			}
+			w.WriteByte(' ')
+		} else {
+			w.WriteByte(',')
+		}
+		w.WriteString(influxEscaper.Replace(f.key))
+		w.WriteByte('=')
+		w.WriteString(strconv.FormatFloat(f.value, 'g', -1, 64))
+		written++
+	}
+	if written > 0 {
+		w.WriteByte(' ')
+		w.WriteString(strconv.FormatInt(timestamp, 10))
+		w.WriteByte('\n')
+	}
+}
@@ -0,0 +1,119 @@
+package format
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// jsonFamily is a metric family, in the layout of prom2json.
+type jsonFamily struct {
+	Name    string        `json:"name"`
+	Help    string        `json:"help"`
+	Type    string        `json:"type"`
+	Metrics []interface{} `json:"metrics"`
+}
+
+// jsonMetric is a counter, gauge or untyped metric.
+type jsonMetric struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	TimestampMs string            `json:"timestamp_ms,omitempty"`
+	Value       string            `json:"value"`
+}
+
+// jsonSummary is a summary.
+type jsonSummary struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	TimestampMs string            `json:"timestamp_ms,omitempty"`
+	Quantiles   map[string]string `json:"quantiles,omitempty"`
+	Count       string            `json:"count"`
+	Sum         string            `json:"sum"`
+}
+
+// jsonHistogram is a histogram.
+type jsonHistogram struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	TimestampMs string            `json:"timestamp_ms,omitempty"`
+	Buckets     map[string]string `json:"buckets,omitempty"`
+	Count       string            `json:"count"`
+	Sum         string            `json:"sum"`
+}
+
+// WriteJSON renders the metric families as a JSON array, in the layout of
+// prom2json. The type of a family is COUNTER, GAUGE, UNTYPED, SUMMARY or
+// HISTOGRAM, and the labels of every metric are an object. Values are
+// strings, so NaN and infinite values can be represented.
+func WriteJSON(w io.Writer, mfs []*dto.MetricFamily) error {
+	families := make([]jsonFamily, 0, len(mfs))
+	for _, mf := range mfs {
+		family := jsonFamily{
+			Name:    mf.GetName(),
+			Help:    mf.GetHelp(),
+			Type:    mf.GetType().String(),
+			Metrics: make([]interface{}, 0, len(mf.GetMetric())),
+		}
+		for _, m := range mf.GetMetric() {
+			var labels map[string]string
+			if len(m.GetLabel()) > 0 {
+				labels = make(map[string]string, len(m.GetLabel()))
+				for _, l := range m.GetLabel() {
+					labels[l.GetName()] = l.GetValue()
+				}
+			}
+			var timestamp string
+			if m.TimestampMs != nil {
+				timestamp = strconv.FormatInt(m.GetTimestampMs(), 10)
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				quantiles := make(map[string]string, len(s.GetQuantile()))
+				for _, q := range s.GetQuantile() {
+					quantiles[formatFloat(q.GetQuantile())] = formatFloat(q.GetValue())
+				}
+				family.Metrics = append(family.Metrics, jsonSummary{
+					Labels:      labels,
+					TimestampMs: timestamp,
+					Quantiles:   quantiles,
+					Count:       strconv.FormatUint(s.GetSampleCount(), 10),
+					Sum:         formatFloat(s.GetSampleSum()),
+				})
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				buckets := make(map[string]string, len(h.GetBucket()))
+				for _, b := range h.GetBucket() {
+					buckets[formatFloat(b.GetUpperBound())] = strconv.FormatUint(b.GetCumulativeCount(), 10)
+				}
+				family.Metrics = append(family.Metrics, jsonHistogram{
+					Labels:      labels,
+					TimestampMs: timestamp,
+					Buckets:     buckets,
+					Count:       strconv.FormatUint(h.GetSampleCount(), 10),
+					Sum:         formatFloat(h.GetSampleSum()),
+				})
+			default:
+				var value float64
+				switch mf.GetType() {
+				case dto.MetricType_COUNTER:
+					value = m.GetCounter().GetValue()
+				case dto.MetricType_GAUGE:
+					value = m.GetGauge().GetValue()
+				default:
+					value = m.GetUntyped().GetValue()
+				}
+				family.Metrics = append(family.Metrics, jsonMetric{
+					Labels:      labels,
+					TimestampMs: timestamp,
+					Value:       formatFloat(value),
+				})
+			}
+		}
+		families = append(families, family)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(families)
+}
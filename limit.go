@@ -0,0 +1,206 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus-community/windows_exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+)
+
+var (
+	seriesDroppedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "series_dropped_total"),
+		"windows_exporter: Number of series of the collector dropped because it exceeded its series limit, or the scrape exceeded the total limit.",
+		[]string{"collector"},
+		nil,
+	)
+	seriesLimitHitDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "series_limit_hit"),
+		"windows_exporter: Whether the last run of the collector exceeded its series limit, or lost series to the total limit.",
+		[]string{"collector"},
+		nil,
+	)
+)
+
+// seriesLimiter caps the number of series of collectors, and of all
+// collectors of a scrape, shared between scrapes.
+type seriesLimiter struct {
+	limits map[string]int
+	// defaultLimit applies to collectors without a limit of their own. 0 to
+	// disable.
+	defaultLimit int
+	// totalLimit applies to the series of all collectors of a scrape, after
+	// their own limits. 0 to disable.
+	totalLimit int
+
+	mtx     sync.Mutex
+	dropped map[string]float64
+	hit     map[string]bool
+}
+
+func newSeriesLimiter(limits map[string]int, defaultLimit, totalLimit int) *seriesLimiter {
+	return &seriesLimiter{
+		limits:       limits,
+		defaultLimit: defaultLimit,
+		totalLimit:   totalLimit,
+		dropped:      make(map[string]float64),
+		hit:          make(map[string]bool),
+	}
+}
+
+// limit returns the maximum number of series of the named collector, or 0 if
+// it is unlimited.
+func (l *seriesLimiter) limit(name string) int {
+	if l == nil {
+		return 0
+	}
+	if n, ok := l.limits[name]; ok {
+		return n
+	}
+	return l.defaultLimit
+}
+
+// enabled reports whether the series of the named collector are limited.
+func (l *seriesLimiter) enabled(name string) bool {
+	return l.limit(name) > 0
+}
+
+// limitsTotal reports whether the series of all collectors of a scrape are
+// limited.
+func (l *seriesLimiter) limitsTotal() bool {
+	return l != nil && l.totalLimit > 0
+}
+
+// counted reports whether the series dropped from the named collector are
+// counted.
+func (l *seriesLimiter) counted(name string) bool {
+	return l.enabled(name) || l.limitsTotal()
+}
+
+// apply returns the metrics of a run of the named collector within its limit.
+// If the limit is exceeded, the series sorting first by name and labels are
+// kept, so the same series are dropped on every run.
+func (l *seriesLimiter) apply(name string, metrics []prometheus.Metric) []prometheus.Metric {
+	limit := l.limit(name)
+	var kept, series []prometheus.Metric
+	for _, m := range metrics {
+		if m.Desc() == scrapeDurationDesc {
+			kept = append(kept, m)
+		} else {
+			series = append(series, m)
+		}
+	}
+	dropped := len(series) - limit
+	l.mtx.Lock()
+	l.hit[name] = dropped > 0
+	if dropped > 0 {
+		l.dropped[name] += float64(dropped)
+	}
+	l.mtx.Unlock()
+	if dropped <= 0 {
+		return metrics
+	}
+
+	log.Warnf("collector %s exceeded its limit of %d series, dropping %d", name, limit, dropped)
+	type keyed struct {
+		key string
+		m   prometheus.Metric
+	}
+	sorted := make([]keyed, 0, len(series))
+	for _, m := range series {
+		sorted = append(sorted, keyed{key: seriesKey(m), m: m})
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].key < sorted[j].key
+	})
+	for _, s := range sorted[:limit] {
+		kept = append(kept, s.m)
+	}
+	return kept
+}
+
+// applyTotal caps the series of all collectors of a scrape, keyed by
+// collector, at the total limit. If it is exceeded, the series sorting first
+// by name and labels across all collectors are kept, so the same series are
+// dropped on every scrape. Dropped series are accounted to their collectors.
+func (l *seriesLimiter) applyTotal(metrics map[string][]prometheus.Metric) {
+	type keyed struct {
+		key   string
+		name  string
+		index int
+	}
+	var series []keyed
+	for name, ms := range metrics {
+		for i, m := range ms {
+			if m.Desc() != scrapeDurationDesc {
+				series = append(series, keyed{key: seriesKey(m), name: name, index: i})
+			}
+		}
+	}
+
+	dropped := make(map[string]map[int]bool)
+	if excess := len(series) - l.totalLimit; excess > 0 {
+		log.Warnf("scrape exceeded the total limit of %d series, dropping %d", l.totalLimit, excess)
+		sort.SliceStable(series, func(i, j int) bool {
+			if series[i].key != series[j].key {
+				return series[i].key < series[j].key
+			}
+			return series[i].name < series[j].name
+		})
+		for _, s := range series[l.totalLimit:] {
+			if dropped[s.name] == nil {
+				dropped[s.name] = make(map[int]bool)
+			}
+			dropped[s.name][s.index] = true
+		}
+		for name, indexes := range dropped {
+			kept := make([]prometheus.Metric, 0, len(metrics[name])-len(indexes))
+			for i, m := range metrics[name] {
+				if !indexes[i] {
+					kept = append(kept, m)
+				}
+			}
+			metrics[name] = kept
+		}
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	for name := range metrics {
+		if n := len(dropped[name]); n > 0 {
+			l.hit[name] = true
+			l.dropped[name] += float64(n)
+		} else if !l.enabled(name) {
+			l.hit[name] = false
+		}
+	}
+}
+
+// counters returns the number of series of the named collector dropped so
+// far, and whether its last run exceeded the limit.
+func (l *seriesLimiter) counters(name string) (float64, bool) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.dropped[name], l.hit[name]
+}
+
+// seriesKey identifies a series by its descriptor and label values.
+func seriesKey(m prometheus.Metric) string {
+	var b strings.Builder
+	b.WriteString(m.Desc().String())
+	var pb dto.Metric
+	if err := m.Write(&pb); err != nil {
+		return b.String()
+	}
+	for _, l := range pb.GetLabel() {
+		b.WriteString("\xff")
+		b.WriteString(l.GetName())
+		b.WriteString("\xff")
+		b.WriteString(l.GetValue())
+	}
+	return b.String()
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus-community/windows_exporter/collector"
+	"github.com/prometheus-community/windows_exporter/relabel"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestSeriesLimit(t *testing.T) {
+	defaultSource := collector.DefaultPerflibSource
+	collector.DefaultPerflibSource = collector.NewFixturePerflibSource()
+	defer func() { collector.DefaultPerflibSource = defaultSource }()
+
+	volumes := volumeCollector{"E:", "C:", "HarddiskVolume1", "D:"}
+	coll := windowsCollector{
+		maxScrapeDuration: time.Minute,
+		collectors: map[string]collector.Collector{
+			"volumes":   volumes,
+			"unlimited": volumes,
+		},
+		runs:    newCollectorRuns(),
+		cache:   newCollectorCache(nil),
+		limiter: newSeriesLimiter(map[string]int{"unlimited": 0}, 3, 0),
+	}
+
+	for scrape := 1; scrape <= 2; scrape++ {
+		kept := map[string][]string{}
+		dropped := map[string]float64{}
+		hit := map[string]float64{}
+		durations := 0
+		coll.collect(func(name string, m prometheus.Metric) {
+			pb := &dto.Metric{}
+			if err := m.Write(pb); err != nil {
+				t.Fatal(err)
+			}
+			switch m.Desc() {
+			case testVolumeDesc:
+				kept[name] = append(kept[name], pb.GetLabel()[0].GetValue())
+			case testValueDesc:
+				kept[name] = append(kept[name], "")
+			case scrapeDurationDesc:
+				durations++
+			case seriesDroppedDesc:
+				dropped[name] = pb.GetCounter().GetValue()
+			case seriesLimitHitDesc:
+				hit[name] = pb.GetGauge().GetValue()
+			}
+		})
+
+		// test_value sorts before test_volume_free_bytes, so the last two
+		// volumes are dropped.
+		want := []string{"", "C:", "D:"}
+		got := kept["volumes"]
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("scrape %d: expected volumes %v to be kept, got %v", scrape, want, got)
+		}
+		if len(kept["unlimited"]) != 5 {
+			t.Errorf("scrape %d: expected all series of the unlimited collector, got %v", scrape, kept["unlimited"])
+		}
+		if durations != 2 {
+			t.Errorf("scrape %d: expected the durations of both collectors, got %d", scrape, durations)
+		}
+		if dropped["volumes"] != float64(2*scrape) || hit["volumes"] != 1 {
+			t.Errorf("scrape %d: expected 2 series dropped per scrape and the limit hit, got %v and %v", scrape, dropped, hit)
+		}
+		if _, ok := dropped["unlimited"]; ok {
+			t.Errorf("scrape %d: expected no limit metrics for the unlimited collector", scrape)
+		}
+	}
+
+	coll.limiter = newSeriesLimiter(nil, 10, 0)
+	hit := -1.0
+	coll.collect(func(name string, m prometheus.Metric) {
+		if name == "volumes" && m.Desc() == seriesLimitHitDesc {
+			pb := &dto.Metric{}
+			if err := m.Write(pb); err != nil {
+				t.Fatal(err)
+			}
+			hit = pb.GetGauge().GetValue()
+		}
+	})
+	if hit != 0 {
+		t.Errorf("expected the limit not to be hit, got %v", hit)
+	}
+}
+
+func TestSeriesLimitAfterRelabeling(t *testing.T) {
+	defaultSource := collector.DefaultPerflibSource
+	collector.DefaultPerflibSource = collector.NewFixturePerflibSource()
+	defer func() { collector.DefaultPerflibSource = defaultSource }()
+
+	c, err := relabel.Parse([]byte(`
+collectors:
+  volumes:
+    - source_labels: [volume]
+      regex: Harddisk.*
+      action: drop
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	coll := windowsCollector{
+		maxScrapeDuration: time.Minute,
+		collectors:        map[string]collector.Collector{"volumes": volumeCollector{"E:", "C:", "HarddiskVolume1", "D:"}},
+		runs:              newCollectorRuns(),
+		cache:             newCollectorCache(nil),
+		relabeler:         relabel.New(c),
+		limiter:           newSeriesLimiter(nil, 3, 0),
+	}
+
+	var metrics metricsCollector
+	coll.collect(func(_ string, m prometheus.Metric) {
+		metrics = append(metrics, m)
+	})
+	// The series dropped by relabeling do not count towards the limit.
+	expected := `# HELP test_volume_free_bytes Free space of a volume.
+# TYPE test_volume_free_bytes gauge
+test_volume_free_bytes{volume="C:"} 1
+test_volume_free_bytes{volume="D:"} 1
+# HELP windows_exporter_series_dropped_total windows_exporter: Number of series of the collector dropped because it exceeded its series limit, or the scrape exceeded the total limit.
+# TYPE windows_exporter_series_dropped_total counter
+windows_exporter_series_dropped_total{collector="volumes"} 1
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected), "test_volume_free_bytes", "windows_exporter_series_dropped_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTotalSeriesLimit(t *testing.T) {
+	defaultSource := collector.DefaultPerflibSource
+	collector.DefaultPerflibSource = collector.NewFixturePerflibSource()
+	defer func() { collector.DefaultPerflibSource = defaultSource }()
+
+	volumes := volumeCollector{"E:", "C:"}
+	coll := windowsCollector{
+		maxScrapeDuration: time.Minute,
+		collectors: map[string]collector.Collector{
+			"a": volumes,
+			"b": volumes,
+		},
+		runs:    newCollectorRuns(),
+		cache:   newCollectorCache(map[string]time.Duration{"b": time.Minute}),
+		limiter: newSeriesLimiter(nil, 0, 4),
+	}
+
+	for scrape := 1; scrape <= 2; scrape++ {
+		kept := map[string][]string{}
+		dropped := map[string]float64{}
+		hit := map[string]float64{}
+		coll.collect(func(name string, m prometheus.Metric) {
+			pb := &dto.Metric{}
+			if err := m.Write(pb); err != nil {
+				t.Fatal(err)
+			}
+			switch m.Desc() {
+			case testVolumeDesc:
+				kept[name] = append(kept[name], pb.GetLabel()[0].GetValue())
+			case testValueDesc:
+				kept[name] = append(kept[name], "")
+			case seriesDroppedDesc:
+				dropped[name] = pb.GetCounter().GetValue()
+			case seriesLimitHitDesc:
+				hit[name] = pb.GetGauge().GetValue()
+			}
+		})
+
+		// The series of both collectors sort together, and the cached series
+		// of b count as well.
+		for _, name := range []string{"a", "b"} {
+			got := kept[name]
+			sort.Strings(got)
+			if want := []string{"", "C:"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("scrape %d: expected %v of collector %s to be kept, got %v", scrape, want, name, got)
+			}
+			if dropped[name] != float64(scrape) || hit[name] != 1 {
+				t.Errorf("scrape %d: expected a series of collector %s dropped per scrape, got %v and %v", scrape, name, dropped, hit)
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus-community/windows_exporter/otlp"
+	"github.com/prometheus-community/windows_exporter/relabel"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// otlpPusher gathers the enabled collectors on an interval, and exports the
+// metrics to an OTLP endpoint.
+type otlpPusher struct {
+	interval time.Duration
+	// factory returns the collector of a single export, like the
+	// collectorFactory of metricsHandler.
+	factory  func(timeout time.Duration) (error, prometheus.Collector)
+	exporter *otlp.Exporter
+	// Collectors of the exporter itself, exported along with the exporter.
+	extraCollectors []prometheus.Collector
+	// Labels added to every metric.
+	constLabels *relabel.ConstLabels
+	// start is the start time of cumulative metrics.
+	start time.Time
+}
+
+// run exports immediately, then on every interval until ctx is done.
+func (p *otlpPusher) run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		if err := p.export(ctx); err != nil {
+			log.Errorf("otlp: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *otlpPusher) export(ctx context.Context) error {
+	err, c := p.factory(p.interval)
+	if err != nil {
+		return err
+	}
+	collectors := append([]prometheus.Collector{c, p.exporter}, p.extraCollectors...)
+	reg := newRegistry(nil, p.constLabels, collectors...)
+	t := time.Now()
+	mfs, err := reg.Gather()
+	if err != nil {
+		// The families gathered without error are still exported, unlike on
+		// the metrics endpoint, which answers with an error.
+		log.Warnf("otlp: error gathering metrics: %v", err)
+	}
+	log.Debugf("otlp: exporting %d metric families", len(mfs))
+	return p.exporter.Export(ctx, mfs, p.start, t)
+}
@@ -0,0 +1,284 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus-community/windows_exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/version"
+)
+
+// Protocols of OTLP, as named by the OTEL_EXPORTER_OTLP_PROTOCOL variable of
+// the OpenTelemetry SDKs.
+const (
+	ProtocolHTTP = "http/protobuf"
+	ProtocolGRPC = "grpc"
+)
+
+const (
+	// httpPath is the path of the metrics endpoint of OTLP/HTTP, used if the
+	// endpoint has none.
+	httpPath = "/v1/metrics"
+	// grpcPath is the path of the Export method of the metrics service.
+	grpcPath = "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+
+	maxErrMsgLen = 256
+)
+
+// TLSConfig configures the TLS connection to the endpoint.
+type TLSConfig struct {
+	// CAFile holds the certificates to verify the endpoint with, instead of
+	// the system roots.
+	CAFile string
+	// CertFile and KeyFile hold the client certificate, if any.
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+func (c TLSConfig) build() (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		b, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %v", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", c.CAFile)
+		}
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, fmt.Errorf("a client certificate requires both a cert file and a key file")
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// Client sends export requests to an OTLP endpoint.
+type Client struct {
+	url      string
+	protocol string
+	headers  http.Header
+	timeout  time.Duration
+	client   *http.Client
+}
+
+// NewClient returns a Client of the endpoint, an http or https URL. gRPC
+// requires HTTP/2, which is negotiated over TLS for https endpoints, and
+// spoken in plaintext for http endpoints.
+func NewClient(endpoint, protocol string, headers http.Header, timeout time.Duration, tlsConfig TLSConfig) (*Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q: expected an http or https URL", endpoint)
+	}
+	cfg, err := tlsConfig.build()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		protocol: protocol,
+		headers:  headers,
+		timeout:  timeout,
+	}
+	switch protocol {
+	case ProtocolHTTP:
+		if u.Path == "" || u.Path == "/" {
+			u.Path = httpPath
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = cfg
+		c.client = &http.Client{Transport: transport}
+	case ProtocolGRPC:
+		u.Path = grpcPath
+		var transport http.RoundTripper
+		if u.Scheme == "http" {
+			transport, err = newH2CTransport(timeout)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			t := http.DefaultTransport.(*http.Transport).Clone()
+			t.TLSClientConfig = cfg
+			t.ForceAttemptHTTP2 = true
+			transport = t
+		}
+		c.client = &http.Client{Transport: transport}
+	default:
+		return nil, fmt.Errorf("unknown protocol %q", protocol)
+	}
+	c.url = u.String()
+	return c, nil
+}
+
+// Send sends a single export request, as encoded by Marshal.
+func (c *Client) Send(ctx context.Context, request []byte) error {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	body := request
+	if c.protocol == ProtocolGRPC {
+		// A gRPC message is prefixed with a compression flag and its length.
+		body = make([]byte, 5+len(request))
+		binary.BigEndian.PutUint32(body[1:5], uint32(len(request)))
+		copy(body[5:], request)
+	}
+	req, err := http.NewRequest("POST", c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for name, values := range c.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("User-Agent", "windows_exporter/"+version.Version)
+	if c.protocol == ProtocolGRPC {
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("TE", "trailers")
+	} else {
+		req.Header.Set("Content-Type", "application/x-protobuf")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrMsgLen))
+		return fmt.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	// Trailers are only available once the body has been read.
+	if _, err := io.Copy(ioutil.Discard, resp.Body); err != nil {
+		return err
+	}
+	if c.protocol == ProtocolGRPC {
+		return grpcError(resp)
+	}
+	return nil
+}
+
+// grpcError returns the error of a gRPC response, given in the headers of
+// responses without a message, or in the trailers otherwise.
+func grpcError(resp *http.Response) error {
+	header := resp.Header
+	if header.Get("Grpc-Status") == "" {
+		header = resp.Trailer
+	}
+	status := header.Get("Grpc-Status")
+	switch status {
+	case "0":
+		return nil
+	case "":
+		return fmt.Errorf("server returned no gRPC status")
+	}
+	msg, err := url.PathUnescape(header.Get("Grpc-Message"))
+	if err != nil {
+		msg = header.Get("Grpc-Message")
+	}
+	if len(msg) > maxErrMsgLen {
+		msg = msg[:maxErrMsgLen]
+	}
+	return fmt.Errorf("server returned gRPC status %s: %s", status, strings.TrimSpace(msg))
+}
+
+var (
+	exportsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "otlp_exports_total"),
+		"windows_exporter: Number of export requests accepted by the OTLP endpoint.",
+		nil,
+		nil,
+	)
+	failedExportsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "otlp_failed_exports_total"),
+		"windows_exporter: Number of export requests to the OTLP endpoint which failed.",
+		nil,
+		nil,
+	)
+	lastExportDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "otlp_last_success_timestamp_seconds"),
+		"windows_exporter: Unix time of the last export request accepted by the OTLP endpoint.",
+		nil,
+		nil,
+	)
+)
+
+// Exporter exports metric families through a Client. It is also a
+// prometheus.Collector exposing its own state.
+type Exporter struct {
+	client *Client
+
+	mtx         sync.Mutex
+	exports     float64
+	failed      float64
+	lastSuccess time.Time
+}
+
+// NewExporter returns an Exporter sending through client.
+func NewExporter(client *Client) *Exporter {
+	return &Exporter{client: client}
+}
+
+// Export sends the metric families, gathered at ts, in a single request.
+// Cumulative metrics start at start.
+func (e *Exporter) Export(ctx context.Context, mfs []*dto.MetricFamily, start, ts time.Time) error {
+	err := e.client.Send(ctx, Marshal(mfs, Resource(mfs), start, ts))
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if err != nil {
+		e.failed++
+		return err
+	}
+	e.exports++
+	e.lastSuccess = time.Now()
+	return nil
+}
+
+// Describe implements prometheus.Collector.
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- exportsDesc
+	ch <- failedExportsDesc
+	ch <- lastExportDesc
+}
+
+// Collect implements prometheus.Collector.
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	e.mtx.Lock()
+	exports, failed, lastSuccess := e.exports, e.failed, e.lastSuccess
+	e.mtx.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(exportsDesc, prometheus.CounterValue, exports)
+	ch <- prometheus.MustNewConstMetric(failedExportsDesc, prometheus.CounterValue, failed)
+	if !lastSuccess.IsZero() {
+		ch <- prometheus.MustNewConstMetric(lastExportDesc, prometheus.GaugeValue, float64(lastSuccess.UnixNano())/1e9)
+	}
+}
@@ -0,0 +1,255 @@
+// Package otlp exports gathered metrics to an OpenTelemetry collector, using
+// OTLP over HTTP or gRPC. Counters become cumulative sums, gauges and untyped
+// metrics gauges, and summaries and histograms keep their type.
+package otlp
+
+import (
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/prometheus-community/windows_exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/version"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// hostnameMetric is the metric of the cs collector labelled with the
+// hostname of the machine.
+var hostnameMetric = prometheus.BuildFQName(collector.Namespace, "cs", "hostname")
+
+// Resource returns the attributes of the resource exporting mfs, which
+// describe the exporter and the host. The host name is taken from the cs
+// collector if it is among mfs, or from the operating system otherwise.
+func Resource(mfs []*dto.MetricFamily) map[string]string {
+	attributes := map[string]string{
+		"service.name":    "windows_exporter",
+		"service.version": version.Version,
+		"os.type":         "windows",
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		attributes["host.name"] = hostname
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != hostnameMetric || len(mf.GetMetric()) == 0 {
+			continue
+		}
+		for _, l := range mf.GetMetric()[0].GetLabel() {
+			if l.GetName() == "hostname" && l.GetValue() != "" {
+				attributes["host.name"] = l.GetValue()
+			}
+		}
+	}
+	return attributes
+}
+
+// Field numbers of the OTLP protobuf messages.
+const (
+	requestResourceMetrics = 1
+
+	resourceMetricsResource = 1
+	resourceMetricsScope    = 2
+	resourceAttributes      = 1
+
+	keyValueKey    = 1
+	keyValueValue  = 2
+	anyValueString = 1
+
+	scopeMetricsScope   = 1
+	scopeMetricsMetrics = 2
+	scopeName           = 1
+	scopeVersion        = 2
+
+	metricName        = 1
+	metricDescription = 2
+	metricGauge       = 5
+	metricSum         = 7
+	metricHistogram   = 9
+	metricSummary     = 11
+
+	dataPoints             = 1
+	aggregationTemporality = 2
+	sumIsMonotonic         = 3
+	temporalityCumulative  = 2
+
+	pointStartTime     = 2
+	pointTime          = 3
+	numberPointDouble  = 4
+	numberPointLabels  = 7
+	pointCount         = 4
+	pointSum           = 5
+	histogramBuckets   = 6
+	histogramBounds    = 7
+	histogramLabels    = 9
+	summaryQuantiles   = 6
+	summaryLabels      = 7
+	quantileValueAt    = 1
+	quantileValueValue = 2
+)
+
+// Marshal returns the protobuf encoding of an ExportMetricsServiceRequest
+// holding the metric families, exported by a resource with the given
+// attributes. Metrics without a timestamp are stamped with ts, and sums,
+// summaries and histograms start at start.
+func Marshal(mfs []*dto.MetricFamily, resource map[string]string, start, ts time.Time) []byte {
+	var rb []byte
+	keys := make([]string, 0, len(resource))
+	for k := range resource {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		rb = appendMessage(rb, resourceAttributes, marshalKeyValue(k, resource[k]))
+	}
+
+	var sb []byte
+	var scope []byte
+	scope = protowire.AppendTag(scope, scopeName, protowire.BytesType)
+	scope = protowire.AppendString(scope, "windows_exporter")
+	scope = protowire.AppendTag(scope, scopeVersion, protowire.BytesType)
+	scope = protowire.AppendString(scope, version.Version)
+	sb = appendMessage(sb, scopeMetricsScope, scope)
+	for _, mf := range mfs {
+		sb = appendMessage(sb, scopeMetricsMetrics, marshalMetric(mf, uint64(start.UnixNano()), uint64(ts.UnixNano())))
+	}
+
+	var b []byte
+	b = appendMessage(b, resourceMetricsResource, rb)
+	b = appendMessage(b, resourceMetricsScope, sb)
+	return appendMessage(nil, requestResourceMetrics, b)
+}
+
+func marshalMetric(mf *dto.MetricFamily, start, ts uint64) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, metricName, protowire.BytesType)
+	b = protowire.AppendString(b, mf.GetName())
+	if mf.GetHelp() != "" {
+		b = protowire.AppendTag(b, metricDescription, protowire.BytesType)
+		b = protowire.AppendString(b, mf.GetHelp())
+	}
+
+	var data []byte
+	for _, m := range mf.GetMetric() {
+		t := ts
+		if m.TimestampMs != nil {
+			t = uint64(m.GetTimestampMs()) * uint64(time.Millisecond)
+		}
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			data = appendMessage(data, dataPoints, marshalNumberPoint(m.GetLabel(), start, t, m.GetCounter().GetValue()))
+		case dto.MetricType_GAUGE:
+			data = appendMessage(data, dataPoints, marshalNumberPoint(m.GetLabel(), 0, t, m.GetGauge().GetValue()))
+		case dto.MetricType_UNTYPED:
+			data = appendMessage(data, dataPoints, marshalNumberPoint(m.GetLabel(), 0, t, m.GetUntyped().GetValue()))
+		case dto.MetricType_SUMMARY:
+			data = appendMessage(data, dataPoints, marshalSummaryPoint(m.GetLabel(), start, t, m.GetSummary()))
+		case dto.MetricType_HISTOGRAM:
+			data = appendMessage(data, dataPoints, marshalHistogramPoint(m.GetLabel(), start, t, m.GetHistogram()))
+		}
+	}
+
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		data = protowire.AppendTag(data, aggregationTemporality, protowire.VarintType)
+		data = protowire.AppendVarint(data, temporalityCumulative)
+		data = protowire.AppendTag(data, sumIsMonotonic, protowire.VarintType)
+		data = protowire.AppendVarint(data, 1)
+		b = appendMessage(b, metricSum, data)
+	case dto.MetricType_SUMMARY:
+		b = appendMessage(b, metricSummary, data)
+	case dto.MetricType_HISTOGRAM:
+		data = protowire.AppendTag(data, aggregationTemporality, protowire.VarintType)
+		data = protowire.AppendVarint(data, temporalityCumulative)
+		b = appendMessage(b, metricHistogram, data)
+	default:
+		b = appendMessage(b, metricGauge, data)
+	}
+	return b
+}
+
+// appendTimes appends the start time of a point, if any, and its time.
+func appendTimes(b []byte, start, ts uint64) []byte {
+	if start != 0 {
+		b = protowire.AppendTag(b, pointStartTime, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, start)
+	}
+	b = protowire.AppendTag(b, pointTime, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, ts)
+}
+
+func appendLabels(b []byte, num protowire.Number, labels []*dto.LabelPair) []byte {
+	for _, l := range labels {
+		b = appendMessage(b, num, marshalKeyValue(l.GetName(), l.GetValue()))
+	}
+	return b
+}
+
+func marshalNumberPoint(labels []*dto.LabelPair, start, ts uint64, value float64) []byte {
+	b := appendTimes(nil, start, ts)
+	b = protowire.AppendTag(b, numberPointDouble, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(value))
+	return appendLabels(b, numberPointLabels, labels)
+}
+
+func marshalSummaryPoint(labels []*dto.LabelPair, start, ts uint64, s *dto.Summary) []byte {
+	b := appendTimes(nil, start, ts)
+	b = protowire.AppendTag(b, pointCount, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, s.GetSampleCount())
+	b = protowire.AppendTag(b, pointSum, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(s.GetSampleSum()))
+	for _, q := range s.GetQuantile() {
+		var qb []byte
+		qb = protowire.AppendTag(qb, quantileValueAt, protowire.Fixed64Type)
+		qb = protowire.AppendFixed64(qb, math.Float64bits(q.GetQuantile()))
+		qb = protowire.AppendTag(qb, quantileValueValue, protowire.Fixed64Type)
+		qb = protowire.AppendFixed64(qb, math.Float64bits(q.GetValue()))
+		b = appendMessage(b, summaryQuantiles, qb)
+	}
+	return appendLabels(b, summaryLabels, labels)
+}
+
+// marshalHistogramPoint converts the cumulative buckets of a Prometheus
+// histogram into the explicit bounds and per-bucket counts of OTLP, whose
+// last bucket counts the samples above the highest bound.
+func marshalHistogramPoint(labels []*dto.LabelPair, start, ts uint64, h *dto.Histogram) []byte {
+	var bounds, counts []byte
+	var previous uint64
+	for _, bucket := range h.GetBucket() {
+		if math.IsInf(bucket.GetUpperBound(), +1) {
+			continue
+		}
+		bounds = protowire.AppendFixed64(bounds, math.Float64bits(bucket.GetUpperBound()))
+		counts = protowire.AppendFixed64(counts, bucket.GetCumulativeCount()-previous)
+		previous = bucket.GetCumulativeCount()
+	}
+	counts = protowire.AppendFixed64(counts, h.GetSampleCount()-previous)
+
+	b := appendTimes(nil, start, ts)
+	b = protowire.AppendTag(b, pointCount, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, h.GetSampleCount())
+	b = protowire.AppendTag(b, pointSum, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(h.GetSampleSum()))
+	b = appendMessage(b, histogramBuckets, counts)
+	if len(bounds) > 0 {
+		b = appendMessage(b, histogramBounds, bounds)
+	}
+	return appendLabels(b, histogramLabels, labels)
+}
+
+func marshalKeyValue(key, value string) []byte {
+	var v []byte
+	v = protowire.AppendTag(v, anyValueString, protowire.BytesType)
+	v = protowire.AppendString(v, value)
+	var b []byte
+	b = protowire.AppendTag(b, keyValueKey, protowire.BytesType)
+	b = protowire.AppendString(b, key)
+	return appendMessage(b, keyValueValue, v)
+}
+
+// appendMessage appends an embedded message, or a packed repeated field.
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
@@ -0,0 +1,31 @@
+package otlp
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// defaultDialTimeout bounds connecting to the endpoint when the client has no
+// timeout, as http.DefaultTransport does.
+const defaultDialTimeout = 30 * time.Second
+
+// newH2CTransport returns a transport speaking HTTP/2 in plaintext, as gRPC
+// does over http endpoints. Connecting is bounded by timeout, or by
+// defaultDialTimeout if it is 0. The http2 transport of this version of x/net
+// has no DialTLSContext, so the context of a request does not bound the dial.
+func newH2CTransport(timeout time.Duration) (http.RoundTripper, error) {
+	if timeout <= 0 {
+		timeout = defaultDialTimeout
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dialer.Dial(network, addr)
+		},
+	}, nil
+}
@@ -0,0 +1,500 @@
+package otlp
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// point is a decoded data point, of any type.
+type point struct {
+	Start, Time uint64
+	Value       float64
+	Count       uint64
+	Sum         float64
+	Buckets     []uint64
+	Bounds      []float64
+	Quantiles   map[float64]float64
+	Labels      map[string]string
+}
+
+// metric is a decoded metric.
+type metric struct {
+	Name, Description string
+	// Type is the field of the data of the metric: gauge, sum, histogram or
+	// summary.
+	Type       string
+	Monotonic  bool
+	Cumulative bool
+	Points     []point
+}
+
+// request is a decoded ExportMetricsServiceRequest.
+type request struct {
+	Resource map[string]string
+	Scope    string
+	Metrics  []metric
+}
+
+// unmarshal decodes an export request, as a receiver would.
+func unmarshal(t *testing.T, b []byte) request {
+	t.Helper()
+	var r request
+	forEachField(t, b, func(_ protowire.Number, v []byte, _ uint64) {
+		forEachField(t, v, func(num protowire.Number, v []byte, _ uint64) {
+			switch num {
+			case resourceMetricsResource:
+				r.Resource = make(map[string]string)
+				forEachField(t, v, func(_ protowire.Number, v []byte, _ uint64) {
+					key, value := unmarshalKeyValue(t, v)
+					r.Resource[key] = value
+				})
+			case resourceMetricsScope:
+				forEachField(t, v, func(num protowire.Number, v []byte, _ uint64) {
+					switch num {
+					case scopeMetricsScope:
+						forEachField(t, v, func(num protowire.Number, v []byte, _ uint64) {
+							if num == scopeName {
+								r.Scope = string(v)
+							}
+						})
+					case scopeMetricsMetrics:
+						r.Metrics = append(r.Metrics, unmarshalMetric(t, v))
+					}
+				})
+			}
+		})
+	})
+	return r
+}
+
+func unmarshalMetric(t *testing.T, b []byte) metric {
+	var m metric
+	forEachField(t, b, func(num protowire.Number, v []byte, _ uint64) {
+		switch num {
+		case metricName:
+			m.Name = string(v)
+		case metricDescription:
+			m.Description = string(v)
+		default:
+			m.Type = map[protowire.Number]string{metricGauge: "gauge", metricSum: "sum", metricHistogram: "histogram", metricSummary: "summary"}[num]
+			forEachField(t, v, func(num protowire.Number, v []byte, n uint64) {
+				switch num {
+				case dataPoints:
+					m.Points = append(m.Points, unmarshalPoint(t, m.Type, v))
+				case aggregationTemporality:
+					m.Cumulative = n == temporalityCumulative
+				case sumIsMonotonic:
+					m.Monotonic = n == 1
+				}
+			})
+		}
+	})
+	return m
+}
+
+func unmarshalPoint(t *testing.T, typ string, b []byte) point {
+	p := point{Labels: make(map[string]string)}
+	forEachField(t, b, func(num protowire.Number, v []byte, n uint64) {
+		switch {
+		case num == pointStartTime:
+			p.Start = n
+		case num == pointTime:
+			p.Time = n
+		case (typ == "gauge" || typ == "sum") && num == numberPointDouble:
+			p.Value = math.Float64frombits(n)
+		case (typ == "histogram" || typ == "summary") && num == pointCount:
+			p.Count = n
+		case (typ == "histogram" || typ == "summary") && num == pointSum:
+			p.Sum = math.Float64frombits(n)
+		case typ == "histogram" && num == histogramBuckets:
+			for ; len(v) > 0; v = v[8:] {
+				p.Buckets = append(p.Buckets, binary.LittleEndian.Uint64(v))
+			}
+		case typ == "histogram" && num == histogramBounds:
+			for ; len(v) > 0; v = v[8:] {
+				p.Bounds = append(p.Bounds, math.Float64frombits(binary.LittleEndian.Uint64(v)))
+			}
+		case typ == "summary" && num == summaryQuantiles:
+			if p.Quantiles == nil {
+				p.Quantiles = make(map[float64]float64)
+			}
+			var q, value float64
+			forEachField(t, v, func(num protowire.Number, _ []byte, n uint64) {
+				if num == quantileValueAt {
+					q = math.Float64frombits(n)
+				} else {
+					value = math.Float64frombits(n)
+				}
+			})
+			p.Quantiles[q] = value
+		case typ == "gauge" && num == numberPointLabels, typ == "sum" && num == numberPointLabels,
+			typ == "histogram" && num == histogramLabels, typ == "summary" && num == summaryLabels:
+			key, value := unmarshalKeyValue(t, v)
+			p.Labels[key] = value
+		}
+	})
+	return p
+}
+
+func unmarshalKeyValue(t *testing.T, b []byte) (key, value string) {
+	forEachField(t, b, func(num protowire.Number, v []byte, _ uint64) {
+		if num == keyValueKey {
+			key = string(v)
+			return
+		}
+		forEachField(t, v, func(_ protowire.Number, v []byte, _ uint64) {
+			value = string(v)
+		})
+	})
+	return key, value
+}
+
+func forEachField(t *testing.T, b []byte, f func(protowire.Number, []byte, uint64)) {
+	t.Helper()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			f(num, v, 0)
+			b = b[n:]
+		case protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			f(num, nil, v)
+			b = b[n:]
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			f(num, nil, v)
+			b = b[n:]
+		default:
+			t.Fatalf("unexpected wire type %v", typ)
+		}
+	}
+}
+
+func labelPairs(pairs ...string) []*dto.LabelPair {
+	var labels []*dto.LabelPair
+	for i := 0; i < len(pairs); i += 2 {
+		labels = append(labels, &dto.LabelPair{Name: proto.String(pairs[i]), Value: proto.String(pairs[i+1])})
+	}
+	return labels
+}
+
+func testFamilies() []*dto.MetricFamily {
+	return []*dto.MetricFamily{
+		{
+			Name: proto.String("windows_cs_hostname"),
+			Help: proto.String("Labeled system hostname information"),
+			Type: dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{{
+				Label: labelPairs("domain", "corp", "fqdn", "web1.corp", "hostname", "web1"),
+				Gauge: &dto.Gauge{Value: proto.Float64(1)},
+			}},
+		},
+		{
+			Name: proto.String("windows_cpu_time_total"),
+			Type: dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{
+				{Label: labelPairs("core", "0", "mode", "idle"), Counter: &dto.Counter{Value: proto.Float64(12.5)}},
+				{Label: labelPairs("core", "0", "mode", "user"), Counter: &dto.Counter{Value: proto.Float64(3)}, TimestampMs: proto.Int64(1000)},
+			},
+		},
+		{
+			Name:   proto.String("windows_textfile_untyped"),
+			Type:   dto.MetricType_UNTYPED.Enum(),
+			Metric: []*dto.Metric{{Untyped: &dto.Untyped{Value: proto.Float64(7)}}},
+		},
+		{
+			Name: proto.String("windows_exporter_collector_duration_seconds"),
+			Type: dto.MetricType_HISTOGRAM.Enum(),
+			Metric: []*dto.Metric{{Histogram: &dto.Histogram{
+				SampleCount: proto.Uint64(5),
+				SampleSum:   proto.Float64(2.5),
+				Bucket: []*dto.Bucket{
+					{UpperBound: proto.Float64(0.1), CumulativeCount: proto.Uint64(1)},
+					{UpperBound: proto.Float64(1), CumulativeCount: proto.Uint64(4)},
+					{UpperBound: proto.Float64(math.Inf(+1)), CumulativeCount: proto.Uint64(5)},
+				},
+			}}},
+		},
+		{
+			Name: proto.String("windows_exporter_scrape_seconds"),
+			Type: dto.MetricType_SUMMARY.Enum(),
+			Metric: []*dto.Metric{{Summary: &dto.Summary{
+				SampleCount: proto.Uint64(2),
+				SampleSum:   proto.Float64(3),
+				Quantile:    []*dto.Quantile{{Quantile: proto.Float64(0.5), Value: proto.Float64(1)}},
+			}}},
+		},
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	start, ts := time.Unix(100, 0), time.Unix(200, 0)
+	got := unmarshal(t, Marshal(testFamilies(), map[string]string{"host.name": "web1"}, start, ts))
+
+	noLabels := map[string]string{}
+	want := request{
+		Resource: map[string]string{"host.name": "web1"},
+		Scope:    "windows_exporter",
+		Metrics: []metric{
+			{
+				Name:        "windows_cs_hostname",
+				Description: "Labeled system hostname information",
+				Type:        "gauge",
+				Points:      []point{{Time: 200e9, Value: 1, Labels: map[string]string{"domain": "corp", "fqdn": "web1.corp", "hostname": "web1"}}},
+			},
+			{
+				Name:       "windows_cpu_time_total",
+				Type:       "sum",
+				Monotonic:  true,
+				Cumulative: true,
+				Points: []point{
+					{Start: 100e9, Time: 200e9, Value: 12.5, Labels: map[string]string{"core": "0", "mode": "idle"}},
+					{Start: 100e9, Time: 1e9, Value: 3, Labels: map[string]string{"core": "0", "mode": "user"}},
+				},
+			},
+			{
+				Name:   "windows_textfile_untyped",
+				Type:   "gauge",
+				Points: []point{{Time: 200e9, Value: 7, Labels: noLabels}},
+			},
+			{
+				Name:       "windows_exporter_collector_duration_seconds",
+				Type:       "histogram",
+				Cumulative: true,
+				Points: []point{{
+					Start: 100e9, Time: 200e9, Count: 5, Sum: 2.5,
+					Buckets: []uint64{1, 3, 1},
+					Bounds:  []float64{0.1, 1},
+					Labels:  noLabels,
+				}},
+			},
+			{
+				Name: "windows_exporter_scrape_seconds",
+				Type: "summary",
+				Points: []point{{
+					Start: 100e9, Time: 200e9, Count: 2, Sum: 3,
+					Quantiles: map[float64]float64{0.5: 1},
+					Labels:    noLabels,
+				}},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected\n%+v\ngot\n%+v", want, got)
+	}
+}
+
+func TestResource(t *testing.T) {
+	got := Resource(testFamilies())
+	if got["host.name"] != "web1" || got["service.name"] != "windows_exporter" {
+		t.Errorf("unexpected resource %v", got)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skip(err)
+	}
+	if got := Resource(nil); got["host.name"] != hostname {
+		t.Errorf("expected the host name %q without the cs collector, got %v", hostname, got)
+	}
+}
+
+func TestExporterHTTP(t *testing.T) {
+	var got request
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/metrics" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			http.Error(w, "unexpected request", http.StatusNotFound)
+			return
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		got, header = unmarshal(t, b), r.Header
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL, ProtocolHTTP, http.Header{"X-Scope-Orgid": {"tenant"}}, time.Second, TLSConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewExporter(client)
+	if err := e.Export(context.Background(), testFamilies(), time.Unix(100, 0), time.Unix(200, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Metrics) != 5 || got.Resource["host.name"] != "web1" || header.Get("X-Scope-Orgid") != "tenant" {
+		t.Errorf("unexpected request %+v with headers %v", got, header)
+	}
+
+	rejecting, err := NewClient(server.URL+"/otlp/v1/metrics", ProtocolHTTP, nil, time.Second, TLSConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewExporter(rejecting).Export(context.Background(), nil, time.Now(), time.Now()); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected the status to be returned, got %v", err)
+	}
+
+	expected := `
+# HELP windows_exporter_otlp_exports_total windows_exporter: Number of export requests accepted by the OTLP endpoint.
+# TYPE windows_exporter_otlp_exports_total counter
+windows_exporter_otlp_exports_total 1
+# HELP windows_exporter_otlp_failed_exports_total windows_exporter: Number of export requests to the OTLP endpoint which failed.
+# TYPE windows_exporter_otlp_failed_exports_total counter
+windows_exporter_otlp_failed_exports_total 0
+`
+	if err := testutil.CollectAndCompare(e, strings.NewReader(expected), "windows_exporter_otlp_exports_total", "windows_exporter_otlp_failed_exports_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExporterGRPC(t *testing.T) {
+	var got request
+	status := "0"
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.URL.Path != grpcPath || r.Header.Get("Content-Type") != "application/grpc" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil || len(b) < 5 || int(binary.BigEndian.Uint32(b[1:5])) != len(b)-5 {
+			t.Errorf("unexpected gRPC message %x: %v", b, err)
+			return
+		}
+		got = unmarshal(t, b[5:])
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		// An empty ExportMetricsServiceResponse.
+		w.Write([]byte{0, 0, 0, 0, 0})
+		w.Header().Set("Grpc-Status", status)
+		if status != "0" {
+			w.Header().Set("Grpc-Message", "unauthenticated%20tenant")
+		}
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "otlp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caFile, pemCertificate(server.Certificate()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewClient(server.URL, ProtocolGRPC, nil, time.Second, TLSConfig{CAFile: caFile, ServerName: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewExporter(client)
+	if err := e.Export(context.Background(), testFamilies(), time.Unix(100, 0), time.Unix(200, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Metrics) != 5 || got.Resource["host.name"] != "web1" {
+		t.Errorf("unexpected request %+v", got)
+	}
+
+	status = "16"
+	if err := e.Export(context.Background(), testFamilies(), time.Unix(100, 0), time.Unix(200, 0)); err == nil || err.Error() != "server returned gRPC status 16: unauthenticated tenant" {
+		t.Errorf("expected the gRPC status to be returned, got %v", err)
+	}
+
+	// The certificate of the test server is not trusted by default.
+	untrusted, err := NewClient(server.URL, ProtocolGRPC, nil, time.Second, TLSConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := untrusted.Send(context.Background(), nil); err == nil {
+		t.Error("expected an untrusted certificate to be refused")
+	}
+}
+
+func TestExporterGRPCPlaintext(t *testing.T) {
+	var got request
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.URL.Path != grpcPath {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil || len(b) < 5 {
+			t.Errorf("unexpected gRPC message %x: %v", b, err)
+			return
+		}
+		got = unmarshal(t, b[5:])
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write([]byte{0, 0, 0, 0, 0})
+		w.Header().Set("Grpc-Status", "0")
+	})
+	server := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL, ProtocolGRPC, nil, time.Second, TLSConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewExporter(client).Export(context.Background(), testFamilies(), time.Unix(100, 0), time.Unix(200, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Metrics) != 5 {
+		t.Errorf("unexpected request %+v", got)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	for _, tc := range []struct {
+		endpoint, protocol string
+		tls                TLSConfig
+	}{
+		{"collector:4317", ProtocolGRPC, TLSConfig{}},
+		{"ftp://collector", ProtocolHTTP, TLSConfig{}},
+		{"https://collector", "http/json", TLSConfig{}},
+		{"https://collector", ProtocolHTTP, TLSConfig{CertFile: "client.pem"}},
+		{"https://collector", ProtocolHTTP, TLSConfig{CAFile: "missing.pem"}},
+	} {
+		if _, err := NewClient(tc.endpoint, tc.protocol, nil, time.Second, tc.tls); err == nil {
+			t.Errorf("expected an error for %s over %s with %+v", tc.endpoint, tc.protocol, tc.tls)
+		}
+	}
+}
+
+func pemCertificate(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
@@ -0,0 +1,29 @@
+// Package perflib holds the performance objects read from the Windows
+// performance counters. On Windows, its types are those of
+// github.com/leoluk/perflib_exporter/perflib, so snapshots are used as read.
+// That package only builds on Windows, so elsewhere the types are mirrored,
+// and collectors, fixtures and recordings using them build on every platform.
+// Snapshots can only be taken on Windows.
+package perflib
+
+// Types of counters, as defined in winperf.h.
+const (
+	PERF_COUNTER_RAWCOUNT      = 0x00010000
+	PERF_COUNTER_COUNTER       = 0x10410400
+	PERF_100NSEC_TIMER         = 0x20510500
+	PERF_PRECISION_100NS_TIMER = 0x20570500
+	PERF_ELAPSED_TIME          = 0x30240500
+)
+
+// NameTable maps the names of objects and counters to their indices.
+type NameTable struct {
+	lookupIndex func(string) uint32
+}
+
+// LookupIndex returns the index of a name, or 0 if it is unknown.
+func (t *NameTable) LookupIndex(str string) uint32 {
+	if t == nil || t.lookupIndex == nil {
+		return 0
+	}
+	return t.lookupIndex(str)
+}
@@ -0,0 +1,62 @@
+// +build !windows
+
+package perflib
+
+import "errors"
+
+// QueryNameTable returns an empty name table, as there are no performance
+// counters to look up outside of Windows.
+func QueryNameTable(tableName string) *NameTable {
+	return &NameTable{}
+}
+
+// QueryPerformanceData fails, as performance counters are only available on
+// Windows.
+func QueryPerformanceData(query string) ([]*PerfObject, error) {
+	return nil, errors.New("perflib is only available on Windows")
+}
+
+// PerfObject is a performance object, such as Processor.
+type PerfObject struct {
+	Name string
+	// Same index you pass to QueryPerformanceData
+	NameIndex     uint
+	HelpText      string
+	HelpTextIndex uint
+	Instances     []*PerfInstance
+	CounterDefs   []*PerfCounterDef
+
+	Frequency int64
+}
+
+// PerfInstance is an instance of an object. Objects without instances have a
+// single PerfInstance with an empty name.
+type PerfInstance struct {
+	// *not* resolved using a name table
+	Name     string
+	Counters []*PerfCounter
+}
+
+// PerfCounterDef describes a counter of an object.
+type PerfCounterDef struct {
+	Name          string
+	NameIndex     uint
+	HelpText      string
+	HelpTextIndex uint
+
+	// CounterType is the type of the counter, as defined in winperf.h.
+	CounterType uint32
+
+	// PERF_TYPE_COUNTER (otherwise, it's a gauge)
+	IsCounter bool
+	// PERF_COUNTER_BASE (base value of a multi-value fraction)
+	IsBaseValue bool
+	// PERF_TIMER_100NS
+	IsNanosecondCounter bool
+}
+
+// PerfCounter is the value of a counter of an instance.
+type PerfCounter struct {
+	Value int64
+	Def   *PerfCounterDef
+}
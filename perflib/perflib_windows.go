@@ -0,0 +1,26 @@
+package perflib
+
+import (
+	"github.com/leoluk/perflib_exporter/perflib"
+)
+
+// The types of snapshots are those of perflib_exporter, so they are used as
+// read.
+type (
+	PerfObject     = perflib.PerfObject
+	PerfInstance   = perflib.PerfInstance
+	PerfCounterDef = perflib.PerfCounterDef
+	PerfCounter    = perflib.PerfCounter
+)
+
+// QueryNameTable reads a name table from the registry, such as "Counter 009"
+// for the English names of counters.
+func QueryNameTable(tableName string) *NameTable {
+	return &NameTable{lookupIndex: perflib.QueryNameTable(tableName).LookupIndex}
+}
+
+// QueryPerformanceData returns the objects selected by query, a
+// space-separated list of object indices.
+func QueryPerformanceData(query string) ([]*PerfObject, error) {
+	return perflib.QueryPerformanceData(query)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/prometheus-community/windows_exporter/relabel"
+	"github.com/prometheus-community/windows_exporter/remotewrite"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// pusher gathers the enabled collectors on an interval, and queues the
+// samples for the remote_write sender.
+type pusher struct {
+	interval time.Duration
+	// factory returns the collector of a single push, like the
+	// collectorFactory of metricsHandler.
+	factory func(timeout time.Duration) (error, prometheus.Collector)
+	sender  *remotewrite.Sender
+	// Collectors of the exporter itself, pushed along with the sender.
+	extraCollectors []prometheus.Collector
+	// Labels added to every metric.
+	constLabels *relabel.ConstLabels
+}
+
+// run pushes immediately, then on every interval until ctx is done.
+func (p *pusher) run(ctx context.Context) {
+	go p.sender.Run(ctx)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		if err := p.push(); err != nil {
+			log.Errorf("remote_write: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *pusher) push() error {
+	err, c := p.factory(p.interval)
+	if err != nil {
+		return err
+	}
+	collectors := append([]prometheus.Collector{c, p.sender}, p.extraCollectors...)
+	reg := newRegistry(nil, p.constLabels, collectors...)
+	t := time.Now()
+	mfs, err := reg.Gather()
+	if err != nil {
+		// Gather still returns the valid families, which are pushed, so an
+		// invalid series does not hold back every other sample. The metrics
+		// endpoint fails the whole scrape instead.
+		log.Warnf("remote_write: error gathering metrics: %v", err)
+	}
+	batch, samples := remotewrite.Encode(mfs, t)
+	log.Debugf("remote_write: queueing %d samples in %d bytes", samples, len(batch))
+	return p.sender.Enqueue(batch)
+}
+
+// readSecretFile returns the content of a file holding a password or token,
+// without surrounding whitespace.
+func readSecretFile(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret: %v", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus-community/windows_exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// hangingCollector blocks until release is closed.
+type hangingCollector struct {
+	release chan struct{}
+}
+
+func (c hangingCollector) Collect(ctx *collector.ScrapeContext, ch chan<- prometheus.Metric) error {
+	<-c.release
+	return nil
+}
+
+func TestCollectorRecording(t *testing.T) {
+	dir, err := ioutil.TempDir("", "windows_exporter_recording")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defaultSource := collector.DefaultPerflibSource
+	collector.DefaultPerflibSource = collector.NewFixturePerflibSource()
+	defer func() { collector.DefaultPerflibSource = defaultSource }()
+
+	hanging := hangingCollector{release: make(chan struct{})}
+	defer close(hanging.release)
+	coll := windowsCollector{
+		maxScrapeDuration: 50 * time.Millisecond,
+		collectors: map[string]collector.Collector{
+			"hanging": hanging,
+			"test":    &countingCollector{},
+		},
+		recordDir:      dir,
+		recordMaxFiles: 2,
+		runs:           newCollectorRuns(),
+	}
+	for i := 0; i < 3; i++ {
+		coll.runs = newCollectorRuns()
+		scrapeValues(t, coll)
+		// Recordings are named after the nanosecond the scrape started.
+		time.Sleep(time.Millisecond)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "scrape-*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected 2 recordings to be kept, got %v", files)
+	}
+	rec, err := collector.LoadRecording(files[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rec.Partial || len(rec.Running) != 1 || rec.Running[0] != "hanging" {
+		t.Errorf("expected the recording to be partial with collector hanging running, got partial=%v running=%v", rec.Partial, rec.Running)
+	}
+
+	replayer, err := collector.NewReplayer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay := windowsCollector{
+		maxScrapeDuration: time.Minute,
+		collectors:        map[string]collector.Collector{"test": &countingCollector{}},
+		replayer:          replayer,
+		runs:              newCollectorRuns(),
+	}
+	if got := scrapeValues(t, replay); got[testValueDesc.String()] != 1 {
+		t.Errorf("expected a scrape replaying the recordings to succeed, got %v", got)
+	}
+}
@@ -0,0 +1,165 @@
+package relabel
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Policies for constant labels a metric already has.
+const (
+	// ConflictRename moves the label of the metric to exported_<name>, as
+	// Prometheus does for target labels.
+	ConflictRename = "rename"
+	// ConflictKeep keeps the value of the metric.
+	ConflictKeep = "keep"
+	// ConflictOverride replaces the value of the metric with the constant
+	// one.
+	ConflictOverride = "override"
+)
+
+// ConstLabels adds constant labels to the series of gathered metric
+// families. A nil ConstLabels leaves them unchanged.
+type ConstLabels struct {
+	labels   map[string]string
+	conflict string
+	// pairs holds the labels sorted by name, shared by the series they are
+	// added to.
+	pairs []*dto.LabelPair
+}
+
+// NewConstLabels returns a ConstLabels adding labels to metrics, with the
+// given policy for labels a metric already has. It returns nil if there are
+// no labels.
+func NewConstLabels(labels map[string]string, conflict string) *ConstLabels {
+	if len(labels) == 0 {
+		return nil
+	}
+	c := &ConstLabels{labels: labels, conflict: conflict}
+	for name, value := range labels {
+		c.pairs = append(c.pairs, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+	}
+	sort.Sort(labelPairs(c.pairs))
+	return c
+}
+
+// Apply adds the constant labels to the series of mfs, in place. Series which
+// end up with the same labels, as the conflicting labels of the metrics were
+// overridden, are dropped and reported in the error.
+func (c *ConstLabels) Apply(mfs []*dto.MetricFamily) error {
+	if c == nil {
+		return nil
+	}
+	var errs prometheus.MultiError
+	for _, mf := range mfs {
+		changed := false
+		for _, m := range mf.Metric {
+			changed = c.apply(m) || changed
+		}
+		if changed && c.conflict == ConflictOverride {
+			errs = append(errs, dropDuplicates(mf)...)
+		}
+	}
+	return errs.MaybeUnwrap()
+}
+
+// apply adds the constant labels to m, and reports whether a label of m was
+// in conflict with them.
+func (c *ConstLabels) apply(m *dto.Metric) bool {
+	conflict := false
+	for _, l := range m.Label {
+		if _, ok := c.labels[l.GetName()]; ok {
+			conflict = true
+			break
+		}
+	}
+	if !conflict {
+		// The common case only needs the labels to be merged. The labels
+		// of gathered metrics may be shared with the collected ones, so they
+		// are copied rather than appended to.
+		pairs := make([]*dto.LabelPair, 0, len(m.Label)+len(c.pairs))
+		m.Label = append(append(pairs, m.Label...), c.pairs...)
+		sort.Sort(labelPairs(m.Label))
+		return false
+	}
+
+	labels := make(map[string]string, len(m.Label)+len(c.labels))
+	for _, l := range m.Label {
+		labels[l.GetName()] = l.GetValue()
+	}
+	for name, value := range c.labels {
+		existing, conflict := labels[name]
+		switch {
+		case !conflict || c.conflict == ConflictOverride:
+		case c.conflict == ConflictKeep:
+			continue
+		default:
+			exported := "exported_" + name
+			for labels[exported] != "" {
+				exported = "exported_" + exported
+			}
+			labels[exported] = existing
+		}
+		labels[name] = value
+	}
+	m.Label = make([]*dto.LabelPair, 0, len(labels))
+	for name, value := range labels {
+		m.Label = append(m.Label, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+	}
+	sort.Sort(labelPairs(m.Label))
+	return true
+}
+
+// dropDuplicates drops the series of mf with the labels of an earlier one,
+// and returns an error for each.
+func dropDuplicates(mf *dto.MetricFamily) []error {
+	var errs []error
+	seen := make(map[string]bool, len(mf.Metric))
+	kept := mf.Metric[:0]
+	for _, m := range mf.Metric {
+		var b strings.Builder
+		for _, l := range m.Label {
+			b.WriteString(l.GetName())
+			b.WriteByte(0xff)
+			b.WriteString(l.GetValue())
+			b.WriteByte(0xff)
+		}
+		if seen[b.String()] {
+			errs = append(errs, fmt.Errorf("collected metric %s %s was collected before with the same name and label values, once the constant labels were added", mf.GetName(), m.Label))
+			continue
+		}
+		seen[b.String()] = true
+		kept = append(kept, m)
+	}
+	mf.Metric = kept
+	return errs
+}
+
+// Gatherer returns a gatherer adding the constant labels to the metric
+// families of g.
+func (c *ConstLabels) Gatherer(g prometheus.Gatherer) prometheus.Gatherer {
+	if c == nil {
+		return g
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+		if applyErr := c.Apply(mfs); applyErr != nil {
+			if err == nil {
+				return mfs, applyErr
+			}
+			return mfs, prometheus.MultiError{err, applyErr}
+		}
+		return mfs, err
+	})
+}
+
+// labelPairs sorts label pairs by name.
+type labelPairs []*dto.LabelPair
+
+func (p labelPairs) Len() int           { return len(p) }
+func (p labelPairs) Less(i, j int) bool { return p[i].GetName() < p[j].GetName() }
+func (p labelPairs) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -0,0 +1,272 @@
+package relabel
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+// labelMatcher matches the value of a label. Missing labels have an empty
+// value.
+type labelMatcher struct {
+	name   string
+	negate bool
+	// value is matched exactly if regex is nil.
+	value string
+	regex *regexp.Regexp
+}
+
+func (m *labelMatcher) matches(labels map[string]string) bool {
+	v := labels[m.name]
+	var match bool
+	if m.regex != nil {
+		match = m.regex.MatchString(v)
+	} else {
+		match = v == m.value
+	}
+	return match != m.negate
+}
+
+// Selector selects series by metric name and labels, written as a metric
+// name glob, a set of label matchers in braces, or both, e.g.
+// windows_iis_*{site=~"Default.*",app!=""}. Globs may use * for any sequence
+// of characters and ? for a single one.
+type Selector struct {
+	matchers []*labelMatcher
+	// byName is true if the selector only matches metric names.
+	byName bool
+}
+
+// ParseSelector parses a series selector.
+func ParseSelector(s string) (*Selector, error) {
+	s = strings.TrimSpace(s)
+	name, body := s, ""
+	if i := strings.IndexByte(s, '{'); i >= 0 {
+		if !strings.HasSuffix(s, "}") {
+			return nil, fmt.Errorf("missing closing '}' in selector %q", s)
+		}
+		name, body = strings.TrimSpace(s[:i]), s[i+1:len(s)-1]
+	}
+
+	sel := &Selector{byName: true}
+	if name != "" {
+		re, err := globRegexp(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metric name in selector %q: %v", s, err)
+		}
+		sel.matchers = append(sel.matchers, &labelMatcher{name: model.MetricNameLabel, regex: re})
+	}
+	matchers, err := parseMatchers(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector %q: %v", s, err)
+	}
+	for _, m := range matchers {
+		sel.byName = sel.byName && m.name == model.MetricNameLabel
+	}
+	sel.matchers = append(sel.matchers, matchers...)
+	if len(sel.matchers) == 0 {
+		return nil, fmt.Errorf("empty selector")
+	}
+	return sel, nil
+}
+
+// Matches reports whether a series matches the selector. labels include the
+// metric name.
+func (s *Selector) Matches(labels map[string]string) bool {
+	for _, m := range s.matchers {
+		if !m.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// globRegexp compiles a metric name glob into an anchored regular expression.
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	if !model.IsValidMetricName(model.LabelValue(strings.NewReplacer("*", "a", "?", "a").Replace(glob))) {
+		return nil, fmt.Errorf("%q is not a valid metric name glob", glob)
+	}
+	pattern := regexp.QuoteMeta(glob)
+	pattern = strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(pattern)
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// parseMatchers parses comma-separated label matchers of the form
+// name="value", with one of the operators =, !=, =~ and !~.
+func parseMatchers(s string) ([]*labelMatcher, error) {
+	var matchers []*labelMatcher
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return matchers, nil
+		}
+		i := 0
+		for i < len(s) && (s[i] == '_' || 'a' <= s[i] && s[i] <= 'z' || 'A' <= s[i] && s[i] <= 'Z' || i > 0 && '0' <= s[i] && s[i] <= '9') {
+			i++
+		}
+		if i == 0 {
+			return nil, fmt.Errorf("expected a label name at %q", s)
+		}
+		m := &labelMatcher{name: s[:i]}
+		s = strings.TrimLeft(s[i:], " \t")
+
+		var op string
+		for _, o := range []string{"=~", "!~", "!=", "="} {
+			if strings.HasPrefix(s, o) {
+				op = o
+				break
+			}
+		}
+		if op == "" {
+			return nil, fmt.Errorf("expected one of =, !=, =~ and !~ after label %s", m.name)
+		}
+		s = strings.TrimLeft(s[len(op):], " \t")
+
+		value, rest, err := unquotePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of label %s: %v", m.name, err)
+		}
+		m.negate = op[0] == '!'
+		if strings.HasSuffix(op, "~") {
+			m.regex, err = regexp.Compile("^(?:" + value + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression of label %s: %v", m.name, err)
+			}
+		} else {
+			m.value = value
+		}
+		matchers = append(matchers, m)
+
+		s = strings.TrimLeft(rest, " \t")
+		if s == "" {
+			return matchers, nil
+		}
+		if s[0] != ',' {
+			return nil, fmt.Errorf("expected ',' at %q", s)
+		}
+		s = s[1:]
+	}
+}
+
+// unquotePrefix unquotes the double-quoted string s starts with, and returns
+// the remainder of s.
+func unquotePrefix(s string) (string, string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return "", "", fmt.Errorf("expected a double-quoted string at %q", s)
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			value, err := strconv.Unquote(s[:i+1])
+			return value, s[i+1:], err
+		}
+	}
+	return "", "", fmt.Errorf("unterminated string %s", s)
+}
+
+// SeriesMatcher keeps the series matching any of a set of selectors, and
+// none of another. A nil SeriesMatcher keeps all series.
+type SeriesMatcher struct {
+	match   []*Selector
+	exclude []*Selector
+	byName  bool
+}
+
+// NewSeriesMatcher returns a SeriesMatcher keeping the series matching any of
+// the match selectors, or all if there is none, and none of the exclude
+// selectors. It returns nil if there are no selectors.
+func NewSeriesMatcher(match, exclude []string) (*SeriesMatcher, error) {
+	if len(match) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+	m := &SeriesMatcher{byName: true}
+	for _, s := range match {
+		sel, err := ParseSelector(s)
+		if err != nil {
+			return nil, err
+		}
+		m.match = append(m.match, sel)
+		m.byName = m.byName && sel.byName
+	}
+	for _, s := range exclude {
+		sel, err := ParseSelector(s)
+		if err != nil {
+			return nil, err
+		}
+		m.exclude = append(m.exclude, sel)
+		m.byName = m.byName && sel.byName
+	}
+	return m, nil
+}
+
+// keep reports whether the series of the given labels, including the metric
+// name, is kept.
+func (m *SeriesMatcher) keep(labels map[string]string) bool {
+	for _, s := range m.exclude {
+		if s.Matches(labels) {
+			return false
+		}
+	}
+	if len(m.match) == 0 {
+		return true
+	}
+	for _, s := range m.match {
+		if s.Matches(labels) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter returns the metric families of mfs with the kept series, changing
+// them in place. Families left without series are dropped.
+func (m *SeriesMatcher) Filter(mfs []*dto.MetricFamily) []*dto.MetricFamily {
+	if m == nil {
+		return mfs
+	}
+	kept := mfs[:0]
+	for _, mf := range mfs {
+		if m.byName {
+			// Only the name is needed, which decides for the whole family.
+			if m.keep(map[string]string{model.MetricNameLabel: mf.GetName()}) {
+				kept = append(kept, mf)
+			}
+			continue
+		}
+		metrics := mf.Metric[:0]
+		for _, metric := range mf.Metric {
+			labels := make(map[string]string, len(metric.GetLabel())+1)
+			for _, l := range metric.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			labels[model.MetricNameLabel] = mf.GetName()
+			if m.keep(labels) {
+				metrics = append(metrics, metric)
+			}
+		}
+		mf.Metric = metrics
+		if len(metrics) > 0 {
+			kept = append(kept, mf)
+		}
+	}
+	return kept
+}
+
+// Gatherer returns a gatherer passing on the kept series of the metric
+// families of g.
+func (m *SeriesMatcher) Gatherer(g prometheus.Gatherer) prometheus.Gatherer {
+	if m == nil {
+		return g
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+		return m.Filter(mfs), err
+	})
+}
@@ -0,0 +1,276 @@
+package relabel
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+// maxCachedDescs bounds the caches of descriptors, as some collectors create
+// new descriptors on every scrape.
+const maxCachedDescs = 4096
+
+type descInfo struct {
+	name string
+	help string
+}
+
+// DescCache holds the names and help of the metrics of descriptors.
+// prometheus.Desc does not expose them, so they are read from the metric
+// family of a metric of each descriptor, gathered on its own. It also holds
+// the descriptors of rebuilt metrics. The zero value is ready to use.
+type DescCache struct {
+	mtx     sync.Mutex
+	descs   map[*prometheus.Desc]descInfo
+	rebuilt map[string]*prometheus.Desc
+}
+
+// singleMetric is an unchecked collector of a single metric.
+type singleMetric struct {
+	metric prometheus.Metric
+}
+
+func (c singleMetric) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c singleMetric) Collect(ch chan<- prometheus.Metric) {
+	ch <- c.metric
+}
+
+// Describe returns the name and help of a metric. It returns false if the
+// metric is invalid.
+func (c *DescCache) Describe(m prometheus.Metric) (name, help string, ok bool) {
+	d, ok := c.describe(m)
+	return d.name, d.help, ok
+}
+
+func (c *DescCache) describe(m prometheus.Metric) (descInfo, bool) {
+	desc := m.Desc()
+	c.mtx.Lock()
+	d, ok := c.descs[desc]
+	c.mtx.Unlock()
+	if ok {
+		return d, true
+	}
+
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(singleMetric{m}); err != nil {
+		return descInfo{}, false
+	}
+	mfs, err := reg.Gather()
+	if err != nil || len(mfs) != 1 {
+		return descInfo{}, false
+	}
+	d = descInfo{name: mfs[0].GetName(), help: mfs[0].GetHelp()}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.descs == nil || len(c.descs) >= maxCachedDescs {
+		c.descs = make(map[*prometheus.Desc]descInfo)
+	}
+	c.descs[desc] = d
+	return d, true
+}
+
+// read returns the name, help and value of a metric, and its labels including
+// the metric name. It returns false if the metric cannot be read.
+func (c *DescCache) read(m prometheus.Metric) (descInfo, *dto.Metric, map[string]string, bool) {
+	d, ok := c.describe(m)
+	if !ok {
+		return descInfo{}, nil, nil, false
+	}
+	pb := &dto.Metric{}
+	if err := m.Write(pb); err != nil {
+		return descInfo{}, nil, nil, false
+	}
+	labels := make(map[string]string, len(pb.GetLabel())+1)
+	for _, l := range pb.GetLabel() {
+		labels[l.GetName()] = l.GetValue()
+	}
+	labels[model.MetricNameLabel] = d.name
+	return d, pb, labels, true
+}
+
+// Relabeler applies the rules of a configuration to metrics, and counts the
+// series they drop. A nil Relabeler passes all metrics through.
+type Relabeler struct {
+	config *Config
+	descs  DescCache
+
+	mtx     sync.Mutex
+	dropped map[string]float64
+}
+
+// New returns a Relabeler applying the rules of c.
+func New(c *Config) *Relabeler {
+	return &Relabeler{
+		config:  c,
+		dropped: make(map[string]float64),
+	}
+}
+
+// Fork returns a Relabeler applying the rules of r, which counts the series
+// it drops on its own. Forking a nil Relabeler returns nil.
+func (r *Relabeler) Fork() *Relabeler {
+	if r == nil {
+		return nil
+	}
+	return New(r.config)
+}
+
+// rules returns the rules applying to the metrics of the named collector.
+func (r *Relabeler) rules(collector string) []*Rule {
+	if r == nil {
+		return nil
+	}
+	rules := r.config.Collectors[collector]
+	if len(r.config.Global) == 0 {
+		return rules
+	}
+	return append(append([]*Rule(nil), rules...), r.config.Global...)
+}
+
+// Enabled reports whether any rule applies to the metrics of the named
+// collector.
+func (r *Relabeler) Enabled(collector string) bool {
+	return len(r.rules(collector)) > 0
+}
+
+// Dropped returns the number of series of the named collector dropped so far.
+func (r *Relabeler) Dropped(collector string) float64 {
+	if r == nil {
+		return 0
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.dropped[collector]
+}
+
+// Apply relabels a metric of the named collector. It returns false if the
+// metric is dropped. Metrics which cannot be read are passed through
+// unchanged, so their error is reported.
+func (r *Relabeler) Apply(collector string, m prometheus.Metric) (prometheus.Metric, bool) {
+	m, keep := r.apply(r.rules(collector), m)
+	if !keep {
+		r.mtx.Lock()
+		r.dropped[collector]++
+		r.mtx.Unlock()
+	}
+	return m, keep
+}
+
+// ApplyGlobal relabels a metric with the global rules only. It is meant for
+// metrics describing a collector rather than produced by it, whose drops are
+// not counted.
+func (r *Relabeler) ApplyGlobal(m prometheus.Metric) (prometheus.Metric, bool) {
+	if r == nil {
+		return m, true
+	}
+	return r.apply(r.config.Global, m)
+}
+
+func (r *Relabeler) apply(rules []*Rule, m prometheus.Metric) (prometheus.Metric, bool) {
+	if len(rules) == 0 {
+		return m, true
+	}
+	d, pb, labels, ok := r.descs.read(m)
+	if !ok {
+		return m, true
+	}
+	if !Process(labels, rules) {
+		return nil, false
+	}
+	if unchanged(labels, d.name, pb.GetLabel()) {
+		return m, true
+	}
+	return r.descs.rebuild(labels, d.help, pb), true
+}
+
+func unchanged(labels map[string]string, name string, pairs []*dto.LabelPair) bool {
+	if labels[model.MetricNameLabel] != name || len(labels) != len(pairs)+1 {
+		return false
+	}
+	for _, l := range pairs {
+		if v, ok := labels[l.GetName()]; !ok || v != l.GetValue() {
+			return false
+		}
+	}
+	return true
+}
+
+// rebuild returns a metric with the value of pb and the given labels,
+// including the metric name. Labels reserved for relabeling, starting with
+// __, are removed. The descriptors of rebuilt metrics are kept, with the
+// labels as variable labels, so one is shared by the series with the same
+// label names.
+func (c *DescCache) rebuild(labels map[string]string, help string, pb *dto.Metric) prometheus.Metric {
+	name := labels[model.MetricNameLabel]
+	names := make([]string, 0, len(labels))
+	for l := range labels {
+		if !strings.HasPrefix(l, model.ReservedLabelPrefix) {
+			names = append(names, l)
+		}
+	}
+	sort.Strings(names)
+	values := make([]string, len(names))
+	for i, l := range names {
+		values[i] = labels[l]
+	}
+	desc := c.rebuiltDesc(name, help, names)
+
+	var (
+		m   prometheus.Metric
+		err error
+	)
+	switch {
+	case pb.Counter != nil:
+		m, err = prometheus.NewConstMetric(desc, prometheus.CounterValue, pb.Counter.GetValue(), values...)
+	case pb.Gauge != nil:
+		m, err = prometheus.NewConstMetric(desc, prometheus.GaugeValue, pb.Gauge.GetValue(), values...)
+	case pb.Untyped != nil:
+		m, err = prometheus.NewConstMetric(desc, prometheus.UntypedValue, pb.Untyped.GetValue(), values...)
+	case pb.Summary != nil:
+		quantiles := make(map[float64]float64, len(pb.Summary.GetQuantile()))
+		for _, q := range pb.Summary.GetQuantile() {
+			quantiles[q.GetQuantile()] = q.GetValue()
+		}
+		m, err = prometheus.NewConstSummary(desc, pb.Summary.GetSampleCount(), pb.Summary.GetSampleSum(), quantiles, values...)
+	case pb.Histogram != nil:
+		buckets := make(map[float64]uint64, len(pb.Histogram.GetBucket()))
+		for _, b := range pb.Histogram.GetBucket() {
+			buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+		}
+		m, err = prometheus.NewConstHistogram(desc, pb.Histogram.GetSampleCount(), pb.Histogram.GetSampleSum(), buckets, values...)
+	default:
+		err = fmt.Errorf("metric %s has no value", name)
+	}
+	if err != nil {
+		return prometheus.NewInvalidMetric(desc, fmt.Errorf("relabeled metric %s is invalid: %v", name, err))
+	}
+	if pb.TimestampMs != nil {
+		m = prometheus.NewMetricWithTimestamp(time.Unix(0, pb.GetTimestampMs()*int64(time.Millisecond)), m)
+	}
+	return m
+}
+
+// rebuiltDesc returns the descriptor of rebuilt metrics of the given name,
+// help and label names.
+func (c *DescCache) rebuiltDesc(name, help string, labelNames []string) *prometheus.Desc {
+	key := name + "\xff" + help + "\xff" + strings.Join(labelNames, "\xff")
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if desc, ok := c.rebuilt[key]; ok {
+		return desc
+	}
+	desc := prometheus.NewDesc(name, help, labelNames, nil)
+	if c.rebuilt == nil || len(c.rebuilt) >= maxCachedDescs {
+		c.rebuilt = make(map[string]*prometheus.Desc)
+	}
+	c.rebuilt[key] = desc
+	return desc
+}
@@ -0,0 +1,213 @@
+// Package relabel rewrites, labels and selects series before they are
+// exposed. Relabeling rules are modelled on the metric_relabel_configs of
+// Prometheus, and apply to the metrics of a single collector, or of all of
+// them.
+package relabel
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// Actions of a rule.
+const (
+	// Replace sets TargetLabel to Replacement, expanded with the submatches of
+	// Regex, if Regex matches the source value.
+	Replace = "replace"
+	// Keep drops the series whose source value does not match Regex.
+	Keep = "keep"
+	// Drop drops the series whose source value matches Regex.
+	Drop = "drop"
+	// LabelDrop removes the labels whose name matches Regex.
+	LabelDrop = "labeldrop"
+	// HashMod sets TargetLabel to the hash of the source value modulo
+	// Modulus.
+	HashMod = "hashmod"
+)
+
+var actions = []string{Replace, Keep, Drop, LabelDrop, HashMod}
+
+// Config is the content of a relabeling configuration file.
+type Config struct {
+	// Global rules apply to the metrics of every collector, after the rules
+	// of the collector.
+	Global []*Rule `yaml:"global"`
+	// Collectors holds the rules of each collector, by collector name.
+	Collectors map[string][]*Rule `yaml:"collectors"`
+}
+
+// Rule is a single relabeling step. The source value is the concatenation of
+// the values of SourceLabels, joined by Separator. The metric name is the
+// value of the __name__ label.
+type Rule struct {
+	SourceLabels []string `yaml:"source_labels,flow"`
+	Separator    string   `yaml:"separator"`
+	Regex        string   `yaml:"regex"`
+	Modulus      uint64   `yaml:"modulus"`
+	TargetLabel  string   `yaml:"target_label"`
+	Replacement  string   `yaml:"replacement"`
+	Action       string   `yaml:"action"`
+
+	regex *regexp.Regexp
+}
+
+// UnmarshalYAML sets the defaults of the fields missing from a rule.
+func (r *Rule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*r = Rule{
+		Separator:   ";",
+		Regex:       "(.*)",
+		Replacement: "$1",
+		Action:      Replace,
+	}
+	type plain Rule
+	return unmarshal((*plain)(r))
+}
+
+// LoadFile reads and validates a relabeling configuration file.
+func LoadFile(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+// Parse parses and validates a relabeling configuration.
+func Parse(b []byte) (*Config, error) {
+	c := &Config{}
+	if err := yaml.UnmarshalStrict(b, c); err != nil {
+		return nil, err
+	}
+	for i, r := range c.Global {
+		if err := r.compile(); err != nil {
+			return nil, fmt.Errorf("global rule %d: %v", i, err)
+		}
+	}
+	for name, rules := range c.Collectors {
+		for i, r := range rules {
+			if err := r.compile(); err != nil {
+				return nil, fmt.Errorf("rule %d of collector %s: %v", i, name, err)
+			}
+		}
+	}
+	return c, nil
+}
+
+// compile validates the rule and compiles its regular expression.
+func (r *Rule) compile() error {
+	if r == nil {
+		return fmt.Errorf("empty rule")
+	}
+	valid := false
+	for _, a := range actions {
+		valid = valid || r.Action == a
+	}
+	if !valid {
+		return fmt.Errorf("unknown action %q, expected one of %s", r.Action, strings.Join(actions, ", "))
+	}
+	for _, l := range r.SourceLabels {
+		if !model.LabelName(l).IsValid() {
+			return fmt.Errorf("invalid source label %q", l)
+		}
+	}
+	switch r.Action {
+	case Replace, HashMod:
+		if r.TargetLabel == "" {
+			return fmt.Errorf("action %s requires a target_label", r.Action)
+		}
+		// Target labels of replace rules may be expanded from the regex.
+		if !strings.Contains(r.TargetLabel, "$") && !model.LabelName(r.TargetLabel).IsValid() {
+			return fmt.Errorf("invalid target label %q", r.TargetLabel)
+		}
+	}
+	if r.Action == HashMod && r.Modulus == 0 {
+		return fmt.Errorf("action hashmod requires a positive modulus")
+	}
+	re, err := regexp.Compile("^(?:" + r.Regex + ")$")
+	if err != nil {
+		return fmt.Errorf("invalid regular expression %q: %v", r.Regex, err)
+	}
+	r.regex = re
+	return nil
+}
+
+// Process applies rules to the labels of a series in order, modifying them in
+// place. It returns false if the series is dropped. Labels with an empty value
+// are removed.
+func Process(labels map[string]string, rules []*Rule) bool {
+	for _, r := range rules {
+		if !r.apply(labels) {
+			return false
+		}
+	}
+	for name, value := range labels {
+		if value == "" {
+			delete(labels, name)
+		}
+	}
+	return true
+}
+
+func (r *Rule) apply(labels map[string]string) bool {
+	values := make([]string, 0, len(r.SourceLabels))
+	for _, name := range r.SourceLabels {
+		values = append(values, labels[name])
+	}
+	val := strings.Join(values, r.Separator)
+
+	switch r.Action {
+	case Keep:
+		return r.regex.MatchString(val)
+	case Drop:
+		return !r.regex.MatchString(val)
+	case LabelDrop:
+		for name := range labels {
+			if r.regex.MatchString(name) {
+				delete(labels, name)
+			}
+		}
+	case HashMod:
+		sum := md5.Sum([]byte(val))
+		mod := binary.BigEndian.Uint64(sum[8:]) % r.Modulus
+		labels[r.TargetLabel] = strconv.FormatUint(mod, 10)
+	case Replace:
+		indexes := r.regex.FindStringSubmatchIndex(val)
+		if indexes == nil {
+			break
+		}
+		target := string(r.regex.ExpandString(nil, r.TargetLabel, val, indexes))
+		if !model.LabelName(target).IsValid() {
+			break
+		}
+		res := string(r.regex.ExpandString(nil, r.Replacement, val, indexes))
+		if res == "" {
+			delete(labels, target)
+			break
+		}
+		labels[target] = res
+	}
+	return true
+}
+
+// Names returns the names of the collectors with rules, sorted.
+func (c *Config) Names() []string {
+	names := make([]string, 0, len(c.Collectors))
+	for name := range c.Collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,346 @@
+package relabel
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(`
+global:
+  - target_label: env
+    replacement: prod
+collectors:
+  cpu:
+    - source_labels: [__name__]
+      regex: windows_cpu_cstate_seconds_total
+      action: drop
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Rule{Separator: ";", Regex: "(.*)", TargetLabel: "env", Replacement: "prod", Action: Replace}
+	got := *c.Global[0]
+	got.regex = nil
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the defaults to be set, got %+v", got)
+	}
+	if names := c.Names(); len(names) != 1 || names[0] != "cpu" {
+		t.Errorf("expected rules for cpu, got %v", names)
+	}
+
+	for _, tc := range []struct {
+		config string
+		err    string
+	}{
+		{"global:\n  - action: keepp\n", `global rule 0: unknown action "keepp"`},
+		{"global:\n  - regex: (a\n    action: drop\n", "global rule 0: invalid regular expression"},
+		{"collectors:\n  cpu:\n    - replacement: foo\n", "rule 0 of collector cpu: action replace requires a target_label"},
+		{"global:\n  - target_label: shard\n    action: hashmod\n", "action hashmod requires a positive modulus"},
+		{"global:\n  - target_label: 1shard\n", `invalid target label "1shard"`},
+		{"global:\n  - source_labels: [a-b]\n    action: keep\n", `invalid source label "a-b"`},
+		{"global:\n  - action: drop\n    regexp: foo\n", "field regexp not found"},
+	} {
+		_, err := Parse([]byte(tc.config))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("expected an error containing %q for %q, got %v", tc.err, tc.config, err)
+		}
+	}
+}
+
+func TestProcess(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		rules  string
+		input  map[string]string
+		output map[string]string
+	}{
+		{
+			name:   "keep",
+			rules:  "- source_labels: [__name__]\n  regex: windows_cpu_.*\n  action: keep\n",
+			input:  map[string]string{"__name__": "windows_os_info"},
+			output: nil,
+		},
+		{
+			name:   "drop by joined labels",
+			rules:  "- source_labels: [core, state]\n  separator: /\n  regex: 0,0/c3\n  action: drop\n",
+			input:  map[string]string{"__name__": "windows_cpu_cstate_seconds_total", "core": "0,0", "state": "c3"},
+			output: nil,
+		},
+		{
+			name:   "drop not matching",
+			rules:  "- source_labels: [state]\n  regex: c3\n  action: drop\n",
+			input:  map[string]string{"__name__": "windows_cpu_cstate_seconds_total", "state": "c2"},
+			output: map[string]string{"__name__": "windows_cpu_cstate_seconds_total", "state": "c2"},
+		},
+		{
+			name:   "replace",
+			rules:  "- source_labels: [volume]\n  regex: '(.):'\n  target_label: drive\n  replacement: drive_$1\n",
+			input:  map[string]string{"__name__": "windows_logical_disk_free_bytes", "volume": "C:"},
+			output: map[string]string{"__name__": "windows_logical_disk_free_bytes", "volume": "C:", "drive": "drive_C"},
+		},
+		{
+			name:   "replace with an empty value removes the label",
+			rules:  "- source_labels: [volume]\n  regex: Harddisk.*\n  target_label: volume\n  replacement: ''\n",
+			input:  map[string]string{"__name__": "windows_logical_disk_free_bytes", "volume": "HarddiskVolume1"},
+			output: map[string]string{"__name__": "windows_logical_disk_free_bytes"},
+		},
+		{
+			name:   "replace requires a full match",
+			rules:  "- source_labels: [volume]\n  regex: C\n  target_label: drive\n",
+			input:  map[string]string{"__name__": "windows_logical_disk_free_bytes", "volume": "C:"},
+			output: map[string]string{"__name__": "windows_logical_disk_free_bytes", "volume": "C:"},
+		},
+		{
+			name:   "labeldrop",
+			rules:  "- regex: core|state\n  action: labeldrop\n",
+			input:  map[string]string{"__name__": "windows_cpu_cstate_seconds_total", "core": "0,0", "state": "c3"},
+			output: map[string]string{"__name__": "windows_cpu_cstate_seconds_total"},
+		},
+		{
+			name:   "hashmod",
+			rules:  "- source_labels: [process]\n  target_label: shard\n  modulus: 8\n  action: hashmod\n",
+			input:  map[string]string{"__name__": "windows_process_cpu_time_total", "process": "firefox"},
+			output: map[string]string{"__name__": "windows_process_cpu_time_total", "process": "firefox", "shard": "2"},
+		},
+	} {
+		c, err := Parse([]byte("global:\n" + indent(tc.rules)))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		kept := Process(tc.input, c.Global)
+		if tc.output == nil {
+			if kept {
+				t.Errorf("%s: expected the series to be dropped, got %v", tc.name, tc.input)
+			}
+			continue
+		}
+		if !kept {
+			t.Errorf("%s: expected the series to be kept", tc.name)
+			continue
+		}
+		if !reflect.DeepEqual(tc.input, tc.output) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.output, tc.input)
+		}
+	}
+}
+
+func indent(s string) string {
+	return "  " + strings.Replace(strings.TrimSuffix(s, "\n"), "\n", "\n  ", -1) + "\n"
+}
+
+type metrics []prometheus.Metric
+
+func (c metrics) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c {
+		ch <- m
+	}
+}
+
+func TestRelabeler(t *testing.T) {
+	c, err := Parse([]byte(`
+global:
+  - target_label: env
+    replacement: prod
+collectors:
+  cpu:
+    - source_labels: [__name__]
+      regex: windows_cpu_cstate_seconds_total
+      action: drop
+    - source_labels: [__name__]
+      regex: windows_cpu_(.*)
+      target_label: __name__
+      replacement: windows_processor_$1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New(c)
+
+	cstateDesc := prometheus.NewDesc("windows_cpu_cstate_seconds_total", "Time spent in low-power idle states.", []string{"core", "state"}, nil)
+	timeDesc := prometheus.NewDesc("windows_cpu_time_total", "Time spent in the processor modes.", []string{"core", "mode"}, nil)
+	durationDesc := prometheus.NewDesc("test_duration_seconds", "A summary.", nil, prometheus.Labels{"source": "test"})
+
+	var out metrics
+	for _, in := range []struct {
+		collector string
+		m         prometheus.Metric
+	}{
+		{"cpu", prometheus.MustNewConstMetric(cstateDesc, prometheus.CounterValue, 1, "0,0", "c1")},
+		{"cpu", prometheus.NewMetricWithTimestamp(time.Unix(1600000000, 0), prometheus.MustNewConstMetric(timeDesc, prometheus.CounterValue, 2, "0,0", "idle"))},
+		{"os", prometheus.MustNewConstSummary(durationDesc, 3, 1.5, map[float64]float64{0.5: 0.25})},
+	} {
+		if m, keep := r.Apply(in.collector, in.m); keep {
+			out = append(out, m)
+		}
+	}
+
+	expected := `# HELP test_duration_seconds A summary.
+# TYPE test_duration_seconds summary
+test_duration_seconds{env="prod",source="test",quantile="0.5"} 0.25
+test_duration_seconds_sum{env="prod",source="test"} 1.5
+test_duration_seconds_count{env="prod",source="test"} 3
+# HELP windows_processor_time_total Time spent in the processor modes.
+# TYPE windows_processor_time_total counter
+windows_processor_time_total{core="0,0",env="prod",mode="idle"} 2 1600000000000
+`
+	if err := testutil.CollectAndCompare(out, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+	if n := r.Dropped("cpu"); n != 1 {
+		t.Errorf("expected 1 dropped sample of cpu, got %v", n)
+	}
+	if !r.Enabled("os") {
+		t.Error("expected the global rules to apply to os")
+	}
+
+	var none *Relabeler
+	m := prometheus.MustNewConstMetric(cstateDesc, prometheus.CounterValue, 1, "0,0", "c1")
+	if got, keep := none.Apply("cpu", m); !keep || got != m {
+		t.Error("expected a nil relabeler to pass metrics through")
+	}
+}
+
+func TestConstLabels(t *testing.T) {
+	desc := prometheus.NewDesc("test_info", "Information.", []string{"role"}, prometheus.Labels{"exported_role": "old"})
+	noRoleDesc := prometheus.NewDesc("test_value", "A value.", nil, nil)
+	in := metrics{
+		prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, "sql"),
+		prometheus.MustNewConstMetric(noRoleDesc, prometheus.GaugeValue, 2),
+	}
+	for _, tc := range []struct {
+		conflict string
+		expected string
+	}{
+		{ConflictRename, `test_info{datacenter="ams1",exported_exported_role="sql",exported_role="old",role="web"} 1`},
+		{ConflictKeep, `test_info{datacenter="ams1",exported_role="old",role="sql"} 1`},
+		{ConflictOverride, `test_info{datacenter="ams1",exported_role="old",role="web"} 1`},
+	} {
+		c := NewConstLabels(map[string]string{"datacenter": "ams1", "role": "web"}, tc.conflict)
+		expected := `# HELP test_info Information.
+# TYPE test_info gauge
+` + tc.expected + `
+# HELP test_value A value.
+# TYPE test_value gauge
+test_value{datacenter="ams1",role="web"} 2
+`
+		if err := testutil.GatherAndCompare(c.Gatherer(registry(t, in)), strings.NewReader(expected)); err != nil {
+			t.Errorf("%s: %v", tc.conflict, err)
+		}
+	}
+
+	reg := registry(t, in)
+	if g := NewConstLabels(nil, ConflictRename).Gatherer(reg); g != reg {
+		t.Error("expected gatherers to be left unwrapped without labels")
+	}
+
+	c := NewConstLabels(map[string]string{"role": "web"}, ConflictOverride)
+	duplicates := metrics{
+		prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, "sql"),
+		prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 2, "iis"),
+	}
+	mfs, err := c.Gatherer(registry(t, duplicates)).Gather()
+	if err == nil {
+		t.Error("expected an error for series overridden into the same labels")
+	}
+	if len(mfs) != 1 || len(mfs[0].GetMetric()) != 1 {
+		t.Errorf("expected the duplicate series to be dropped, got %v", mfs)
+	}
+}
+
+// registry returns a registry of c.
+func registry(t *testing.T, c prometheus.Collector) *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(c); err != nil {
+		t.Fatal(err)
+	}
+	return reg
+}
+
+// gather returns the metric families of c.
+func gather(t *testing.T, c prometheus.Collector) []*dto.MetricFamily {
+	mfs, err := registry(t, c).Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mfs
+}
+
+func TestSeriesMatcher(t *testing.T) {
+	requestsDesc := prometheus.NewDesc("windows_iis_requests_total", "Requests.", []string{"site", "method"}, nil)
+	bufmanDesc := prometheus.NewDesc("windows_mssql_bufman_page_reads", "Page reads.", []string{"mssql_instance"}, nil)
+	in := metrics{
+		prometheus.MustNewConstMetric(requestsDesc, prometheus.CounterValue, 1, "Default Web Site", "GET"),
+		prometheus.MustNewConstMetric(requestsDesc, prometheus.CounterValue, 2, "Default Web Site", "POST"),
+		prometheus.MustNewConstMetric(requestsDesc, prometheus.CounterValue, 3, "api", "GET"),
+		prometheus.MustNewConstMetric(bufmanDesc, prometheus.GaugeValue, 4, "SQLEXPRESS"),
+	}
+	for _, tc := range []struct {
+		match, exclude []string
+		values         []float64
+	}{
+		{match: []string{"windows_mssql_*"}, values: []float64{4}},
+		{match: []string{"windows_iis_requests_total{method=\"GET\"}", "windows_mssql_bufman_page_reads"}, values: []float64{1, 3, 4}},
+		{match: []string{`{__name__=~"windows_iis_.*", site=~"Default.*"}`}, values: []float64{1, 2}},
+		{exclude: []string{"windows_iis_*"}, values: []float64{4}},
+		{match: []string{"windows_iis_?equests_total"}, exclude: []string{`{site="api"}`, `{method!="GET"}`}, values: []float64{1}},
+		{exclude: []string{`{mssql_instance=""}`}, values: []float64{4}},
+	} {
+		m, err := NewSeriesMatcher(tc.match, tc.exclude)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var values []float64
+		for _, mf := range m.Filter(gather(t, in)) {
+			for _, metric := range mf.GetMetric() {
+				values = append(values, metric.GetCounter().GetValue()+metric.GetGauge().GetValue())
+			}
+		}
+		sort.Float64s(values)
+		if !reflect.DeepEqual(values, tc.values) {
+			t.Errorf("match %q exclude %q: expected %v, got %v", tc.match, tc.exclude, tc.values, values)
+		}
+	}
+
+	if m, err := NewSeriesMatcher(nil, nil); m != nil || err != nil {
+		t.Errorf("expected no matcher without selectors, got %v, %v", m, err)
+	}
+	for _, s := range []string{"", "{}", "windows-cpu", `{site="a"`, `{site=a}`, `{site~"a"}`, `{site=~"(a"}`, `{site="a" method="b"}`, `{site="a}`} {
+		if _, err := ParseSelector(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestDescCache(t *testing.T) {
+	var c DescCache
+	desc := prometheus.NewDesc("test_quoted", `A "quoted" help, with a \ backslash.`, []string{"site"}, nil)
+	name, help, ok := c.Describe(prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, "a"))
+	if !ok || name != "test_quoted" || help != `A "quoted" help, with a \ backslash.` {
+		t.Errorf("unexpected name %q and help %q", name, help)
+	}
+	invalid := prometheus.NewInvalidMetric(prometheus.NewDesc("test_invalid", "help", nil, nil), fmt.Errorf("failed"))
+	if _, _, ok := c.Describe(invalid); ok {
+		t.Error("expected an invalid metric not to be described")
+	}
+
+	pb := &dto.Metric{Gauge: &dto.Gauge{Value: proto.Float64(1)}}
+	a := c.rebuild(map[string]string{"__name__": "test", "site": "a"}, "help", pb)
+	b := c.rebuild(map[string]string{"__name__": "test", "site": "b"}, "help", pb)
+	if a.Desc() != b.Desc() {
+		t.Error("expected the series of the same label names to share a descriptor")
+	}
+}
@@ -0,0 +1,554 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus-community/windows_exporter/collector"
+	"github.com/prometheus-community/windows_exporter/config"
+	"github.com/prometheus-community/windows_exporter/relabel"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	reloadSuccessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "config_last_reload_successful"),
+		"windows_exporter: Whether the last configuration reload attempt was successful.",
+		nil,
+		nil,
+	)
+	reloadTimestampDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "config_last_reload_success_timestamp_seconds"),
+		"windows_exporter: Unix time of the last successful configuration reload, or of the start if there was none.",
+		nil,
+		nil,
+	)
+	configInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "config_info"),
+		"windows_exporter: A hash of the effective configuration, as shown on /config.",
+		[]string{"hash"},
+		nil,
+	)
+	valueSourceDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "config_value_source_info"),
+		"windows_exporter: The configuration file supplying the value of a flag. Flags not set by a file are omitted.",
+		[]string{"flag", "file"},
+		nil,
+	)
+)
+
+// collectorSet holds the collectors built from the configuration, and the
+// settings applying to them. It is replaced as a whole on reloads.
+type collectorSet struct {
+	collectors map[string]collector.Collector
+	timeouts   map[string]time.Duration
+	cache      *collectorCache
+	filters    map[string][]*seriesFilter
+	relabeler  *relabel.Relabeler
+	limiter    *seriesLimiter
+	// modules holds the collector sets of the scrape modules, by name.
+	modules map[string]*collectorSet
+	// defaultTimeout is the scrape timeout of a module when Prometheus gives
+	// none, 0 if unset.
+	defaultTimeout time.Duration
+}
+
+// collectorFlags holds the values of the flags a collector set is built from.
+type collectorFlags struct {
+	enabled     string
+	timeouts    []string
+	cacheTTLs   []string
+	filters     []string
+	relabelFile string
+	// seriesLimits holds the series limits of collectors, and
+	// defaultSeriesLimit that of the others. totalSeriesLimit caps the
+	// series of all collectors of a scrape.
+	seriesLimits       []string
+	defaultSeriesLimit int
+	totalSeriesLimit   int
+	// settings holds the values collectors are built with. Collectors of
+	// scrape modules are built with the values of the module overriding them.
+	settings collector.Settings
+	modules  map[string]config.Module
+}
+
+// newCollectorFlags returns the values of the flags a collector set is built
+// from, out of a loaded configuration.
+func newCollectorFlags(c *loadedConfig) (collectorFlags, error) {
+	defaultSeriesLimit, err := strconv.Atoi(c.values.Get("scrape.default-series-limit"))
+	if err != nil {
+		return collectorFlags{}, fmt.Errorf("invalid default series limit: %v", err)
+	}
+	totalSeriesLimit, err := strconv.Atoi(c.values.Get("scrape.total-series-limit"))
+	if err != nil {
+		return collectorFlags{}, fmt.Errorf("invalid total series limit: %v", err)
+	}
+	settings := collector.Settings{Flags: make(map[string]string, len(c.values))}
+	for name := range c.values {
+		settings.Flags[name] = c.values.Get(name)
+	}
+	return collectorFlags{
+		enabled:            c.values.Get("collectors.enabled"),
+		timeouts:           c.values["scrape.collector-timeout"],
+		cacheTTLs:          c.values["scrape.cache-ttl"],
+		filters:            c.values["collector.filter"],
+		relabelFile:        c.values.Get("relabel.config.file"),
+		seriesLimits:       c.values["scrape.series-limit"],
+		defaultSeriesLimit: defaultSeriesLimit,
+		totalSeriesLimit:   totalSeriesLimit,
+		settings:           settings,
+		modules:            c.modules,
+	}, nil
+}
+
+// buildCollectorSet builds the enabled collectors and those of the scrape
+// modules, and validates the settings naming them. The collectors of prev,
+// the set in use if any, are kept if their settings did not change.
+func buildCollectorSet(flags collectorFlags, prev *collectorSet) (*collectorSet, error) {
+	var prevCollectors map[string]collector.Collector
+	var prevFilters map[string][]*seriesFilter
+	prevModules := map[string]*collectorSet{}
+	if prev != nil {
+		prevCollectors, prevFilters, prevModules = prev.collectors, prev.filters, prev.modules
+	}
+	collectors, err := loadCollectors(flags.enabled, flags.settings, prevCollectors)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load collectors: %v", err)
+	}
+	names := keys(collectors)
+
+	moduleCollectors := make(map[string]map[string]collector.Collector, len(flags.modules))
+	for name, m := range flags.modules {
+		var prevModule map[string]collector.Collector
+		if pm, ok := prevModules[name]; ok {
+			prevModule = pm.collectors
+		}
+		mc, err := loadCollectors(moduleEnabled(m, flags.enabled), moduleSettings(flags.settings, m), prevModule)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't load collectors of module %s: %v", name, err)
+		}
+		moduleCollectors[name] = mc
+		names = append(names, keys(mc)...)
+	}
+
+	set, err := parseCollectorSettings(names, flags)
+	if err != nil {
+		return nil, err
+	}
+	// The filters parsed by parseCollectorSettings are forked for the top
+	// level and every module, so they are only parsed once.
+	rules := set.filters
+	set.collectors = collectors
+	set.filters = forkSeriesFilters(rules, prevFilters)
+	set.modules = make(map[string]*collectorSet, len(moduleCollectors))
+	for name, mc := range moduleCollectors {
+		var prevModuleFilters map[string][]*seriesFilter
+		if pm, ok := prevModules[name]; ok {
+			prevModuleFilters = pm.filters
+		}
+		set.modules[name] = set.moduleSet(mc, forkSeriesFilters(rules, prevModuleFilters), flags.modules[name].Timeout)
+	}
+	return set, nil
+}
+
+// moduleSet returns a set of the collectors of a scrape module, sharing the
+// settings of s, but caching, filtering, relabeling and limiting the series
+// of the collectors on its own, so they are counted apart from those of s.
+// filters holds the series filters of the module, forked from those of s.
+func (s *collectorSet) moduleSet(collectors map[string]collector.Collector, filters map[string][]*seriesFilter, timeout time.Duration) *collectorSet {
+	return &collectorSet{
+		collectors:     collectors,
+		timeouts:       s.timeouts,
+		cache:          newCollectorCache(s.cache.ttls),
+		filters:        filters,
+		relabeler:      s.relabeler.Fork(),
+		limiter:        newSeriesLimiter(s.limiter.limits, s.limiter.defaultLimit, s.limiter.totalLimit),
+		defaultTimeout: timeout,
+	}
+}
+
+// module returns the set of the named scrape module, or s itself if name is
+// empty.
+func (s *collectorSet) module(name string) (*collectorSet, error) {
+	if name == "" {
+		return s, nil
+	}
+	m, ok := s.modules[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown module: %s", name)
+	}
+	return m, nil
+}
+
+// moduleEnabled returns the collectors enabled by a module, or enabled if it
+// sets none.
+func moduleEnabled(m config.Module, enabled string) string {
+	if values := m.Flags["collectors.enabled"]; len(values) > 0 {
+		return values[0]
+	}
+	return enabled
+}
+
+// moduleSettings returns settings with the values of the flags set by a
+// module overriding them. collectors.enabled is left alone, as it selects
+// collectors rather than configuring them. The perflib objects read for the
+// collectors of the module add to those of the others.
+func moduleSettings(settings collector.Settings, m config.Module) collector.Settings {
+	flags := make(map[string]string, len(settings.Flags)+len(m.Flags))
+	for name, value := range settings.Flags {
+		flags[name] = value
+	}
+	for name, items := range m.Flags {
+		if name != "collectors.enabled" && len(items) > 0 {
+			flags[name] = items[len(items)-1]
+		}
+	}
+	return collector.Settings{Flags: flags, MergePerfCounterDependencies: true}
+}
+
+// parseCollectorSettings parses the per-collector timeouts, cache TTLs,
+// filters, relabeling rules and series limits into a set without collectors,
+// and checks that they name enabled collectors.
+func parseCollectorSettings(enabled []string, flags collectorFlags) (*collectorSet, error) {
+	isEnabled := make(map[string]bool, len(enabled))
+	for _, name := range enabled {
+		isEnabled[name] = true
+	}
+
+	timeouts, err := config.ParseCollectorDurations(flags.timeouts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid collector timeout: %v", err)
+	}
+	for name := range timeouts {
+		if !isEnabled[name] {
+			return nil, fmt.Errorf("timeout set for collector %s, which is not enabled", name)
+		}
+	}
+
+	cacheTTLs, err := config.ParseCollectorDurations(flags.cacheTTLs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache TTL: %v", err)
+	}
+	for name := range cacheTTLs {
+		if !isEnabled[name] {
+			return nil, fmt.Errorf("cache TTL set for collector %s, which is not enabled", name)
+		}
+	}
+
+	filters, err := parseSeriesFilters(flags.filters)
+	if err != nil {
+		return nil, fmt.Errorf("invalid collector filter: %v", err)
+	}
+	for name := range filters {
+		if !isEnabled[name] {
+			return nil, fmt.Errorf("filter set for collector %s, which is not enabled", name)
+		}
+	}
+
+	var relabeler *relabel.Relabeler
+	if flags.relabelFile != "" {
+		c, err := relabel.LoadFile(flags.relabelFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid relabeling configuration: %v", err)
+		}
+		for _, name := range c.Names() {
+			if !isEnabled[name] {
+				return nil, fmt.Errorf("relabeling rules set for collector %s, which is not enabled", name)
+			}
+		}
+		relabeler = relabel.New(c)
+	}
+
+	limits, err := config.ParseCollectorLimits(flags.seriesLimits)
+	if err != nil {
+		return nil, fmt.Errorf("invalid series limit: %v", err)
+	}
+	for name := range limits {
+		if !isEnabled[name] {
+			return nil, fmt.Errorf("series limit set for collector %s, which is not enabled", name)
+		}
+	}
+	if flags.defaultSeriesLimit < 0 {
+		return nil, fmt.Errorf("invalid default series limit %d", flags.defaultSeriesLimit)
+	}
+	if flags.totalSeriesLimit < 0 {
+		return nil, fmt.Errorf("invalid total series limit %d", flags.totalSeriesLimit)
+	}
+
+	return &collectorSet{
+		timeouts:  timeouts,
+		cache:     newCollectorCache(cacheTTLs),
+		filters:   filters,
+		relabeler: relabeler,
+		limiter:   newSeriesLimiter(limits, flags.defaultSeriesLimit, flags.totalSeriesLimit),
+	}, nil
+}
+
+// checkConfig checks that the flags name available collectors, and that the
+// settings of collectors are valid, without building any collector.
+func checkConfig(flags collectorFlags) error {
+	available := make(map[string]bool)
+	for _, name := range collector.Available() {
+		available[name] = true
+	}
+	names := expandEnabledCollectors(flags.enabled)
+	for _, name := range names {
+		if !available[name] {
+			return fmt.Errorf("unknown collector %q", name)
+		}
+	}
+	for module, m := range flags.modules {
+		for _, name := range expandEnabledCollectors(moduleEnabled(m, flags.enabled)) {
+			if !available[name] {
+				return fmt.Errorf("unknown collector %q in module %s", name, module)
+			}
+			names = append(names, name)
+		}
+	}
+	_, err := parseCollectorSettings(names, flags)
+	return err
+}
+
+// filter returns the requested collectors, or all if none is requested.
+func (s *collectorSet) filter(requestedCollectors []string) (map[string]collector.Collector, error) {
+	if len(requestedCollectors) == 0 {
+		return s.collectors, nil
+	}
+	filtered := make(map[string]collector.Collector)
+	for _, name := range requestedCollectors {
+		c, exists := s.collectors[name]
+		if !exists {
+			return nil, fmt.Errorf("unavailable collector: %s", name)
+		}
+		filtered[name] = c
+	}
+	return filtered, nil
+}
+
+// configLoader binds the configuration files, the environment and the
+// command line to the flags of an application.
+type configLoader struct {
+	app      *kingpin.Application
+	args     []string
+	environ  []string
+	files    []string
+	dir      string
+	defaults *config.Defaults
+}
+
+// loadedConfig holds the values of the flags of a loaded configuration.
+type loadedConfig struct {
+	values   config.Values
+	settings []config.Setting
+	modules  map[string]config.Module
+}
+
+// load parses the flags from scratch, with the values of the configuration
+// files and the environment as defaults. The values are parsed into a mirror
+// of the flags, so the flags of the application, which are read while
+// serving, are left untouched.
+func (l *configLoader) load() (*loadedConfig, error) {
+	files, err := config.ListFiles(l.files, l.dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not list config files: %v", err)
+	}
+	resolver, err := config.NewResolver(files...)
+	if err != nil {
+		return nil, fmt.Errorf("could not load config file: %v", err)
+	}
+	app, values := config.Mirror(l.app)
+	if err := resolver.LoadEnv(app, l.environ); err != nil {
+		return nil, err
+	}
+	if err := resolver.Bind(app, l.args); err != nil {
+		return nil, err
+	}
+	if _, err := app.Parse(l.args); err != nil {
+		return nil, err
+	}
+	if err := values.Validate(); err != nil {
+		return nil, err
+	}
+	return &loadedConfig{
+		values:   values,
+		settings: resolver.Settings(app),
+		modules:  resolver.Modules(),
+	}, nil
+}
+
+// apply sets the flags of the application to the values of c. The flags are
+// read without synchronisation, so this is only done at startup.
+func (l *configLoader) apply(c *loadedConfig) error {
+	// Start over, so repeatable flags are not parsed twice.
+	l.defaults.Restore(l.app)
+	for name, values := range c.values {
+		if f := l.app.GetFlag(name); f != nil {
+			f.Default(values...)
+		}
+	}
+	_, err := l.app.Parse(nil)
+	return err
+}
+
+// state returns a summary of the configuration files, which changes when a
+// file is changed, added or removed.
+func (l *configLoader) state() string {
+	files, err := config.ListFiles(l.files, l.dir)
+	if err != nil {
+		return err.Error()
+	}
+	var b strings.Builder
+	for _, f := range files {
+		fmt.Fprintf(&b, "%s=%s;", f, statFile(f))
+	}
+	return b.String()
+}
+
+// configReloader rereads the configuration files and flags, and rebuilds the
+// collectors from them. Settings outside of the collectors, such as the
+// listen address, keep the values they had at startup.
+type configReloader struct {
+	loader *configLoader
+	// build builds the collector set of c. prev is the set in use, nil at
+	// startup.
+	build func(c *loadedConfig, prev *collectorSet) (*collectorSet, error)
+
+	// reloadMtx serialises reloads, so the settings shown match the current
+	// collector set.
+	reloadMtx sync.Mutex
+
+	mtx         sync.RWMutex
+	current     *collectorSet
+	settings    []config.Setting
+	lastSuccess bool
+	lastTime    time.Time
+}
+
+// newConfigReloader builds the initial collector set from the configuration
+// already loaded by loader.
+func newConfigReloader(loader *configLoader, c *loadedConfig, build func(c *loadedConfig, prev *collectorSet) (*collectorSet, error)) (*configReloader, error) {
+	set, err := build(c, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &configReloader{
+		loader:      loader,
+		build:       build,
+		current:     set,
+		settings:    c.settings,
+		lastSuccess: true,
+		lastTime:    time.Now(),
+	}, nil
+}
+
+// collectors returns the current collector set.
+func (r *configReloader) collectors() *collectorSet {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.current
+}
+
+// reload rebuilds the collector set from the configuration, keeping the
+// collectors whose settings did not change. The previous set stays in use if
+// the configuration is invalid.
+func (r *configReloader) reload() error {
+	r.reloadMtx.Lock()
+	defer r.reloadMtx.Unlock()
+
+	c, err := r.loader.load()
+	var set *collectorSet
+	if err == nil {
+		// current is only replaced while reloadMtx is held.
+		set, err = r.build(c, r.current)
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.lastSuccess = err == nil
+	if err != nil {
+		log.Errorf("Failed to reload configuration, keeping the previous one: %v", err)
+		return err
+	}
+	r.current = set
+	r.settings = c.settings
+	r.lastTime = time.Now()
+	log.Infof("Reloaded configuration, enabled collectors: %v", keys(set.collectors))
+	return nil
+}
+
+// watch reloads the configuration whenever a configuration file changes,
+// checking every interval.
+func (r *configReloader) watch(interval time.Duration) {
+	last := r.loader.state()
+	for range time.Tick(interval) {
+		if s := r.loader.state(); s != last {
+			last = s
+			log.Infof("Configuration files changed")
+			_ = r.reload()
+		}
+	}
+}
+
+// statFile returns a summary of the modification time and size of a file.
+func statFile(path string) string {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", fi.ModTime().UnixNano(), fi.Size())
+}
+
+// ServeHTTP reloads the configuration on POST and PUT requests.
+func (r *configReloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost && req.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "Only POST or PUT requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.reload(); err != nil {
+		http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+	}
+}
+
+// serveConfig renders the effective configuration, with the source of every
+// value.
+func (r *configReloader) serveConfig(w http.ResponseWriter, req *http.Request) {
+	r.mtx.RLock()
+	settings := r.settings
+	r.mtx.RUnlock()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write(config.Render(settings))
+}
+
+func (r *configReloader) Describe(ch chan<- *prometheus.Desc) {
+	ch <- reloadSuccessDesc
+	ch <- reloadTimestampDesc
+	ch <- configInfoDesc
+	ch <- valueSourceDesc
+}
+
+func (r *configReloader) Collect(ch chan<- prometheus.Metric) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	ch <- prometheus.MustNewConstMetric(reloadSuccessDesc, prometheus.GaugeValue, boolToFloat(r.lastSuccess))
+	ch <- prometheus.MustNewConstMetric(reloadTimestampDesc, prometheus.GaugeValue, float64(r.lastTime.UnixNano())/1e9)
+	ch <- prometheus.MustNewConstMetric(configInfoDesc, prometheus.GaugeValue, 1, config.Hash(r.settings))
+	for _, s := range r.settings {
+		if s.Source.Kind == config.SourceFile {
+			ch <- prometheus.MustNewConstMetric(valueSourceDesc, prometheus.GaugeValue, 1, s.Flag, s.Source.Name)
+		}
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1.0
+	}
+	return 0.0
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/prometheus-community/windows_exporter/collector"
+	"github.com/prometheus-community/windows_exporter/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func TestConfigReloader(t *testing.T) {
+	file, err := ioutil.TempFile("", "windows_exporter_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	writeConfig := func(content string) {
+		if err := ioutil.WriteFile(file.Name(), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app := kingpin.New("test", "")
+	enabled := app.Flag("collectors.enabled", "").Default("cpu").String()
+	defaults := config.SaveDefaults(app)
+	if _, err := app.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	loader := &configLoader{app: app, files: []string{file.Name()}, defaults: defaults}
+	writeConfig("collectors:\n  enabled: cpu\n")
+	c, err := loader.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := newConfigReloader(loader, c, func(c *loadedConfig, prev *collectorSet) (*collectorSet, error) {
+		return &collectorSet{collectors: map[string]collector.Collector{c.values.Get("collectors.enabled"): nil}}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeConfig("collectors:\n  enabled: os\n")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/-/reload", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the reload to succeed, got %d: %s", rec.Code, rec.Body)
+	}
+	if _, ok := r.collectors().collectors["os"]; !ok || !r.lastSuccess {
+		t.Errorf("expected the reloaded collectors, got %v", r.collectors().collectors)
+	}
+	if *enabled != "cpu" {
+		t.Errorf("expected the reload to leave the flags alone, got %q", *enabled)
+	}
+
+	writeConfig("collectors: [\n")
+	if err := r.reload(); err == nil {
+		t.Error("expected an invalid configuration to fail the reload")
+	}
+	if _, ok := r.collectors().collectors["os"]; !ok || r.lastSuccess {
+		t.Errorf("expected the previous collectors to be kept, got %v", r.collectors().collectors)
+	}
+	if *enabled != "cpu" {
+		t.Errorf("expected a failed reload to leave the flags alone, got %q", *enabled)
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/-/reload", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected GET to be refused, got %d", rec.Code)
+	}
+}
+
+func TestConfigReloaderDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "windows_exporter_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	app := kingpin.New("test", "")
+	app.Flag("collectors.enabled", "").Default("cpu").String()
+	loader := &configLoader{app: app, dir: dir, defaults: config.SaveDefaults(app)}
+	c, err := loader.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := newConfigReloader(loader, c, func(c *loadedConfig, prev *collectorSet) (*collectorSet, error) {
+		return &collectorSet{collectors: map[string]collector.Collector{c.values.Get("collectors.enabled"): nil}}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := loader.state()
+	fragment := filepath.Join(dir, "10-os.yml")
+	if err := ioutil.WriteFile(fragment, []byte("collectors:\n  enabled: os\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if loader.state() == state {
+		t.Error("expected a new file to change the state of the configuration")
+	}
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.collectors().collectors["os"]; !ok {
+		t.Errorf("expected the collectors of the new file, got %v", r.collectors().collectors)
+	}
+
+	expected := fmt.Sprintf(`# HELP windows_exporter_config_value_source_info windows_exporter: The configuration file supplying the value of a flag. Flags not set by a file are omitted.
+# TYPE windows_exporter_config_value_source_info gauge
+windows_exporter_config_value_source_info{file=%q,flag="collectors.enabled"} 1
+`, fragment)
+	if err := testutil.CollectAndCompare(r, strings.NewReader(expected), "windows_exporter_config_value_source_info"); err != nil {
+		t.Error(err)
+	}
+
+	rec := httptest.NewRecorder()
+	r.serveConfig(rec, httptest.NewRequest(http.MethodGet, "/config", nil))
+	if want := "collectors:\n  enabled: os # configuration file " + fragment + "\n"; rec.Body.String() != want {
+		t.Errorf("expected /config to render\n%s\ngot\n%s", want, rec.Body)
+	}
+	expected = fmt.Sprintf(`# HELP windows_exporter_config_info windows_exporter: A hash of the effective configuration, as shown on /config.
+# TYPE windows_exporter_config_info gauge
+windows_exporter_config_info{hash=%q} 1
+`, config.Hash(r.settings))
+	if err := testutil.CollectAndCompare(r, strings.NewReader(expected), "windows_exporter_config_info"); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestConfigKeys checks that every flag can be set from a configuration
+// file, and that every setting of the file is a flag.
+func TestConfigKeys(t *testing.T) {
+	// Flags which cannot be set from a configuration file.
+	notInFile := map[string]bool{
+		// The configuration files themselves.
+		"config.file":  true,
+		"config.dir":   true,
+		"config.check": true,
+		// Flags of kingpin.
+		"help":                   true,
+		"help-long":              true,
+		"help-man":               true,
+		"version":                true,
+		"completion-bash":        true,
+		"completion-script-bash": true,
+		"completion-script-zsh":  true,
+	}
+	// The flags of the logger are added to the command line by main.
+	logApp := kingpin.New("test", "")
+	log.AddFlags(logApp)
+
+	flags := map[string]bool{}
+	for _, app := range []*kingpin.Application{kingpin.CommandLine, logApp} {
+		for _, f := range app.Model().Flags {
+			if !notInFile[f.Name] {
+				flags[f.Name] = true
+			}
+		}
+	}
+	var missing, unknown []string
+	for _, key := range config.Keys() {
+		if !flags[key] {
+			unknown = append(unknown, key)
+		}
+		delete(flags, key)
+	}
+	for name := range flags {
+		missing = append(missing, name)
+	}
+	sort.Strings(missing)
+	if len(missing) > 0 {
+		t.Errorf("flags missing from the configuration file: %v", missing)
+	}
+	if len(unknown) > 0 {
+		t.Errorf("settings of the configuration file without a flag: %v", unknown)
+	}
+}
+
+func TestConfigLoaderApply(t *testing.T) {
+	app := kingpin.New("test", "")
+	enabled := app.Flag("collectors.enabled", "").Default("cpu").String()
+	timeouts := config.Strings(app.Flag("scrape.collector-timeout", ""))
+	whitelist := app.Flag("collector.process.whitelist", "").Default(".+").String()
+	defaults := config.SaveDefaults(app)
+	args := []string{"--scrape.collector-timeout=os=5s", "--collector.process.whitelist=sqlservr"}
+	if _, err := app.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	loader := &configLoader{app: app, args: args, environ: []string{"WINDOWS_EXPORTER_COLLECTORS_ENABLED=os"}, defaults: defaults}
+	c, err := loader.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.values.Get("collectors.enabled"); got != "os" || *enabled != "cpu" {
+		t.Errorf("expected the value to be loaded without setting the flag, got %q and %q", got, *enabled)
+	}
+	if err := loader.apply(c); err != nil {
+		t.Fatal(err)
+	}
+	if *enabled != "os" || *whitelist != "sqlservr" || len(*timeouts) != 1 || (*timeouts)[0] != "os=5s" {
+		t.Errorf("expected the loaded values to be applied once, got %q, %q and %q", *enabled, *whitelist, *timeouts)
+	}
+
+	loader.args = []string{"--collector.process.whitelist=(foo"}
+	if _, err := loader.load(); err == nil {
+		t.Error("expected an error for an invalid regular expression")
+	}
+	if *whitelist != "sqlservr" {
+		t.Errorf("expected a failed load to leave the flags alone, got %q", *whitelist)
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	if err := checkConfig(collectorFlags{enabled: "cpu,os", timeouts: []string{"os=5s"}, filters: []string{"os:product!~foo"}}); err != nil {
+		t.Errorf("expected a valid configuration, got %v", err)
+	}
+	if err := checkConfig(collectorFlags{enabled: "cpu,ops"}); err == nil {
+		t.Error("expected an error for an unknown collector")
+	}
+	if err := checkConfig(collectorFlags{enabled: "cpu", cacheTTLs: []string{"os=5s"}}); err == nil {
+		t.Error("expected an error for a cache TTL of a disabled collector")
+	}
+
+	dir, err := ioutil.TempDir("", "relabel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	relabelFile := filepath.Join(dir, "relabel.yml")
+	if err := ioutil.WriteFile(relabelFile, []byte("collectors:\n  os:\n    - action: labeldrop\n      regex: product\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkConfig(collectorFlags{enabled: "cpu,os", relabelFile: relabelFile}); err != nil {
+		t.Errorf("expected valid relabeling rules, got %v", err)
+	}
+	if err := checkConfig(collectorFlags{enabled: "cpu", relabelFile: relabelFile}); err == nil {
+		t.Error("expected an error for relabeling rules of a disabled collector")
+	}
+	if err := checkConfig(collectorFlags{enabled: "cpu", seriesLimits: []string{"process=5000"}}); err == nil {
+		t.Error("expected an error for a series limit of a disabled collector")
+	}
+	modules := map[string]config.Module{
+		"sql": {Flags: map[string][]string{"collectors.enabled": {"cpu,process"}}},
+	}
+	if err := checkConfig(collectorFlags{enabled: "cpu", seriesLimits: []string{"process=5000"}, modules: modules}); err != nil {
+		t.Errorf("expected a series limit of a collector of a module to be valid, got %v", err)
+	}
+	modules["sql"].Flags["collectors.enabled"] = []string{"cpu,mssq"}
+	if err := checkConfig(collectorFlags{enabled: "cpu", modules: modules}); err == nil {
+		t.Error("expected an error for an unknown collector of a module")
+	}
+
+	if err := checkConfig(collectorFlags{enabled: "cpu", filters: []string{"cpu:core=~(0"}}); err == nil {
+		t.Error("expected an error for an invalid filter")
+	}
+}
+
+func TestModuleSettings(t *testing.T) {
+	settings := collector.Settings{Flags: map[string]string{
+		"collectors.enabled":          "cpu",
+		"collector.process.whitelist": ".+",
+		"collector.process.blacklist": "svchost",
+	}}
+	m := config.Module{Flags: map[string][]string{
+		"collectors.enabled":          {"process"},
+		"collector.process.whitelist": {"sqlservr"},
+	}}
+	got := moduleSettings(settings, m).Flags
+	if got["collectors.enabled"] != "cpu" || got["collector.process.whitelist"] != "sqlservr" || got["collector.process.blacklist"] != "svchost" {
+		t.Errorf("expected the values of the module over the others, got %v", got)
+	}
+	if settings.Flags["collector.process.whitelist"] != ".+" {
+		t.Errorf("expected the settings to be left alone, got %v", settings.Flags)
+	}
+	if !moduleSettings(settings, m).MergePerfCounterDependencies || settings.MergePerfCounterDependencies {
+		t.Error("expected the perflib objects of the module to add to the others")
+	}
+
+	set := &collectorSet{modules: map[string]*collectorSet{"sql": {}}}
+	if m, err := set.module(""); err != nil || m != set {
+		t.Errorf("expected the set itself without a module, got %v", err)
+	}
+	if _, err := set.module("iis"); err == nil {
+		t.Error("expected an error for an unknown module")
+	}
+}
+
+func TestBuildCollectorSetModules(t *testing.T) {
+	set, err := buildCollectorSet(collectorFlags{
+		enabled: "os",
+		filters: []string{"os:product!~foo"},
+		modules: map[string]config.Module{"sql": {}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := set.modules["sql"]
+	if _, ok := m.collectors["os"]; !ok {
+		t.Fatalf("expected the collectors of the module, got %v", m.collectors)
+	}
+	if len(m.filters["os"]) != 1 || m.filters["os"][0] == set.filters["os"][0] {
+		t.Errorf("expected the module to have filters of its own, got %v", m.filters)
+	}
+
+	reloaded, err := buildCollectorSet(collectorFlags{
+		enabled: "os,service",
+		modules: map[string]config.Module{"sql": {}},
+	}, set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.collectors["os"] != set.collectors["os"] || reloaded.modules["sql"].collectors["os"] != m.collectors["os"] {
+		t.Error("expected the unchanged collectors to be kept")
+	}
+	if _, ok := reloaded.collectors["service"]; !ok {
+		t.Errorf("expected the newly enabled collector, got %v", reloaded.collectors)
+	}
+}
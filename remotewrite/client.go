@@ -0,0 +1,243 @@
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus-community/windows_exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/version"
+)
+
+const maxErrMsgLen = 256
+
+// Client sends encoded batches to a remote_write endpoint.
+type Client struct {
+	URL     string
+	Timeout time.Duration
+	// Basic authentication is used if Username is set, bearer token
+	// authentication if BearerToken is set.
+	Username    string
+	Password    string
+	BearerToken string
+
+	HTTPClient *http.Client
+}
+
+// recoverableError is an error after which the request may be retried.
+type recoverableError struct {
+	error
+}
+
+// Send posts a single batch.
+func (c *Client) Send(ctx context.Context, batch []byte) error {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequest("POST", c.URL, bytes.NewReader(batch))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "windows_exporter/"+version.Version)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	} else if c.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.BearerToken)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		// Network errors are assumed to be temporary.
+		return recoverableError{err}
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrMsgLen))
+	err = fmt.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(body))
+	if resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests {
+		return recoverableError{err}
+	}
+	return err
+}
+
+var (
+	sentBatchesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "push_sent_batches_total"),
+		"windows_exporter: Number of batches accepted by the remote_write endpoint.",
+		nil,
+		nil,
+	)
+	failedRequestsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "push_failed_requests_total"),
+		"windows_exporter: Number of remote_write requests which failed, including retries.",
+		nil,
+		nil,
+	)
+	droppedBatchesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "push_dropped_batches_total"),
+		"windows_exporter: Number of batches dropped, because the queue was full, or the endpoint rejected them.",
+		[]string{"reason"},
+		nil,
+	)
+	queuedBatchesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "push_queued_batches"),
+		"windows_exporter: Number of batches waiting to be sent.",
+		nil,
+		nil,
+	)
+	queuedBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "push_queued_bytes"),
+		"windows_exporter: Size of the batches waiting to be sent.",
+		nil,
+		nil,
+	)
+	lastSuccessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "push_last_success_timestamp_seconds"),
+		"windows_exporter: Unix time of the last batch accepted by the remote_write endpoint.",
+		nil,
+		nil,
+	)
+)
+
+// Sender sends the batches of a Queue in order. Requests failing with a
+// recoverable error are retried with exponential backoff. It is also a
+// prometheus.Collector exposing its own state.
+type Sender struct {
+	client     *Client
+	queue      *Queue
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	wake       chan struct{}
+
+	mtx         sync.Mutex
+	sent        float64
+	failed      float64
+	rejected    float64
+	lastSuccess time.Time
+}
+
+// NewSender returns a Sender of the batches in queue.
+func NewSender(client *Client, queue *Queue, minBackoff, maxBackoff time.Duration) *Sender {
+	return &Sender{
+		client:     client,
+		queue:      queue,
+		minBackoff: minBackoff,
+		maxBackoff: maxBackoff,
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// Enqueue queues a batch, and wakes up the sender.
+func (s *Sender) Enqueue(batch []byte) error {
+	err := s.queue.Push(batch)
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+// Run sends queued batches until ctx is done.
+func (s *Sender) Run(ctx context.Context) {
+	backoff := s.minBackoff
+	for {
+		name, batch, ok, err := s.queue.Peek()
+		if err != nil {
+			log.Errorf("remote_write: %v", err)
+			continue
+		}
+		if !ok {
+			select {
+			case <-s.wake:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		err = s.client.Send(ctx, batch)
+		if ctx.Err() != nil {
+			return
+		}
+		if _, recoverable := err.(recoverableError); recoverable {
+			s.count(func() { s.failed++ })
+			log.Warnf("remote_write: failed to send batch, retrying in %s: %v", backoff, err)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			backoff *= 2
+			if backoff > s.maxBackoff {
+				backoff = s.maxBackoff
+			}
+			continue
+		}
+
+		backoff = s.minBackoff
+		if err != nil {
+			s.count(func() { s.failed++; s.rejected++ })
+			log.Errorf("remote_write: batch rejected, dropping it: %v", err)
+		} else {
+			s.count(func() { s.sent++; s.lastSuccess = time.Now() })
+		}
+		s.queue.Remove(name)
+	}
+}
+
+func (s *Sender) count(f func()) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	f()
+}
+
+// Describe implements prometheus.Collector.
+func (s *Sender) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sentBatchesDesc
+	ch <- failedRequestsDesc
+	ch <- droppedBatchesDesc
+	ch <- queuedBatchesDesc
+	ch <- queuedBytesDesc
+	ch <- lastSuccessDesc
+}
+
+// Collect implements prometheus.Collector.
+func (s *Sender) Collect(ch chan<- prometheus.Metric) {
+	s.mtx.Lock()
+	sent, failed, rejected, lastSuccess := s.sent, s.failed, s.rejected, s.lastSuccess
+	s.mtx.Unlock()
+	batches, size, dropped := s.queue.Stats()
+
+	ch <- prometheus.MustNewConstMetric(sentBatchesDesc, prometheus.CounterValue, sent)
+	ch <- prometheus.MustNewConstMetric(failedRequestsDesc, prometheus.CounterValue, failed)
+	ch <- prometheus.MustNewConstMetric(droppedBatchesDesc, prometheus.CounterValue, float64(dropped), "queue_full")
+	ch <- prometheus.MustNewConstMetric(droppedBatchesDesc, prometheus.CounterValue, rejected, "rejected")
+	ch <- prometheus.MustNewConstMetric(queuedBatchesDesc, prometheus.GaugeValue, float64(batches))
+	ch <- prometheus.MustNewConstMetric(queuedBytesDesc, prometheus.GaugeValue, float64(size))
+	if !lastSuccess.IsZero() {
+		ch <- prometheus.MustNewConstMetric(lastSuccessDesc, prometheus.GaugeValue, float64(lastSuccess.UnixNano())/1e9)
+	}
+}
@@ -0,0 +1,159 @@
+// Package remotewrite ships gathered metrics to a Prometheus remote_write
+// endpoint. Batches are queued on disk, so samples survive outages of the
+// receiver, up to a limit on the size of the queue.
+package remotewrite
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/snappy"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Label is a label of a time series.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is a value of a time series at a point in time.
+type Sample struct {
+	Value float64
+	// Timestamp in milliseconds since the Unix epoch.
+	Timestamp int64
+}
+
+// TimeSeries is a series of samples identified by its labels, including the
+// metric name in the __name__ label.
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// ToTimeSeries converts metric families into time series. Summaries and
+// histograms are split into their sample series, as in the text format.
+// Metrics without a timestamp are stamped with ts.
+func ToTimeSeries(mfs []*dto.MetricFamily, ts time.Time) []TimeSeries {
+	defaultTimestamp := ts.UnixNano() / int64(time.Millisecond)
+	var series []TimeSeries
+	for _, mf := range mfs {
+		name := mf.GetName()
+		for _, m := range mf.GetMetric() {
+			timestamp := defaultTimestamp
+			if m.TimestampMs != nil {
+				timestamp = m.GetTimestampMs()
+			}
+			add := func(suffix string, value float64, extra ...Label) {
+				labels := make([]Label, 0, len(m.GetLabel())+len(extra)+1)
+				labels = append(labels, Label{Name: "__name__", Value: name + suffix})
+				for _, l := range m.GetLabel() {
+					labels = append(labels, Label{Name: l.GetName(), Value: l.GetValue()})
+				}
+				labels = append(labels, extra...)
+				sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+				series = append(series, TimeSeries{
+					Labels:  labels,
+					Samples: []Sample{{Value: value, Timestamp: timestamp}},
+				})
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add("", m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add("", q.GetValue(), Label{Name: "quantile", Value: formatFloat(q.GetQuantile())})
+				}
+				add("_sum", s.GetSampleSum())
+				add("_count", float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				infSeen := false
+				for _, b := range h.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), +1) {
+						infSeen = true
+					}
+					add("_bucket", float64(b.GetCumulativeCount()), Label{Name: "le", Value: formatFloat(b.GetUpperBound())})
+				}
+				if !infSeen {
+					add("_bucket", float64(h.GetSampleCount()), Label{Name: "le", Value: "+Inf"})
+				}
+				add("_sum", h.GetSampleSum())
+				add("_count", float64(h.GetSampleCount()))
+			}
+		}
+	}
+	return series
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, +1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Field numbers of the remote_write protobuf messages.
+const (
+	writeRequestTimeseries = 1
+	timeSeriesLabels       = 1
+	timeSeriesSamples      = 2
+	labelName              = 1
+	labelValue             = 2
+	sampleValue            = 1
+	sampleTimestamp        = 2
+)
+
+// Marshal returns the protobuf encoding of a remote_write WriteRequest holding
+// series.
+func Marshal(series []TimeSeries) []byte {
+	var b []byte
+	for _, ts := range series {
+		b = protowire.AppendTag(b, writeRequestTimeseries, protowire.BytesType)
+		b = protowire.AppendBytes(b, marshalTimeSeries(ts))
+	}
+	return b
+}
+
+func marshalTimeSeries(ts TimeSeries) []byte {
+	var b []byte
+	for _, l := range ts.Labels {
+		var lb []byte
+		lb = protowire.AppendTag(lb, labelName, protowire.BytesType)
+		lb = protowire.AppendString(lb, l.Name)
+		lb = protowire.AppendTag(lb, labelValue, protowire.BytesType)
+		lb = protowire.AppendString(lb, l.Value)
+		b = protowire.AppendTag(b, timeSeriesLabels, protowire.BytesType)
+		b = protowire.AppendBytes(b, lb)
+	}
+	for _, s := range ts.Samples {
+		var sb []byte
+		sb = protowire.AppendTag(sb, sampleValue, protowire.Fixed64Type)
+		sb = protowire.AppendFixed64(sb, math.Float64bits(s.Value))
+		sb = protowire.AppendTag(sb, sampleTimestamp, protowire.VarintType)
+		sb = protowire.AppendVarint(sb, uint64(s.Timestamp))
+		b = protowire.AppendTag(b, timeSeriesSamples, protowire.BytesType)
+		b = protowire.AppendBytes(b, sb)
+	}
+	return b
+}
+
+// Encode returns the snappy-compressed WriteRequest holding the metric
+// families, as sent in the body of a remote_write request, and the number of
+// samples in it.
+func Encode(mfs []*dto.MetricFamily, ts time.Time) ([]byte, int) {
+	series := ToTimeSeries(mfs, ts)
+	return snappy.Encode(nil, Marshal(series)), len(series)
+}
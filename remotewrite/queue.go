@@ -0,0 +1,158 @@
+package remotewrite
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/common/log"
+)
+
+const queueFileSuffix = ".batch"
+
+// Queue is a FIFO of encoded batches, stored as one file per batch in a
+// directory. Batches left over from a previous run are sent first. Once the
+// total size exceeds the limit, the oldest batches are dropped.
+type Queue struct {
+	dir      string
+	maxBytes int64
+
+	mtx     sync.Mutex
+	files   []string
+	sizes   map[string]int64
+	size    int64
+	nextSeq uint64
+	dropped int
+}
+
+// OpenQueue opens the queue in dir, creating the directory if needed.
+func OpenQueue(dir string, maxBytes int64) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	q := &Queue{dir: dir, maxBytes: maxBytes, sizes: make(map[string]int64)}
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() && strings.HasSuffix(name, queueFileSuffix+".tmp") {
+			// Left over from a write interrupted by a previous run.
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				log.Warnf("Failed to remove partially written batch %s: %v", name, err)
+			}
+			continue
+		}
+		if e.IsDir() || !strings.HasSuffix(name, queueFileSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, queueFileSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		if seq >= q.nextSeq {
+			q.nextSeq = seq + 1
+		}
+		q.files = append(q.files, name)
+		q.sizes[name] = e.Size()
+		q.size += e.Size()
+	}
+	// Sequence numbers are zero-padded, so lexical order is queue order.
+	sort.Strings(q.files)
+	q.trim(0)
+	return q, nil
+}
+
+// trim drops the oldest batches until n more bytes fit into the queue. The
+// caller must hold mtx.
+func (q *Queue) trim(n int64) {
+	for len(q.files) > 0 && q.size+n > q.maxBytes {
+		q.remove(q.files[0])
+		q.dropped++
+	}
+}
+
+func (q *Queue) remove(name string) {
+	// The batch leaves the queue even if the file cannot be removed, so a stuck
+	// file cannot block it.
+	if err := os.Remove(filepath.Join(q.dir, name)); err != nil && !os.IsNotExist(err) {
+		log.Warnf("Failed to remove queued batch: %v", err)
+	}
+	for i, f := range q.files {
+		if f == name {
+			q.files = append(q.files[:i], q.files[i+1:]...)
+			break
+		}
+	}
+	q.size -= q.sizes[name]
+	delete(q.sizes, name)
+}
+
+// Push appends a batch. Batches larger than the limit are dropped.
+func (q *Queue) Push(batch []byte) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	n := int64(len(batch))
+	if n > q.maxBytes {
+		q.dropped++
+		return fmt.Errorf("batch of %d bytes exceeds the queue limit of %d bytes", n, q.maxBytes)
+	}
+	q.trim(n)
+
+	name := fmt.Sprintf("%020d%s", q.nextSeq, queueFileSuffix)
+	q.nextSeq++
+	path := filepath.Join(q.dir, name)
+	// Write to a temporary file first, so a crash never leaves partial batches.
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, batch, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	q.files = append(q.files, name)
+	q.sizes[name] = n
+	q.size += n
+	return nil
+}
+
+// Peek returns the oldest batch and its name, or false if the queue is empty.
+func (q *Queue) Peek() (string, []byte, bool, error) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if len(q.files) == 0 {
+		return "", nil, false, nil
+	}
+	name := q.files[0]
+	b, err := ioutil.ReadFile(filepath.Join(q.dir, name))
+	if err != nil {
+		// Drop unreadable batches, so they do not block the queue.
+		q.remove(name)
+		q.dropped++
+		return "", nil, false, fmt.Errorf("dropped unreadable batch %s: %v", name, err)
+	}
+	return name, b, true, nil
+}
+
+// Remove removes the named batch, if it was not dropped in the meantime.
+func (q *Queue) Remove(name string) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if _, ok := q.sizes[name]; ok {
+		q.remove(name)
+	}
+}
+
+// Stats returns the number of queued batches, their total size in bytes, and
+// the number of batches dropped so far.
+func (q *Queue) Stats() (int, int64, int) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	return len(q.files), q.size, q.dropped
+}
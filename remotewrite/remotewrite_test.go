@@ -0,0 +1,257 @@
+package remotewrite
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// unmarshal decodes a WriteRequest, as a receiver would.
+func unmarshal(t *testing.T, b []byte) []TimeSeries {
+	t.Helper()
+	var series []TimeSeries
+	forEachField(t, b, func(num protowire.Number, v []byte, _ uint64) {
+		var ts TimeSeries
+		forEachField(t, v, func(num protowire.Number, v []byte, _ uint64) {
+			switch num {
+			case timeSeriesLabels:
+				var l Label
+				forEachField(t, v, func(num protowire.Number, v []byte, _ uint64) {
+					if num == labelName {
+						l.Name = string(v)
+					} else {
+						l.Value = string(v)
+					}
+				})
+				ts.Labels = append(ts.Labels, l)
+			case timeSeriesSamples:
+				var s Sample
+				forEachField(t, v, func(num protowire.Number, _ []byte, n uint64) {
+					if num == sampleValue {
+						s.Value = math.Float64frombits(n)
+					} else {
+						s.Timestamp = int64(n)
+					}
+				})
+				ts.Samples = append(ts.Samples, s)
+			}
+		})
+		series = append(series, ts)
+	})
+	return series
+}
+
+func forEachField(t *testing.T, b []byte, f func(protowire.Number, []byte, uint64)) {
+	t.Helper()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			f(num, v, 0)
+			b = b[n:]
+		case protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			f(num, nil, v)
+			b = b[n:]
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			f(num, nil, v)
+			b = b[n:]
+		default:
+			t.Fatalf("unexpected wire type %v", typ)
+		}
+	}
+}
+
+func gather(t *testing.T) []*dto.MetricFamily {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_gauge", Help: "help"}, []string{"instance"})
+	gauge.WithLabelValues("a").Set(1.5)
+	hist := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_hist", Help: "help", Buckets: []float64{1}})
+	hist.Observe(0.5)
+	reg.MustRegister(gauge, hist)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mfs
+}
+
+func TestEncode(t *testing.T) {
+	ts := time.Unix(1600000000, 0)
+	body, samples := Encode(gather(t), ts)
+	b, err := snappy.Decode(nil, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := unmarshal(t, b)
+	if samples != len(got) {
+		t.Errorf("expected %d samples to be reported, got %d", len(got), samples)
+	}
+
+	ms := ts.UnixNano() / int64(time.Millisecond)
+	sample := func(v float64) []Sample { return []Sample{{Value: v, Timestamp: ms}} }
+	want := []TimeSeries{
+		{Labels: []Label{{"__name__", "test_gauge"}, {"instance", "a"}}, Samples: sample(1.5)},
+		{Labels: []Label{{"__name__", "test_hist_bucket"}, {"le", "1"}}, Samples: sample(1)},
+		{Labels: []Label{{"__name__", "test_hist_bucket"}, {"le", "+Inf"}}, Samples: sample(1)},
+		{Labels: []Label{{"__name__", "test_hist_sum"}}, Samples: sample(0.5)},
+		{Labels: []Label{{"__name__", "test_hist_count"}}, Samples: sample(1)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected\n%v\ngot\n%v", want, got)
+	}
+}
+
+func tempQueue(t *testing.T, maxBytes int64) (*Queue, string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "windows_exporter_queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := OpenQueue(dir, maxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q, dir
+}
+
+func TestQueueLimit(t *testing.T) {
+	q, dir := tempQueue(t, 10)
+	defer os.RemoveAll(dir)
+
+	for _, batch := range []string{"aaaa", "bbbb", "cccc"} {
+		if err := q.Push([]byte(batch)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.Push(make([]byte, 11)); err == nil {
+		t.Error("expected a batch larger than the limit to be refused")
+	}
+	if n, size, dropped := q.Stats(); n != 2 || size != 8 || dropped != 2 {
+		t.Errorf("expected 2 batches of 8 bytes and 2 dropped, got %d, %d and %d", n, size, dropped)
+	}
+
+	// Reopening resumes with the oldest remaining batch, and removes batches
+	// whose write was interrupted.
+	tmp := filepath.Join(dir, "00000000000000000009"+queueFileSuffix+".tmp")
+	if err := ioutil.WriteFile(tmp, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	q, err := OpenQueue(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("expected the partially written batch to be removed, got %v", err)
+	}
+	name, b, ok, err := q.Peek()
+	if err != nil || !ok || string(b) != "bbbb" {
+		t.Fatalf("expected the oldest remaining batch, got %q, %v, %v", b, ok, err)
+	}
+	q.Remove(name)
+	if err := q.Push([]byte("dddd")); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"cccc", "dddd"} {
+		name, b, _, _ := q.Peek()
+		if string(b) != want {
+			t.Errorf("expected batch %q, got %q", want, b)
+		}
+		q.Remove(name)
+	}
+}
+
+func TestSenderRetries(t *testing.T) {
+	var (
+		mtx      sync.Mutex
+		received []string
+		attempts int
+	)
+	done := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		attempts++
+		if user, pass, _ := r.BasicAuth(); user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("Content-Encoding") != "snappy" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		switch string(body) {
+		case "retry":
+			// Fail the first two attempts.
+			if attempts <= 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		case "reject":
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received = append(received, string(body))
+		if string(body) == "last" {
+			close(done)
+		}
+	}))
+	defer receiver.Close()
+
+	q, dir := tempQueue(t, 1024)
+	defer os.RemoveAll(dir)
+	s := NewSender(&Client{URL: receiver.URL, Username: "user", Password: "pass"}, q, time.Millisecond, 4*time.Millisecond)
+	for _, batch := range []string{"retry", "reject", "last"} {
+		if err := s.Enqueue([]byte(batch)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the batches to be delivered")
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	if want := []string{"retry", "last"}; !reflect.DeepEqual(received, want) {
+		t.Errorf("expected %v to be received, got %v", want, received)
+	}
+	if attempts != 5 {
+		t.Errorf("expected 5 requests, got %d", attempts)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import "sync"
+
+// collectorRuns tracks the runs of collectors which are in flight, across
+// scrapes. A run which outlived its timeout is abandoned by the scrape, but
+// keeps its collector busy until it returns. New runs of that collector are
+// refused in the meantime, so hung queries cannot pile up. Runs of
+// overlapping scrapes are tracked individually, so any of them can be
+// abandoned.
+type collectorRuns struct {
+	mtx     sync.Mutex
+	nextID  uint64
+	running map[string]map[uint64]bool
+	hung    map[string]map[uint64]bool
+	skipped map[string]float64
+}
+
+func newCollectorRuns() *collectorRuns {
+	return &collectorRuns{
+		running: make(map[string]map[uint64]bool),
+		hung:    make(map[string]map[uint64]bool),
+		skipped: make(map[string]float64),
+	}
+}
+
+// start registers a new run of the named collector, and returns its id. It
+// returns false if a previous, abandoned run is still in flight.
+func (r *collectorRuns) start(name string) (uint64, bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if len(r.hung[name]) > 0 {
+		r.skipped[name]++
+		return 0, false
+	}
+	r.nextID++
+	if r.running[name] == nil {
+		r.running[name] = make(map[uint64]bool)
+	}
+	r.running[name][r.nextID] = true
+	return r.nextID, true
+}
+
+// abandon marks the run as timed out. The collector stays busy until the run
+// finishes.
+func (r *collectorRuns) abandon(name string, id uint64) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if !r.running[name][id] {
+		return
+	}
+	if r.hung[name] == nil {
+		r.hung[name] = make(map[uint64]bool)
+	}
+	r.hung[name][id] = true
+}
+
+// finish marks the run as returned.
+func (r *collectorRuns) finish(name string, id uint64) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	delete(r.running[name], id)
+	if len(r.running[name]) == 0 {
+		delete(r.running, name)
+	}
+	delete(r.hung[name], id)
+	if len(r.hung[name]) == 0 {
+		delete(r.hung, name)
+	}
+}
+
+// skippedTotal returns the number of runs of the named collector refused so far.
+func (r *collectorRuns) skippedTotal(name string) float64 {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.skipped[name]
+}
@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestCollectorRuns(t *testing.T) {
+	r := newCollectorRuns()
+
+	id, ok := r.start("os")
+	if !ok {
+		t.Fatal("expected the first run to start")
+	}
+	r.finish("os", id)
+	if _, ok := r.start("os"); !ok {
+		t.Fatal("expected a run to start after the previous one finished")
+	}
+
+	id, _ = r.start("cs")
+	r.abandon("cs", id)
+	if _, ok := r.start("cs"); ok {
+		t.Fatal("expected a run to be refused while an abandoned run is in flight")
+	}
+	if got := r.skippedTotal("cs"); got != 1 {
+		t.Errorf("expected 1 skipped run, got %v", got)
+	}
+	r.finish("cs", id)
+	if _, ok := r.start("cs"); !ok {
+		t.Fatal("expected a run to start once the abandoned run returned")
+	}
+}
+
+func TestCollectorRunsOverlapping(t *testing.T) {
+	r := newCollectorRuns()
+
+	first, _ := r.start("os")
+	second, ok := r.start("os")
+	if !ok {
+		t.Fatal("expected an overlapping run to start while no run is abandoned")
+	}
+
+	// The first run hangs, while the second returns in time.
+	r.abandon("os", first)
+	r.finish("os", second)
+	if _, ok := r.start("os"); ok {
+		t.Fatal("expected a run to be refused while the abandoned first run is in flight")
+	}
+
+	r.finish("os", first)
+	if _, ok := r.start("os"); !ok {
+		t.Fatal("expected a run to start once the abandoned run returned")
+	}
+}
@@ -0,0 +1,11 @@
+// +build !windows
+
+package main
+
+// initWbem does nothing, as there is no WMI outside of Windows. Collectors
+// only produce metrics there when replaying recordings.
+func initWbem() {}
+
+// startService does nothing, the exporter always runs in the foreground
+// outside of Windows.
+func startService(stopCh chan<- bool) {}
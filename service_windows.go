@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/StackExchange/wmi"
+	"github.com/prometheus/common/log"
+	"golang.org/x/sys/windows/svc"
+)
+
+func initWbem() {
+	// This initialization prevents a memory leak on WMF 5+. See
+	// https://github.com/prometheus-community/windows_exporter/issues/77 and
+	// linked issues for details.
+	log.Debugf("Initializing SWbemServices")
+	s, err := wmi.InitializeSWbemServices(wmi.DefaultClient)
+	if err != nil {
+		log.Fatal(err)
+	}
+	wmi.DefaultClient.AllowMissingFields = true
+	wmi.DefaultClient.SWbemServicesClient = s
+}
+
+// startService runs the exporter as a Windows service, unless it was started
+// from an interactive session. stopCh receives a value once the service is
+// asked to stop.
+func startService(stopCh chan<- bool) {
+	isInteractive, err := svc.IsAnInteractiveSession()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if isInteractive {
+		return
+	}
+	go func() {
+		if err := svc.Run(serviceName, &windowsExporterService{stopCh: stopCh}); err != nil {
+			log.Errorf("Failed to start service: %v", err)
+		}
+	}()
+}
+
+type windowsExporterService struct {
+	stopCh chan<- bool
+}
+
+func (s *windowsExporterService) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (ssec bool, errno uint32) {
+	const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown
+	changes <- svc.Status{State: svc.StartPending}
+	changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
+loop:
+	for {
+		select {
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				changes <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				s.stopCh <- true
+				break loop
+			default:
+				log.Error(fmt.Sprintf("unexpected control request #%d", c))
+			}
+		}
+	}
+	changes <- svc.Status{State: svc.StopPending}
+	return
+}
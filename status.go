@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus-community/windows_exporter/relabel"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// statusHistoryLength is the number of recent outcomes kept per collector.
+const statusHistoryLength = 20
+
+// Outcomes of the history of a collector.
+const (
+	historySuccess = "success"
+	historyFailure = "failure"
+	historyTimeout = "timeout"
+	historySkipped = "skipped"
+)
+
+// history returns the outcome as recorded in the history of a collector.
+func (o collectorOutcome) history() string {
+	switch o {
+	case success:
+		return historySuccess
+	case failed:
+		return historyFailure
+	case skipped:
+		return historySkipped
+	}
+	// The collector was still running when the scrape ended.
+	return historyTimeout
+}
+
+// collectorStatus records the recent runs of collectors, shared between
+// scrapes and reloads.
+type collectorStatus struct {
+	mtx      sync.Mutex
+	statuses map[statusKey]*runStatus
+	// descs holds the names of the metric families of descriptors.
+	descs relabel.DescCache
+}
+
+// statusKey identifies a collector of a scrape module, the module being empty
+// for the collectors of the top level. The collectors of modules are distinct
+// from the others, so their runs are recorded separately.
+type statusKey struct {
+	module string
+	name   string
+}
+
+// runStatus is the status of a single collector.
+type runStatus struct {
+	lastRun       time.Time
+	lastDuration  time.Duration
+	lastError     string
+	lastErrorTime time.Time
+	// history holds the most recent outcomes, oldest first.
+	history  []string
+	counts   map[string]int
+	families []string
+}
+
+func newCollectorStatus() *collectorStatus {
+	return &collectorStatus{statuses: make(map[statusKey]*runStatus)}
+}
+
+func (s *collectorStatus) get(module, name string) *runStatus {
+	key := statusKey{module: module, name: name}
+	st, ok := s.statuses[key]
+	if !ok {
+		st = &runStatus{counts: make(map[string]int)}
+		s.statuses[key] = st
+	}
+	return st
+}
+
+// ran records a run of the named collector of a module which returned,
+// possibly after its scrape timed out. descs holds a metric it sent of each
+// descriptor.
+func (s *collectorStatus) ran(module, name string, start time.Time, duration time.Duration, err error, descs map[*prometheus.Desc]prometheus.Metric) {
+	if s == nil {
+		return
+	}
+	families := make([]string, 0, len(descs))
+	seen := make(map[string]bool, len(descs))
+	for _, m := range descs {
+		family, _, ok := s.descs.Describe(m)
+		if !ok || seen[family] {
+			continue
+		}
+		seen[family] = true
+		families = append(families, family)
+	}
+	sort.Strings(families)
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	st := s.get(module, name)
+	st.lastRun = start
+	st.lastDuration = duration
+	st.families = families
+	if err != nil {
+		st.lastError = err.Error()
+		st.lastErrorTime = start.Add(duration)
+	}
+}
+
+// record adds the outcome of the named collector of a module in a scrape to
+// its history. Timeouts count as errors.
+func (s *collectorStatus) record(module, name string, outcome string, t time.Time) {
+	if s == nil {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	st := s.get(module, name)
+	st.history = append(st.history, outcome)
+	if len(st.history) > statusHistoryLength {
+		st.history = st.history[len(st.history)-statusHistoryLength:]
+	}
+	st.counts[outcome]++
+	if outcome == historyTimeout {
+		st.lastError = "timed out"
+		st.lastErrorTime = t
+	}
+}
+
+// collectorStatusJSON is the status of a collector as served on /collectors.
+type collectorStatusJSON struct {
+	Module              string     `json:"module,omitempty"`
+	Name                string     `json:"name"`
+	Enabled             bool       `json:"enabled"`
+	LastRun             *time.Time `json:"last_run,omitempty"`
+	LastDurationSeconds float64    `json:"last_duration_seconds"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastErrorTime       *time.Time `json:"last_error_time,omitempty"`
+	Successes           int        `json:"successes"`
+	Failures            int        `json:"failures"`
+	Timeouts            int        `json:"timeouts"`
+	Skipped             int        `json:"skipped"`
+	History             []string   `json:"history"`
+	MetricFamilies      []string   `json:"metric_families"`
+}
+
+// list returns the status of each of the available collectors, followed by
+// the collectors enabled by each scrape module, sorted by module and name.
+// enabled tells which collectors are enabled, by module; modules missing from
+// it are left out.
+func (s *collectorStatus) list(available []string, enabled map[string]map[string]bool) []collectorStatusJSON {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	keys := make([]statusKey, 0, len(available))
+	for _, name := range available {
+		keys = append(keys, statusKey{name: name})
+	}
+	for module, names := range enabled {
+		if module == "" {
+			continue
+		}
+		for name := range names {
+			keys = append(keys, statusKey{module: module, name: name})
+		}
+	}
+	list := make([]collectorStatusJSON, 0, len(keys))
+	for _, key := range keys {
+		c := collectorStatusJSON{
+			Module:         key.module,
+			Name:           key.name,
+			Enabled:        enabled[key.module][key.name],
+			History:        []string{},
+			MetricFamilies: []string{},
+		}
+		if st, ok := s.statuses[key]; ok {
+			if !st.lastRun.IsZero() {
+				lastRun := st.lastRun
+				c.LastRun = &lastRun
+			}
+			c.LastDurationSeconds = st.lastDuration.Seconds()
+			c.LastError = st.lastError
+			if !st.lastErrorTime.IsZero() {
+				lastErrorTime := st.lastErrorTime
+				c.LastErrorTime = &lastErrorTime
+			}
+			c.Successes = st.counts[historySuccess]
+			c.Failures = st.counts[historyFailure]
+			c.Timeouts = st.counts[historyTimeout]
+			c.Skipped = st.counts[historySkipped]
+			c.History = append(c.History, st.history...)
+			c.MetricFamilies = append(c.MetricFamilies, st.families...)
+		}
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Module != list[j].Module {
+			return list[i].Module < list[j].Module
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// serveStatus serves the status of the collectors returned by list as JSON.
+func serveStatus(list func() []collectorStatusJSON) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(list()); err != nil {
+			http.Error(w, fmt.Sprintf("error encoding JSON: %s", err), http.StatusInternalServerError)
+		}
+	}
+}
+
+var statusTable = template.Must(template.New("collectors").Funcs(template.FuncMap{
+	"time": func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	},
+}).Parse(`<h2>Collectors</h2>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Module</th><th>Collector</th><th>Enabled</th><th>Last run</th><th>Duration (s)</th><th>Last error</th><th>Successes</th><th>Failures</th><th>Timeouts</th><th>Skipped</th><th>History</th><th>Metric families</th></tr>
+{{range .}}<tr><td>{{.Module}}</td><td>{{.Name}}</td><td>{{if .Enabled}}yes{{else}}no{{end}}</td><td>{{time .LastRun}}</td><td>{{if .LastRun}}{{printf "%.3f" .LastDurationSeconds}}{{end}}</td><td>{{if .LastError}}{{time .LastErrorTime}}: {{.LastError}}{{end}}</td><td>{{.Successes}}</td><td>{{.Failures}}</td><td>{{.Timeouts}}</td><td>{{.Skipped}}</td><td>{{range .History}}{{if eq . "success"}}+{{else if eq . "failure"}}x{{else if eq . "timeout"}}t{{else}}s{{end}}{{end}}</td><td>{{range $i, $f := .MetricFamilies}}{{if $i}}<br>{{end}}{{$f}}{{end}}</td></tr>
+{{end}}</table>
+<p>History, oldest first: + success, x failure, t timeout, s skipped.</p>
+`))
+
+// writeStatusTable renders the status of collectors as an HTML table.
+func writeStatusTable(w io.Writer, list []collectorStatusJSON) error {
+	return statusTable.Execute(w, list)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus-community/windows_exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollectorStatus(t *testing.T) {
+	defaultSource := collector.DefaultPerflibSource
+	collector.DefaultPerflibSource = collector.NewFixturePerflibSource()
+	defer func() { collector.DefaultPerflibSource = defaultSource }()
+
+	counting := &countingCollector{}
+	status := newCollectorStatus()
+	coll := windowsCollector{
+		maxScrapeDuration: time.Minute,
+		collectors: map[string]collector.Collector{
+			"volumes":  volumeCollector{"C:"},
+			"counting": counting,
+		},
+		runs:   newCollectorRuns(),
+		cache:  newCollectorCache(nil),
+		status: status,
+	}
+	coll.collect(func(string, prometheus.Metric) {})
+	counting.err = errors.New("access denied")
+	coll.collect(func(string, prometheus.Metric) {})
+
+	// The collectors of a module are recorded apart from the others.
+	module := windowsCollector{
+		maxScrapeDuration: time.Minute,
+		collectors:        map[string]collector.Collector{"counting": &countingCollector{}},
+		runs:              newCollectorRuns(),
+		cache:             newCollectorCache(nil),
+		status:            status,
+		module:            "sql",
+	}
+	module.collect(func(string, prometheus.Metric) {})
+
+	enabled := map[string]map[string]bool{
+		"":    {"volumes": true, "counting": true},
+		"sql": {"counting": true},
+	}
+	list := status.list([]string{"volumes", "counting", "os"}, enabled)
+	if len(list) != 4 || list[0].Name != "counting" || list[1].Name != "os" || list[2].Name != "volumes" || list[3].Module != "sql" {
+		t.Fatalf("expected the collectors sorted by module and name, got %v", list)
+	}
+	if c := list[3]; !c.Enabled || c.Successes != 1 || c.Failures != 0 {
+		t.Errorf("expected a single success of the collector of the module, got %+v", c)
+	}
+	if c := list[0]; !reflect.DeepEqual(c.History, []string{"success", "failure"}) || c.Successes != 1 || c.Failures != 1 ||
+		c.LastError != "access denied" || c.LastErrorTime == nil || c.LastRun == nil {
+		t.Errorf("expected a success followed by a failure, got %+v", c)
+	}
+	if c := list[1]; c.Enabled || c.LastRun != nil || len(c.History) != 0 {
+		t.Errorf("expected a disabled collector which never ran, got %+v", c)
+	}
+	want := []string{"test_value", "test_volume_free_bytes"}
+	if c := list[2]; !c.Enabled || c.Successes != 2 || c.LastError != "" || !reflect.DeepEqual(c.MetricFamilies, want) {
+		t.Errorf("expected two successes with metric families %v, got %+v", want, c)
+	}
+
+	for i := 0; i < statusHistoryLength+5; i++ {
+		status.record("", "os", historyTimeout, time.Now())
+	}
+	list = status.list([]string{"os"}, nil)
+	if c := list[0]; len(c.History) != statusHistoryLength || c.Timeouts != statusHistoryLength+5 || c.LastError != "timed out" {
+		t.Errorf("expected the history to be truncated, got %+v", c)
+	}
+
+	rec := httptest.NewRecorder()
+	serveStatus(func() []collectorStatusJSON { return list })(rec, httptest.NewRequest(http.MethodGet, "/collectors", nil))
+	var served []collectorStatusJSON
+	if err := json.NewDecoder(rec.Body).Decode(&served); err != nil {
+		t.Fatal(err)
+	}
+	if len(served) != 1 || served[0].Name != "os" || served[0].Timeouts != statusHistoryLength+5 {
+		t.Errorf("expected the status of os, got %+v", served)
+	}
+
+	var b strings.Builder
+	if err := writeStatusTable(&b, list); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "<td>os</td><td>no</td>") || !strings.Contains(b.String(), strings.Repeat("t", statusHistoryLength)) {
+		t.Errorf("unexpected table:\n%s", b.String())
+	}
+}
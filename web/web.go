@@ -0,0 +1,349 @@
+// Package web serves HTTP handlers with TLS, client certificate verification
+// and basic authentication, as configured by a web configuration file. The
+// file is reread whenever it, or a certificate it references, changes, so
+// certificates and users can be rotated without restarting the exporter.
+package web
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/prometheus/common/log"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// unknownUserHash is checked against the passwords of unknown users.
+var unknownUserHash = []byte("$2a$10$aojOWTx9NbrOZP.mlxNzzOQ2m8mt0c.VPivdafzY/5QYysQ8pERGq")
+
+// Config is the content of a web configuration file.
+type Config struct {
+	TLSConfig TLSConfig `yaml:"tls_server_config"`
+	// Users maps user names to bcrypt hashes of their passwords.
+	Users map[string]string `yaml:"basic_auth_users"`
+}
+
+// TLSConfig configures the TLS listener. TLS is disabled if no certificate is
+// given.
+type TLSConfig struct {
+	CertFile                 string   `yaml:"cert_file"`
+	KeyFile                  string   `yaml:"key_file"`
+	ClientAuth               string   `yaml:"client_auth_type"`
+	ClientCAFile             string   `yaml:"client_ca_file"`
+	MinVersion               string   `yaml:"min_version"`
+	MaxVersion               string   `yaml:"max_version"`
+	CipherSuites             []string `yaml:"cipher_suites"`
+	PreferServerCipherSuites bool     `yaml:"prefer_server_cipher_suites"`
+}
+
+var tlsVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                           tls.NoClientCert,
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+var cipherSuites = map[string]uint16{
+	"TLS_RSA_WITH_AES_128_CBC_SHA":                  tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":                  tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":               tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":               tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256":       tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384":       tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256":   tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256": tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+}
+
+// LoadConfig reads and validates a web configuration file.
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	if err := yaml.UnmarshalStrict(b, c); err != nil {
+		return nil, fmt.Errorf("failed to parse web config %s: %v", path, err)
+	}
+	if _, err := c.TLSConfig.build(); err != nil {
+		return nil, fmt.Errorf("invalid web config %s: %v", path, err)
+	}
+	for user, hash := range c.Users {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("invalid web config %s: password of user %s is not a bcrypt hash: %v", path, user, err)
+		}
+	}
+	return c, nil
+}
+
+func (c *TLSConfig) enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// build returns the tls.Config described by c, or nil if TLS is disabled.
+func (c *TLSConfig) build() (*tls.Config, error) {
+	if !c.enabled() {
+		if c.ClientCAFile != "" || c.ClientAuth != "" {
+			return nil, errors.New("client certificates require cert_file and key_file")
+		}
+		return nil, nil
+	}
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("both cert_file and key_file must be set")
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %v", err)
+	}
+	cfg := &tls.Config{
+		Certificates:             []tls.Certificate{cert},
+		MinVersion:               tls.VersionTLS12,
+		PreferServerCipherSuites: c.PreferServerCipherSuites,
+	}
+
+	if c.MinVersion != "" {
+		v, ok := tlsVersions[c.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown min_version %q", c.MinVersion)
+		}
+		cfg.MinVersion = v
+	}
+	if c.MaxVersion != "" {
+		v, ok := tlsVersions[c.MaxVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown max_version %q", c.MaxVersion)
+		}
+		cfg.MaxVersion = v
+	}
+	for _, name := range c.CipherSuites {
+		id, ok := cipherSuites[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		cfg.CipherSuites = append(cfg.CipherSuites, id)
+	}
+
+	clientAuth, ok := clientAuthTypes[c.ClientAuth]
+	if !ok {
+		return nil, fmt.Errorf("unknown client_auth_type %q", c.ClientAuth)
+	}
+	cfg.ClientAuth = clientAuth
+	if c.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %v", err)
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA %s", c.ClientCAFile)
+		}
+	} else if clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert {
+		return nil, fmt.Errorf("client_auth_type %s requires client_ca_file", c.ClientAuth)
+	}
+	return cfg, nil
+}
+
+// loadedConfig is a validated configuration, together with the state of the
+// files it was built from.
+type loadedConfig struct {
+	config    *Config
+	tlsConfig *tls.Config
+	files     string
+}
+
+// reloader returns the configuration of a file, reloading it once the file or
+// one of the files it references changes. A configuration which fails to load
+// is logged, and the previous one is kept.
+type reloader struct {
+	path string
+
+	mtx     sync.Mutex
+	current *loadedConfig
+	// Hashes of credentials which passed bcrypt verification, as checking
+	// them is deliberately slow.
+	verified map[[sha256.Size]byte]bool
+}
+
+func newReloader(path string) (*reloader, error) {
+	r := &reloader{path: path}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// fileState returns a summary of the modification times and sizes of the
+// configuration file and the files it references.
+func fileState(paths ...string) string {
+	s := ""
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+		if fi, err := os.Stat(p); err == nil {
+			s += fmt.Sprintf("%s:%d:%d;", p, fi.ModTime().UnixNano(), fi.Size())
+		} else {
+			s += p + ":missing;"
+		}
+	}
+	return s
+}
+
+func (r *reloader) load() error {
+	c, err := LoadConfig(r.path)
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := c.TLSConfig.build()
+	if err != nil {
+		return err
+	}
+	t := c.TLSConfig
+	r.current = &loadedConfig{
+		config:    c,
+		tlsConfig: tlsConfig,
+		files:     fileState(r.path, t.CertFile, t.KeyFile, t.ClientCAFile),
+	}
+	r.verified = make(map[[sha256.Size]byte]bool)
+	return nil
+}
+
+// config returns the current configuration.
+func (r *reloader) config() *loadedConfig {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	t := r.current.config.TLSConfig
+	if fileState(r.path, t.CertFile, t.KeyFile, t.ClientCAFile) == r.current.files {
+		return r.current
+	}
+	previous := r.current
+	if err := r.load(); err != nil {
+		log.Errorf("Failed to reload web config, keeping the previous one: %v", err)
+		// Keep the failed state, so the error is not logged on every request.
+		previous.files = fileState(r.path, t.CertFile, t.KeyFile, t.ClientCAFile)
+		return previous
+	}
+	if r.current.config.TLSConfig.enabled() != previous.config.TLSConfig.enabled() {
+		log.Warnf("Enabling or disabling TLS in %s requires a restart", r.path)
+	}
+	log.Infof("Reloaded web config %s", r.path)
+	return r.current
+}
+
+// authenticated reports whether the request carries the credentials of a
+// configured user. All requests are authenticated if no user is configured.
+func (r *reloader) authenticated(req *http.Request) bool {
+	c := r.config().config
+	if len(c.Users) == 0 {
+		return true
+	}
+	user, pass, ok := req.BasicAuth()
+	if !ok {
+		return false
+	}
+	hash, ok := c.Users[user]
+	if !ok {
+		// Spend the time of a verification anyway, so the response time does
+		// not reveal which users exist.
+		_ = bcrypt.CompareHashAndPassword(unknownUserHash, []byte(pass))
+		return false
+	}
+
+	key := sha256.Sum256([]byte(user + "\x00" + pass + "\x00" + hash))
+	r.mtx.Lock()
+	verified := r.verified[key]
+	r.mtx.Unlock()
+	if verified {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) != nil {
+		return false
+	}
+	r.mtx.Lock()
+	r.verified[key] = true
+	r.mtx.Unlock()
+	return true
+}
+
+func (r *reloader) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !r.authenticated(req) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="windows_exporter"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// ListenAndServe serves server.Handler, or http.DefaultServeMux if nil, on
+// server.Addr. If configPath is set, connections and requests are subject to
+// the TLS settings and users of the web configuration file at that path.
+func ListenAndServe(server *http.Server, configPath string) error {
+	if configPath == "" {
+		return server.ListenAndServe()
+	}
+	r, err := newReloader(configPath)
+	if err != nil {
+		return err
+	}
+	configure(server, r)
+	if server.TLSConfig == nil {
+		log.Warnf("No certificate configured in %s, serving without TLS", configPath)
+		return server.ListenAndServe()
+	}
+	return server.ListenAndServeTLS("", "")
+}
+
+// configure applies the configuration of r to server.
+func configure(server *http.Server, r *reloader) {
+	handler := server.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	server.Handler = r.handler(handler)
+	if r.current.tlsConfig == nil {
+		return
+	}
+	current := func() (*tls.Config, error) {
+		if c := r.config().tlsConfig; c != nil {
+			return c, nil
+		}
+		return nil, errors.New("TLS was disabled in the web config")
+	}
+	server.TLSConfig = &tls.Config{
+		// Resolve the configuration on every handshake, so reloads apply to
+		// new connections.
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return current()
+		},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			c, err := current()
+			if err != nil {
+				return nil, err
+			}
+			return &c.Certificates[0], nil
+		},
+	}
+}
@@ -0,0 +1,247 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Hash of the password "secret".
+const secretHash = "$2a$04$szs/XlcdtUI9WFZ1kVljHe.kNVbPcddOWMQHowErlCIwnTOIevyTK"
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	tls  tls.Certificate
+}
+
+// newTestCert returns a certificate signed by parent, or a self-signed CA
+// certificate if parent is nil.
+func newTestCert(t *testing.T, parent *testCert, serial int64) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: fmt.Sprintf("test %d", serial)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, tls: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}}
+}
+
+// write stores the certificate and key as PEM files in dir.
+func (c *testCert) write(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath := filepath.Join(dir, name+".crt")
+	keyPath := filepath.Join(dir, name+".key")
+	writeFile(t, certPath, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})))
+	writeFile(t, keyPath, string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})))
+	return certPath, keyPath
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "windows_exporter_web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// serve starts a server configured by the web config at path, and returns its
+// URL and the server.
+func serve(t *testing.T, path string) (string, *http.Server) {
+	t.Helper()
+	r, err := newReloader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "ok")
+	})}
+	configure(server, r)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.TLSConfig == nil {
+		go server.Serve(ln)
+		return "http://" + ln.Addr().String(), server
+	}
+	go server.ServeTLS(ln, "", "")
+	return "https://" + ln.Addr().String(), server
+}
+
+func get(client *http.Client, url, user, pass string) (int, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	if user != "" {
+		req.SetBasicAuth(user, pass)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func TestBasicAuthReload(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "web.yml")
+	writeFile(t, path, "basic_auth_users:\n  alice: "+secretHash+"\n")
+	url, server := serve(t, path)
+	defer server.Close()
+
+	cases := []struct {
+		user, pass string
+		expected   int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"alice", "wrong", http.StatusUnauthorized},
+		{"bob", "secret", http.StatusUnauthorized},
+		{"alice", "secret", http.StatusOK},
+		{"alice", "secret", http.StatusOK},
+	}
+	for _, tc := range cases {
+		code, err := get(http.DefaultClient, url, tc.user, tc.pass)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tc.expected {
+			t.Errorf("expected status %d for user %q, got %d", tc.expected, tc.user, code)
+		}
+	}
+
+	// Replace the user, and make sure the change in size is noticed even if
+	// the modification time is too coarse.
+	writeFile(t, path, "basic_auth_users:\n  robert: "+secretHash+"\n")
+	if code, _ := get(http.DefaultClient, url, "alice", "secret"); code != http.StatusUnauthorized {
+		t.Errorf("expected the removed user to be rejected, got %d", code)
+	}
+	if code, _ := get(http.DefaultClient, url, "robert", "secret"); code != http.StatusOK {
+		t.Errorf("expected the added user to be accepted, got %d", code)
+	}
+
+	// An invalid file keeps the previous configuration.
+	writeFile(t, path, "basic_auth_users: [\n")
+	if code, _ := get(http.DefaultClient, url, "robert", "secret"); code != http.StatusOK {
+		t.Errorf("expected the previous configuration to be kept, got %d", code)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ca := newTestCert(t, nil, 1)
+	caPath, _ := ca.write(t, dir, "ca")
+	certPath, keyPath := newTestCert(t, ca, 2).write(t, dir, "server")
+	client := newTestCert(t, ca, 3)
+	otherClient := newTestCert(t, newTestCert(t, nil, 4), 5)
+
+	path := filepath.Join(dir, "web.yml")
+	writeFile(t, path, fmt.Sprintf(`tls_server_config:
+  cert_file: %s
+  key_file: %s
+  client_ca_file: %s
+  client_auth_type: RequireAndVerifyClientCert
+  min_version: TLS12
+`, certPath, keyPath, caPath))
+	url, server := serve(t, path)
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	newClient := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: certs,
+		}}}
+	}
+
+	if code, err := get(newClient(client.tls), url, "", ""); err != nil || code != http.StatusOK {
+		t.Errorf("expected a client certificate signed by the CA to be accepted, got %d, %v", code, err)
+	}
+	if _, err := get(newClient(), url, "", ""); err == nil {
+		t.Error("expected a client without certificate to be rejected")
+	}
+	if _, err := get(newClient(otherClient.tls), url, "", ""); err == nil {
+		t.Error("expected a client certificate signed by another CA to be rejected")
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ca := newTestCert(t, nil, 1)
+	certPath, keyPath := ca.write(t, dir, "server")
+
+	cases := []string{
+		"unknown_key: true\n",
+		"basic_auth_users:\n  alice: plaintext\n",
+		fmt.Sprintf("tls_server_config:\n  cert_file: %s\n", certPath),
+		fmt.Sprintf("tls_server_config:\n  cert_file: %s\n  key_file: %s\n  min_version: SSL3\n", certPath, keyPath),
+		fmt.Sprintf("tls_server_config:\n  cert_file: %s\n  key_file: %s\n  cipher_suites: [TLS_FOO]\n", certPath, keyPath),
+		fmt.Sprintf("tls_server_config:\n  cert_file: %s\n  key_file: %s\n  client_auth_type: RequireAndVerifyClientCert\n", certPath, keyPath),
+		"tls_server_config:\n  client_auth_type: RequireAnyClientCert\n",
+	}
+	for _, content := range cases {
+		path := filepath.Join(dir, "web.yml")
+		writeFile(t, path, content)
+		if _, err := LoadConfig(path); err == nil {
+			t.Errorf("expected an error for config:\n%s", content)
+		}
+	}
+
+	if _, err := LoadConfig(filepath.Join(dir, "missing.yml")); !os.IsNotExist(err) {
+		t.Errorf("expected a missing file error, got %v", err)
+	}
+}